@@ -2,13 +2,24 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+//go:build cgo
+// +build cgo
+
 package webp
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
 	"io"
+	"log"
+	"math"
+	"runtime"
+	"sync"
 )
 
 // Constants for animation disposal and blending modes.
@@ -30,10 +41,39 @@ const (
 	BlendModeNoBlend = 1
 )
 
+// maxFrameDuration is the longest frame duration the WebP format can store,
+// in milliseconds.
+const maxFrameDuration = 1<<24 - 1
+
+// maxLoopCount is the largest loop count the WebP format can store.
+const maxLoopCount = 1<<16 - 1
+
+// Constants for the handling of odd frame offsets, which the WebP format
+// cannot store.
+const (
+	// OddOffsetRoundDown rounds odd offsets down to even values, which shifts
+	// the frame left or up by one pixel.
+	OddOffsetRoundDown = 0
+
+	// OddOffsetError rejects frames at odd offsets.
+	OddOffsetError = 1
+
+	// OddOffsetPad extends frames at odd offsets by a transparent column or row
+	// on the left or top, so they are drawn at the exact position. The padding
+	// only leaves the canvas unchanged with BlendModeBlend and DisposeModeNone,
+	// so frames at odd offsets using other modes are rejected.
+	OddOffsetPad = 2
+)
+
 // AnimationEncoder encodes animated WebP images.
 // It provides methods for adding frames, setting animation parameters,
 // and encoding the final animation.
 //
+// Each frame is compressed as soon as it is added and its source image is not
+// retained, but the compressed frames are all held in memory until the
+// animation is assembled, and the assembly holds a second copy. For very long
+// animations, AnimationWriter writes the frames to a file as they are added.
+//
 // Usage:
 //
 //	enc := webp.NewAnimationEncoder()
@@ -48,6 +88,44 @@ const (
 //	enc.Encode(outputFile)
 type AnimationEncoder struct {
 	mux *WebPMux
+
+	// canvasWidth and canvasHeight are the canvas dimensions, set by
+	// SetAnimationParams or established by the first frame added.
+	canvasWidth, canvasHeight int
+
+	// oddOffsets is the handling of odd frame offsets, set by SetAnimationParams.
+	oddOffsets int
+
+	// defaultDuration is the duration of frames without one, set by
+	// SetAnimationParams.
+	defaultDuration int
+
+	// keyframeInterval is the interval of the frames turned into keyframes,
+	// set by SetAnimationParams.
+	keyframeInterval int
+
+	// frameCount is the number of frames added.
+	frameCount int
+
+	// loopCount and backgroundColor are the parameters of the ANIM chunk,
+	// set by SetAnimationParams, SetLoopCount and SetBackgroundColor.
+	loopCount       int
+	backgroundColor uint32
+
+	// defaultDispose and defaultBlend are the modes of the frames added with
+	// zero modes, set by SetDefaultDispose and SetDefaultBlend.
+	defaultDispose, defaultBlend int
+
+	// featureFlags replaces the VP8X flags inferred by the mux when not zero,
+	// set by SetFeatureFlags.
+	featureFlags uint32
+
+	// optimizeSubframes enables the cropping of frames to their changes, set
+	// by SetAnimationParams. canvas is the canvas left by the last frame
+	// added when it can be compared with the next frame, and pending the one
+	// left by the frame being added.
+	optimizeSubframes bool
+	canvas, pending   *image.RGBA
 }
 
 // AnimationParams contains parameters for an animated WebP image.
@@ -56,9 +134,39 @@ type AnimationParams struct {
 	// For example, 0xFFFFFFFF for white, 0xFF000000 for black, 0x00000000 for transparent.
 	BackgroundColor uint32
 
-	// LoopCount is the number of times to repeat the animation.
-	// 0 means infinite loop.
+	// LoopCount is the number of times to repeat the animation, up to 65535.
+	// 0 means infinite loop. See SetLoopCount to set it after adding frames.
 	LoopCount int
+
+	// CanvasWidth and CanvasHeight are the dimensions of the canvas the frames
+	// are placed on. When zero, the canvas is derived from the first frame.
+	// Either both or neither must be set.
+	CanvasWidth  int
+	CanvasHeight int
+
+	// OddOffsets determines how frames at odd offsets are handled. Use
+	// OddOffsetRoundDown, OddOffsetError or OddOffsetPad.
+	OddOffsets int
+
+	// DefaultFrameDuration is the duration in milliseconds given to the frames
+	// added with a zero Duration. When zero, such frames keep a zero duration.
+	DefaultFrameDuration int
+
+	// KeyframeInterval makes every KeyframeInterval-th frame, from the first,
+	// a keyframe with DisposeModeBackground and BlendModeNoBlend, which does
+	// not depend on the previous frames. Zero disables it.
+	KeyframeInterval int
+
+	// OptimizeSubframes crops each frame covering the canvas down to the box
+	// of the pixels that differ from the previous frame, placed at its even
+	// offset with BlendModeNoBlend. It shrinks animations of small changes,
+	// such as screen recordings.
+	//
+	// A frame is only cropped when it is DisposeModeNone and BlendModeNoBlend
+	// or opaque, and the previous frame covered the canvas with
+	// DisposeModeNone and was BlendModeNoBlend, opaque or the first frame.
+	// Keyframes are never cropped.
+	OptimizeSubframes bool
 }
 
 // Frame represents a single frame in an animated WebP image.
@@ -69,54 +177,441 @@ type Frame struct {
 	Image image.Image
 
 	// X is the x-offset of the frame within the canvas.
-	// The WebP format requires even offsets, so odd values will be rounded down.
+	// The WebP format requires even offsets, so by default odd values are
+	// rounded down and the frame is drawn one pixel to the left, see
+	// AnimationParams.OddOffsets.
 	X int
 
 	// Y is the y-offset of the frame within the canvas.
-	// The WebP format requires even offsets, so odd values will be rounded down.
+	// The WebP format requires even offsets, so by default odd values are
+	// rounded down and the frame is drawn one pixel higher, see
+	// AnimationParams.OddOffsets.
 	Y int
 
-	// Duration is the display duration of the frame in milliseconds.
+	// Duration is the display duration of the frame in milliseconds, up to
+	// 16777215. A zero duration is replaced by the DefaultFrameDuration of the
+	// animation, if set. Otherwise it is stored as is, and viewers differ in
+	// how they play it: browsers typically show such frames for about 100ms,
+	// while other viewers show them for as short as they can.
 	Duration int
 
 	// DisposeMode determines how the area used by the current frame is treated
 	// before rendering the next frame. Use DisposeModeNone or DisposeModeBackground.
+	// Zero takes the default of the encoder, DisposeModeNone unless set with
+	// SetDefaultDispose, unless DisposeModeSet is true.
 	DisposeMode int
 
+	// DisposeModeSet makes DisposeMode apply as is, so that DisposeModeNone
+	// is kept regardless of the default set with SetDefaultDispose.
+	DisposeModeSet bool
+
 	// BlendMode determines how transparent pixels of the current frame are blended
 	// with those of the previous canvas. Use BlendModeBlend or BlendModeNoBlend.
+	// Zero takes the default of the encoder, BlendModeBlend unless set with
+	// SetDefaultBlend, unless BlendModeSet is true.
 	BlendMode int
+
+	// BlendModeSet makes BlendMode apply as is, so that BlendModeBlend is
+	// kept regardless of the default set with SetDefaultBlend.
+	BlendModeSet bool
+
+	// Lossless encodes the frame with the lossless (VP8L) encoder instead of
+	// the lossy (VP8) one, so the pixels are preserved exactly. Lossy and
+	// lossless frames can be mixed in the same animation.
+	//
+	// Frames with an *image.Paletted image, such as GIF frames or cartoons,
+	// are encoded losslessly when added without options, as the lossless
+	// encoder stores their palette as is, which is usually much smaller
+	// than lossy RGB.
+	Lossless bool
+}
+
+// Clone returns a copy of the frame with a deep copy of its image, which is
+// independent of later changes to the original, such as a draw buffer reused
+// for every frame. It is needed only to keep frames before adding them, as
+// AddFrame encodes the image before it returns.
+//
+// The copy has the same bounds and, for the image types of the standard
+// library, the same type, so a *image.Paletted frame stays lossless by
+// default. Other images are copied to an *image.RGBA.
+func (f Frame) Clone() Frame {
+	f.Image = cloneImage(f.Image)
+	return f
+}
+
+// cloneImage returns a deep copy of the image m.
+func cloneImage(m image.Image) image.Image {
+	switch m := m.(type) {
+	case nil:
+		return nil
+	case *image.RGBA:
+		c := *m
+		c.Pix = append([]byte(nil), m.Pix...)
+		return &c
+	case *image.NRGBA:
+		c := *m
+		c.Pix = append([]byte(nil), m.Pix...)
+		return &c
+	case *image.Gray:
+		c := *m
+		c.Pix = append([]byte(nil), m.Pix...)
+		return &c
+	case *image.Paletted:
+		c := *m
+		c.Pix = append([]byte(nil), m.Pix...)
+		c.Palette = append(color.Palette(nil), m.Palette...)
+		return &c
+	case *image.YCbCr:
+		c := *m
+		c.Y = append([]byte(nil), m.Y...)
+		c.Cb = append([]byte(nil), m.Cb...)
+		c.Cr = append([]byte(nil), m.Cr...)
+		return &c
+	}
+	c := image.NewRGBA(m.Bounds())
+	draw.Draw(c, c.Rect, m, c.Rect.Min, draw.Src)
+	return c
+}
+
+// RawFrame is a frame of an animated WebP image in its compressed form, as
+// returned by AnimationDecoder.RawFrames and added by AddRawFrame, so frames
+// can be copied between animations without decoding and re-encoding them.
+type RawFrame struct {
+	// Data is the frame as a still WebP image. Its VP8 or VP8L bitstream,
+	// and its ALPH chunk if any, are stored as in the animation.
+	Data []byte
+
+	// X and Y are the offsets of the frame within the canvas.
+	X, Y int
+
+	// Width and Height are the dimensions of the frame.
+	Width, Height int
+
+	// Duration, DisposeMode and BlendMode are as in Frame.
+	Duration    int
+	DisposeMode int
+	BlendMode   int
+
+	// Format is the bitstream format of the frame, FormatLossy for a VP8
+	// chunk or FormatLossless for a VP8L chunk. It is informational only,
+	// AddRawFrame reads the format from Data.
+	Format int
 }
 
 // NewAnimationEncoder creates a new AnimationEncoder.
 // The returned encoder must be closed with Close() when no longer needed
 // to avoid memory leaks.
+//
+// As a safety net, an encoder garbage collected without Close releases its
+// memory and logs a warning. Do not rely on it: the collector only runs when
+// the Go heap grows, regardless of the native memory held by the encoders.
 func NewAnimationEncoder() *AnimationEncoder {
-	return &AnimationEncoder{
+	enc := &AnimationEncoder{
 		mux: webpAnimCreate(),
 	}
+	runtime.SetFinalizer(enc, finalizeAnimationEncoder)
+	return enc
+}
+
+// finalizeAnimationEncoder releases the memory of an encoder garbage
+// collected without Close.
+func finalizeAnimationEncoder(enc *AnimationEncoder) {
+	if enc.mux != nil {
+		log.Print("webp: AnimationEncoder garbage collected without Close, call Close to release its memory")
+		enc.Close()
+	}
 }
 
 // AddFrame adds a frame to the animation.
 //
-// The frame's image is encoded as a WebP image and added to the animation.
+// The frame's image is encoded as a WebP image and added to the animation
+// before AddFrame returns, so the image may be modified or reused for the next
+// frame as soon as it returns. Use Frame.Clone to keep frames for later.
 // Frames are displayed in the order they are added, with the specified duration,
 // position, and blending options.
 //
-// Returns an error if the encoder is closed or if the frame cannot be added.
+// The frame is encoded with the default quality (DefaulQuality), or losslessly
+// for an *image.Paletted image. Use AddFrameWithQuality or AddFrameWithOptions
+// to choose the encoding parameters for each frame.
+//
+// Every frame must fit within the canvas. Unless the canvas size is set with
+// SetAnimationParams, the first frame establishes it as its offset plus its size.
+//
+// Returns an error if the encoder is closed, if the duration or the dispose or
+// blend mode of the frame is invalid, if the frame is larger than MaxDimension
+// or does not fit the canvas, or if the frame cannot be added.
 func (enc *AnimationEncoder) AddFrame(frame Frame) error {
+	return enc.AddFrameWithOptions(frame, nil)
+}
+
+// AddFrameWithQuality adds a frame to the animation, encoding its image with
+// the given quality. The quality must be in the range 0 ~ 100, where 0 gives
+// the smallest size and 100 the best quality. The quality is ignored for
+// lossless frames.
+//
+// Returns an error if the encoder is closed, if the quality is out of range
+// or if the frame cannot be added.
+func (enc *AnimationEncoder) AddFrameWithQuality(frame Frame, quality float32) error {
+	return enc.AddFrameWithOptions(frame, &Options{Quality: quality})
+}
+
+// AddFrameWithOptions adds a frame to the animation, encoding its image with
+// the given options. A nil opt uses the default quality (DefaulQuality).
+// The frame is encoded losslessly if either frame.Lossless or opt.Lossless
+// is set. The metadata fields of opt are ignored, use SetEXIF and SetXMP to
+// attach metadata to the animation.
+//
+// Returns an error if the encoder is closed, if the options or the duration,
+// dispose or blend mode of the frame are invalid, if the frame does not fit
+// the canvas or if the frame cannot be added.
+func (enc *AnimationEncoder) AddFrameWithOptions(frame Frame, opt *Options) error {
 	if enc.mux == nil {
-		return errors.New("animation encoder is closed")
+		return ErrEncoderClosed
+	}
+	frame, err := enc.prepareFrame(frame)
+	if err != nil {
+		return err
 	}
 
 	// Encode the image to WebP
-	var data []byte
-	var err error
-	if m, ok := frame.Image.(*image.RGBA); ok {
-		data, err = EncodeRGBA(m, 90)
+	data, err := encodeImage(frame.Image, frameOptions(frame, opt))
+	if err != nil {
+		return err
+	}
+	return enc.addEncodedFrame(frame, data)
+}
+
+// AddRawFrame adds an already compressed frame to the animation, such as one
+// returned by AnimationDecoder.RawFrames. The frame data is added as is,
+// without being decoded, and its size is read from the data.
+//
+// Returns an error if the encoder is closed, if the data is not a still WebP
+// image, if the frame does not fit the canvas or if the frame cannot be added.
+func (enc *AnimationEncoder) AddRawFrame(frame RawFrame) error {
+	if enc.mux == nil {
+		return ErrEncoderClosed
+	}
+	enc.pending = nil
+	if _, _, _, animated, err := parseHeader(frame.Data); err == nil && animated {
+		return errors.New("webp: invalid raw frame data, an animation instead of a still image")
+	}
+	width, height, _, err := webpGetInfo(frame.Data)
+	if err != nil {
+		return errors.New("webp: invalid raw frame data")
+	}
+	return enc.addEncodedFrame(Frame{
+		Image:       image.Rect(0, 0, width, height),
+		X:           frame.X,
+		Y:           frame.Y,
+		Duration:    frame.Duration,
+		DisposeMode: frame.DisposeMode,
+		BlendMode:   frame.BlendMode,
+	}, frame.Data)
+}
+
+// AddEncodedFrame adds a frame already encoded as the still WebP image data,
+// such as one kept in a cache, at offset (x, y) of the canvas, for the
+// duration in milliseconds and with the dispose and blend modes. The data is
+// added as is, like with AddRawFrame, avoiding the decoding and the lossy
+// re-encoding of AddFrame.
+//
+// Returns an error if the encoder is closed, if the data is not a still WebP
+// image, if the frame does not fit the canvas or if the frame cannot be added.
+func (enc *AnimationEncoder) AddEncodedFrame(data []byte, x, y, duration, dispose, blend int) error {
+	return enc.AddRawFrame(RawFrame{
+		Data:        data,
+		X:           x,
+		Y:           y,
+		Duration:    duration,
+		DisposeMode: dispose,
+		BlendMode:   blend,
+	})
+}
+
+// AppendAnimation adds the frames of the encoded animation data to the
+// animation, with their offsets, durations and dispose and blend modes,
+// without decoding and re-encoding them. Still images are added as a single
+// frame.
+//
+// The canvas of data must have the same size as the canvas of the animation.
+// If the canvas size is not set yet, it is set to the one of data.
+//
+// Returns an error if the encoder is closed, if data cannot be parsed, if the
+// canvas sizes differ or if the frames cannot be added.
+func (enc *AnimationEncoder) AppendAnimation(data []byte) error {
+	if enc.mux == nil {
+		return ErrEncoderClosed
+	}
+	defer runtime.KeepAlive(enc)
+
+	dec, err := NewAnimationDecoder(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+	_, width, height := dec.Info()
+	frames, err := dec.RawFrames()
+	if err != nil {
+		return err
+	}
+
+	if enc.canvasWidth == 0 && enc.canvasHeight == 0 {
+		if webpAnimSetCanvasSize(enc.mux, width, height) != 1 {
+			return fmt.Errorf("webp: invalid canvas size %dx%d", width, height)
+		}
+		enc.canvasWidth, enc.canvasHeight = width, height
+	} else if width != enc.canvasWidth || height != enc.canvasHeight {
+		return fmt.Errorf("webp: cannot append a %dx%d animation to the %dx%d canvas",
+			width, height, enc.canvasWidth, enc.canvasHeight)
+	}
+
+	for _, frame := range frames {
+		if err := enc.AddRawFrame(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// frameOptions returns the options to encode the frame with: opt without the
+// metadata, lossless if the frame asks for it. Without options, paletted
+// images are encoded losslessly, hinted as graphics.
+func frameOptions(frame Frame, opt *Options) *Options {
+	frameOpt := Options{Quality: DefaulQuality}
+	if opt != nil {
+		frameOpt = *opt
+		frameOpt.ICCProfile, frameOpt.EXIF, frameOpt.XMP = nil, nil, nil
+		frameOpt.ColorSpace = ColorSpaceDefault
+	} else if _, ok := frame.Image.(*image.Paletted); ok {
+		frameOpt = Options{Lossless: true, ImageHint: ImageHintGraph}
+	}
+	if frame.Lossless {
+		frameOpt.Lossless = true
+	}
+	return &frameOpt
+}
+
+// alignFrame returns the frame to add for frame according to the handling
+// of odd offsets.
+func (enc *AnimationEncoder) alignFrame(frame Frame) (Frame, error) {
+	if frame.X%2 == 0 && frame.Y%2 == 0 || enc.oddOffsets == OddOffsetRoundDown {
+		return frame, nil
+	}
+	if enc.oddOffsets == OddOffsetError {
+		return frame, fmt.Errorf("webp: invalid frame offset (%d, %d), must be even", frame.X, frame.Y)
+	}
+	if frame.BlendMode != BlendModeBlend || frame.DisposeMode != DisposeModeNone {
+		return frame, fmt.Errorf("webp: frame at odd offset (%d, %d) cannot be padded, it must use BlendModeBlend and DisposeModeNone", frame.X, frame.Y)
+	}
+	if frame.X < 0 || frame.Y < 0 {
+		return frame, nil // rejected by checkFrameBounds
+	}
+
+	// Pad the frame with transparent pixels up to the even offset
+	b := frame.Image.Bounds()
+	padX, padY := frame.X%2, frame.Y%2
+	m := image.NewRGBA(image.Rect(0, 0, b.Dx()+padX, b.Dy()+padY))
+	draw.Draw(m, m.Bounds().Add(image.Pt(padX, padY)), frame.Image, b.Min, draw.Src)
+	frame.Image = m
+	frame.X, frame.Y = frame.X-padX, frame.Y-padY
+	return frame, nil
+}
+
+// prepareFrame returns the frame to encode for frame, with its modes, offsets
+// and bounds checked and resolved.
+func (enc *AnimationEncoder) prepareFrame(frame Frame) (Frame, error) {
+	if err := checkFrame(frame); err != nil {
+		return frame, err
+	}
+	width, height := enc.canvasWidth, enc.canvasHeight
+	if width == 0 && height == 0 && frame.Image != nil {
+		width, height = frameBounds(frame)
+	}
+	frame, enc.pending = enc.subframe(enc.keyframe(enc.frameModes(frame), enc.frameCount), enc.frameCount, enc.canvas, width, height)
+	frame, err := enc.alignFrame(frame)
+	if err != nil {
+		return frame, err
+	}
+	return frame, enc.checkFrameBounds(frame)
+}
+
+// subframe returns the frame to add at the given index, cropped to the
+// pixels that differ from canvas, the canvas left by the previous frame if
+// it is known, when subframe optimization is enabled. It also returns the
+// canvas the frame leaves, if it can be compared with the next frame. The
+// canvas is width x height.
+func (enc *AnimationEncoder) subframe(frame Frame, index int, canvas *image.RGBA, width, height int) (Frame, *image.RGBA) {
+	if !enc.optimizeSubframes || frame.Image == nil || frame.X != 0 || frame.Y != 0 {
+		return frame, nil
+	}
+	b := frame.Image.Bounds()
+	if b.Dx() != width || b.Dy() != height {
+		return frame, nil
+	}
+	m := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(m, m.Rect, frame.Image, b.Min, draw.Src)
+
+	replaces := frame.BlendMode == BlendModeNoBlend || m.Opaque()
+	next := m
+	if frame.DisposeMode != DisposeModeNone || !replaces && index > 0 {
+		next = nil
+	}
+	// A frame disposed to the background is kept whole, so the decoder
+	// clears the whole canvas after it, not only the changed pixels.
+	keyframe := enc.keyframeInterval > 0 && index%enc.keyframeInterval == 0
+	if canvas == nil || !replaces || keyframe || frame.DisposeMode != DisposeModeNone {
+		return frame, next
+	}
+
+	// A frame identical to the previous one still needs a pixel to hold
+	// its duration.
+	r := diffBounds(canvas, m)
+	if r.Empty() {
+		r = image.Rect(0, 0, 1, 1)
+	}
+	r.Min.X, r.Min.Y = r.Min.X&^1, r.Min.Y&^1
+	if r == m.Rect {
+		return frame, next
+	}
+	if s, ok := frame.Image.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		frame.Image = s.SubImage(r.Add(b.Min))
 	} else {
-		data, err = EncodeRGBA(toRGBAImage(frame.Image), 90)
+		frame.Image = m.SubImage(r)
 	}
+	frame.X, frame.Y = r.Min.X, r.Min.Y
+	frame.BlendMode = BlendModeNoBlend
+	return frame, next
+}
+
+// diffBounds returns the bounding box of the pixels that differ between a
+// and b, which have the same bounds, or an empty rectangle if none does.
+func diffBounds(a, b *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	width := 4 * a.Rect.Dx()
+	for y := 0; y < a.Rect.Dy(); y++ {
+		rowA := a.Pix[y*a.Stride : y*a.Stride+width]
+		rowB := b.Pix[y*b.Stride : y*b.Stride+width]
+		if bytes.Equal(rowA, rowB) {
+			continue
+		}
+		left, right := 0, width
+		for rowA[left] == rowB[left] {
+			left++
+		}
+		for rowA[right-1] == rowB[right-1] {
+			right--
+		}
+		r = r.Union(image.Rect(left/4, y, (right+3)/4, y+1))
+	}
+	return r.Add(a.Rect.Min)
+}
+
+// addEncodedFrame adds the frame, already encoded as data, to the mux.
+func (enc *AnimationEncoder) addEncodedFrame(frame Frame, data []byte) error {
+	defer runtime.KeepAlive(enc)
+	frame, err := enc.placeFrame(frame)
 	if err != nil {
 		return err
 	}
@@ -127,31 +622,298 @@ func (enc *AnimationEncoder) AddFrame(frame Frame) error {
 
 	// Add the frame to the mux
 	if webpAnimPushFrame(enc.mux, &frameInfo, 1) != 1 {
-		return errors.New("failed to add frame to animation")
+		return errors.New("webp: failed to add frame to animation")
+	}
+	enc.frameAdded(frame)
+	return nil
+}
+
+// placeFrame returns the frame to store for an encoded frame, with its
+// offsets and bounds checked and its default duration applied.
+func (enc *AnimationEncoder) placeFrame(frame Frame) (Frame, error) {
+	if err := checkFrame(frame); err != nil {
+		return frame, err
+	}
+	frame = enc.keyframe(frame, enc.frameCount)
+	if enc.oddOffsets != OddOffsetRoundDown && (frame.X%2 != 0 || frame.Y%2 != 0) {
+		return frame, fmt.Errorf("webp: invalid frame offset (%d, %d), must be even", frame.X, frame.Y)
+	}
+	if err := enc.checkFrameBounds(frame); err != nil {
+		return frame, err
+	}
+
+	if frame.Duration == 0 {
+		frame.Duration = enc.defaultDuration
+	}
+	return frame, nil
+}
+
+// frameAdded counts the frame stored, and establishes the canvas from the
+// first frame if not set.
+func (enc *AnimationEncoder) frameAdded(frame Frame) {
+	enc.canvas, enc.pending = enc.pending, nil
+	enc.frameCount++
+	if enc.canvasWidth == 0 && enc.canvasHeight == 0 {
+		enc.canvasWidth, enc.canvasHeight = frameBounds(frame)
+	}
+}
+
+// frameModes returns the frame with its zero dispose and blend modes replaced
+// by the defaults of the encoder, unless they are set explicitly.
+func (enc *AnimationEncoder) frameModes(frame Frame) Frame {
+	if frame.DisposeMode == 0 && !frame.DisposeModeSet {
+		frame.DisposeMode = enc.defaultDispose
 	}
+	if frame.BlendMode == 0 && !frame.BlendModeSet {
+		frame.BlendMode = enc.defaultBlend
+	}
+	return frame
+}
+
+// keyframe returns the frame to add at the given index, turned into a keyframe
+// if it falls on the keyframe interval.
+func (enc *AnimationEncoder) keyframe(frame Frame, index int) Frame {
+	if enc.keyframeInterval > 0 && index%enc.keyframeInterval == 0 {
+		frame.DisposeMode, frame.BlendMode = DisposeModeBackground, BlendModeNoBlend
+	}
+	return frame
+}
 
+// checkFrame checks that the frame has an image, and that the duration, the
+// dispose and blend modes and the size of the frame are valid.
+func checkFrame(frame Frame) error {
+	if frame.Image == nil {
+		return errors.New("webp: frame has no image")
+	}
+	if frame.Duration < 0 || frame.Duration > maxFrameDuration {
+		return fmt.Errorf("webp: invalid frame duration %d, must be in the range 0 ~ %d", frame.Duration, maxFrameDuration)
+	}
+	if err := checkDisposeMode(frame.DisposeMode); err != nil {
+		return err
+	}
+	if err := checkBlendMode(frame.BlendMode); err != nil {
+		return err
+	}
+	return checkDimensions(frame.Image.Bounds())
+}
+
+// checkDisposeMode checks that the dispose mode of a frame is valid.
+func checkDisposeMode(mode int) error {
+	switch mode {
+	case DisposeModeNone, DisposeModeBackground:
+		return nil
+	}
+	return fmt.Errorf("webp: invalid dispose mode %d, must be DisposeModeNone or DisposeModeBackground", mode)
+}
+
+// checkBlendMode checks that the blend mode of a frame is valid.
+func checkBlendMode(mode int) error {
+	switch mode {
+	case BlendModeBlend, BlendModeNoBlend:
+		return nil
+	}
+	return fmt.Errorf("webp: invalid blend mode %d, must be BlendModeBlend or BlendModeNoBlend", mode)
+}
+
+// checkFrameBounds checks that the frame fits within the canvas.
+func (enc *AnimationEncoder) checkFrameBounds(frame Frame) error {
+	if frame.X < 0 || frame.Y < 0 {
+		return fmt.Errorf("webp: invalid frame offset (%d, %d), must not be negative", frame.X, frame.Y)
+	}
+	if enc.canvasWidth == 0 && enc.canvasHeight == 0 {
+		return nil
+	}
+	if right, bottom := frameBounds(frame); right > enc.canvasWidth || bottom > enc.canvasHeight {
+		b := frame.Image.Bounds()
+		return fmt.Errorf("webp: frame %dx%d at offset (%d, %d) does not fit the %dx%d canvas",
+			b.Dx(), b.Dy(), frame.X, frame.Y, enc.canvasWidth, enc.canvasHeight)
+	}
 	return nil
 }
 
+// frameBounds returns the right and bottom edges of the frame on the canvas.
+// The offsets are rounded down to even values, as stored in the file.
+func frameBounds(frame Frame) (right, bottom int) {
+	b := frame.Image.Bounds()
+	return frame.X&^1 + b.Dx(), frame.Y&^1 + b.Dy()
+}
+
 // SetAnimationParams sets the animation parameters.
 //
-// This should be called before adding frames to set the background color and
-// loop count for the animation.
+// The canvas size, the odd offset handling, the default frame duration and
+// the keyframe interval apply to the frames added afterwards, so this should
+// be called before adding frames. The loop count and the background color are
+// only written when the animation is assembled, and can be changed at any
+// time with SetLoopCount and SetBackgroundColor; the final call wins.
 //
 // Returns an error if the encoder is closed or if the parameters cannot be set.
 func (enc *AnimationEncoder) SetAnimationParams(params AnimationParams) error {
 	if enc.mux == nil {
-		return errors.New("animation encoder is closed")
+		return ErrEncoderClosed
+	}
+	defer runtime.KeepAlive(enc)
+	switch params.OddOffsets {
+	case OddOffsetRoundDown, OddOffsetError, OddOffsetPad:
+	default:
+		return fmt.Errorf("webp: invalid odd offset handling %d", params.OddOffsets)
+	}
+	if params.DefaultFrameDuration < 0 || params.DefaultFrameDuration > maxFrameDuration {
+		return fmt.Errorf("webp: invalid default frame duration %d, must be in the range 0 ~ %d", params.DefaultFrameDuration, maxFrameDuration)
+	}
+	if params.KeyframeInterval < 0 {
+		return fmt.Errorf("webp: invalid keyframe interval %d, must not be negative", params.KeyframeInterval)
+	}
+
+	// Set the canvas size
+	if params.CanvasWidth != 0 || params.CanvasHeight != 0 {
+		if webpAnimSetCanvasSize(enc.mux, params.CanvasWidth, params.CanvasHeight) != 1 {
+			return fmt.Errorf("webp: invalid canvas size %dx%d", params.CanvasWidth, params.CanvasHeight)
+		}
+		enc.canvasWidth, enc.canvasHeight = params.CanvasWidth, params.CanvasHeight
+	}
+
+	if err := enc.setAnimParams(params.LoopCount, params.BackgroundColor); err != nil {
+		return err
+	}
+	enc.oddOffsets = params.OddOffsets
+	enc.defaultDuration = params.DefaultFrameDuration
+	enc.keyframeInterval = params.KeyframeInterval
+	enc.optimizeSubframes = params.OptimizeSubframes
+
+	return nil
+}
+
+// SetDefaultDispose sets the dispose mode of the frames added afterwards with
+// a zero DisposeMode, DisposeModeNone or DisposeModeBackground, so a uniform
+// mode need not be set on every frame. Frames with DisposeModeSet keep their
+// DisposeMode. It does not apply to AddRawFrame, whose frames keep the
+// modes they were stored with.
+//
+// Returns an error if the encoder is closed or if the mode is invalid.
+func (enc *AnimationEncoder) SetDefaultDispose(mode int) error {
+	if enc.mux == nil {
+		return ErrEncoderClosed
+	}
+	if mode != DisposeModeNone && mode != DisposeModeBackground {
+		return fmt.Errorf("webp: invalid dispose mode %d, must be DisposeModeNone or DisposeModeBackground", mode)
+	}
+	enc.defaultDispose = mode
+	return nil
+}
+
+// SetDefaultBlend sets the blend mode of the frames added afterwards with a
+// zero BlendMode, BlendModeBlend or BlendModeNoBlend, like SetDefaultDispose.
+// Frames with BlendModeSet keep their BlendMode.
+//
+// Returns an error if the encoder is closed or if the mode is invalid.
+func (enc *AnimationEncoder) SetDefaultBlend(mode int) error {
+	if enc.mux == nil {
+		return ErrEncoderClosed
+	}
+	if mode != BlendModeBlend && mode != BlendModeNoBlend {
+		return fmt.Errorf("webp: invalid blend mode %d, must be BlendModeBlend or BlendModeNoBlend", mode)
 	}
+	enc.defaultBlend = mode
+	return nil
+}
 
-	// Create a WebPMuxAnimParams structure
-	animParams := webpMuxAnimParamsCreate(params.BackgroundColor, params.LoopCount)
+// SetLoopCount sets the number of times the animation is played, 0 meaning
+// forever, up to 65535. Unlike the other animation parameters, it can be set
+// at any time before the animation is assembled by Encode, WriteTo or Bytes,
+// such as after inspecting the frames. The final call, of SetLoopCount or
+// SetAnimationParams, wins.
+//
+// Returns an error if the encoder is closed or if the loop count is invalid.
+func (enc *AnimationEncoder) SetLoopCount(n int) error {
+	if enc.mux == nil {
+		return ErrEncoderClosed
+	}
+	return enc.setAnimParams(n, enc.backgroundColor)
+}
 
-	// Set the animation parameters
+// SetBackgroundColor sets the background color of the canvas as ARGB, see
+// AnimationParams.BackgroundColor. Like SetLoopCount, it can be set at any
+// time before the animation is assembled, and the final call wins.
+//
+// Returns an error if the encoder is closed.
+func (enc *AnimationEncoder) SetBackgroundColor(color uint32) error {
+	if enc.mux == nil {
+		return ErrEncoderClosed
+	}
+	return enc.setAnimParams(enc.loopCount, color)
+}
+
+// setAnimParams sets the loop count and background color of the mux.
+func (enc *AnimationEncoder) setAnimParams(loopCount int, backgroundColor uint32) error {
+	if loopCount < 0 || loopCount > maxLoopCount {
+		return fmt.Errorf("webp: invalid loop count %d, must be in the range 0 ~ %d", loopCount, maxLoopCount)
+	}
+	defer runtime.KeepAlive(enc)
+	animParams := webpMuxAnimParamsCreate(backgroundColor, loopCount)
 	if webpAnimSetAnimationParams(enc.mux, &animParams) != 1 {
-		return errors.New("failed to set animation parameters")
+		return errors.New("webp: failed to set animation parameters")
+	}
+	enc.loopCount, enc.backgroundColor = loopCount, backgroundColor
+	return nil
+}
+
+// SetFeatureFlags forces the feature flags of the VP8X chunk of the assembled
+// animation to flags, a combination of FlagAnimation, FlagAlpha, FlagICCP,
+// FlagEXIF and FlagXMP, instead of the flags libwebp infers from the chunks.
+// It is meant for producing files that exercise particular code paths of
+// readers, such as an alpha flag without alpha in the frames; the flags are
+// written as given, even if they do not match the content.
+//
+// Zero restores the inferred flags. The final call wins. The flags are
+// applied by Encode, WriteTo and Bytes, which fail if the animation has a
+// single frame, as libwebp assembles it as a still image without a VP8X chunk.
+//
+// Returns an error if the encoder is closed, if flags holds unknown bits, or
+// if it lacks FlagAnimation, as the animation chunks are written regardless.
+func (enc *AnimationEncoder) SetFeatureFlags(flags uint32) error {
+	if enc.mux == nil {
+		return ErrEncoderClosed
+	}
+	const known = FlagAnimation | FlagXMP | FlagEXIF | FlagAlpha | FlagICCP
+	if flags&^known != 0 {
+		return fmt.Errorf("webp: invalid feature flags %#x, unknown bits %#x", flags, flags&^known)
+	}
+	if flags != 0 && flags&FlagAnimation == 0 {
+		return fmt.Errorf("webp: invalid feature flags %#x, an animation requires FlagAnimation", flags)
+	}
+	enc.featureFlags = flags
+	return nil
+}
+
+// SetEXIF sets the EXIF metadata of the animation, written as the EXIF chunk.
+//
+// Calling SetEXIF again replaces the metadata, and empty data removes it.
+//
+// Returns an error if the encoder is closed or if the metadata cannot be set.
+func (enc *AnimationEncoder) SetEXIF(data []byte) error {
+	if enc.mux == nil {
+		return ErrEncoderClosed
+	}
+	defer runtime.KeepAlive(enc)
+	if webpAnimSetChunk(enc.mux, "EXIF", data) != 1 {
+		return errors.New("webp: failed to set EXIF metadata")
 	}
+	return nil
+}
 
+// SetXMP sets the XMP metadata of the animation, written as the "XMP " chunk.
+//
+// Calling SetXMP again replaces the metadata, and empty data removes it.
+//
+// Returns an error if the encoder is closed or if the metadata cannot be set.
+func (enc *AnimationEncoder) SetXMP(data []byte) error {
+	if enc.mux == nil {
+		return ErrEncoderClosed
+	}
+	defer runtime.KeepAlive(enc)
+	if webpAnimSetChunk(enc.mux, "XMP ", data) != 1 {
+		return errors.New("webp: failed to set XMP metadata")
+	}
 	return nil
 }
 
@@ -161,23 +923,76 @@ func (enc *AnimationEncoder) SetAnimationParams(params AnimationParams) error {
 // The resulting WebP file can be viewed in any WebP-compatible viewer that
 // supports animation.
 //
+// Encode does not invalidate the encoder: more frames can be added and Encode
+// called again, or Reset can be used to start a new animation. Use WriteTo to
+// also get the number of bytes written.
+//
 // Returns an error if the encoder is closed or if the animation cannot be encoded.
 func (enc *AnimationEncoder) Encode(w io.Writer) error {
-	if enc.mux == nil {
-		return errors.New("animation encoder is closed")
+	_, err := enc.WriteTo(w)
+	return err
+}
+
+// WriteTo assembles the animation and writes it to w, like Encode, so the
+// encoder implements io.WriterTo and composes with writers such as
+// io.MultiWriter or size-counting wrappers.
+//
+// WriteTo returns the number of bytes written to w.
+// Returns an error if the encoder is closed, if the animation cannot be
+// encoded or if writing to w fails.
+func (enc *AnimationEncoder) WriteTo(w io.Writer) (n int64, err error) {
+	data, err := enc.Bytes()
+	if err != nil {
+		return 0, err
 	}
+	written, err := w.Write(data)
+	return int64(written), err
+}
 
-	// Assemble the animation
-	var webpData WebPData
-	if webpAnimAssemble(enc.mux, &webpData) != 1 {
-		return errors.New("failed to assemble animation")
+// Bytes assembles the animation and returns it, like Encode without the writer.
+//
+// Returns an error if the encoder is closed or if the animation cannot be encoded.
+func (enc *AnimationEncoder) Bytes() ([]byte, error) {
+	if enc.mux == nil {
+		return nil, ErrEncoderClosed
+	}
+	defer runtime.KeepAlive(enc)
+	data, err := webpAnimAssemble(enc.mux)
+	if err != nil {
+		return nil, errors.New("webp: failed to assemble animation")
 	}
-	// Note: The memory for webpData.data.bytes will be freed by the C code in webpAnimAssemble
+	if enc.featureFlags != 0 {
+		// The flags are the first byte of the VP8X payload, which follows the
+		// RIFF header and the chunk header; the other bits are reserved.
+		if len(data) < 21 || string(data[12:16]) != "VP8X" {
+			return nil, errors.New("webp: feature flags set, but the animation is assembled without a VP8X chunk")
+		}
+		data[20] = byte(enc.featureFlags)
+	}
+	return data, nil
+}
 
-	// Write the data to the writer
-	data := webpDataToBytes(webpData)
-	_, err := w.Write(data)
-	return err
+// Reset discards the frames, animation parameters and metadata added so far,
+// so the encoder can be reused for another animation. After Reset the encoder
+// behaves like one returned by NewAnimationEncoder.
+//
+// Reset has no effect on a closed encoder.
+func (enc *AnimationEncoder) Reset() {
+	if enc.mux == nil {
+		return
+	}
+	defer runtime.KeepAlive(enc)
+	webpAnimDelete(enc.mux)
+	enc.mux = webpAnimCreate()
+	enc.canvasWidth, enc.canvasHeight = 0, 0
+	enc.oddOffsets = OddOffsetRoundDown
+	enc.defaultDuration = 0
+	enc.keyframeInterval, enc.frameCount = 0, 0
+	enc.featureFlags = 0
+	enc.defaultDispose, enc.defaultBlend = 0, 0
+	enc.loopCount, enc.backgroundColor = 0, 0
+	enc.optimizeSubframes = false
+	enc.canvas, enc.pending = nil, nil
 }
 
 // EncodeAnimation encodes an animated WebP image with the given frames and parameters.
@@ -191,23 +1006,174 @@ func (enc *AnimationEncoder) Encode(w io.Writer) error {
 //	params := webp.AnimationParams{BackgroundColor: 0xFFFFFFFF, LoopCount: 0}
 //	err := webp.EncodeAnimation(outputFile, frames, params)
 func EncodeAnimation(w io.Writer, frames []Frame, params AnimationParams) error {
+	return EncodeContext(context.Background(), w, frames, params)
+}
+
+// EncodeAnimationN is like EncodeAnimation, but also returns the number of
+// bytes written to w, such as to record the size of the output without
+// wrapping w in a counting writer.
+func EncodeAnimationN(w io.Writer, frames []Frame, params AnimationParams) (n int, err error) {
+	return encodeAnimation(context.Background(), w, frames, params, nil, nil, 1)
+}
+
+// EncodeAnimationQuality is like EncodeAnimation, but encodes the frames with
+// the given quality instead of DefaulQuality, as with AddFrameWithQuality.
+// The quality must be in the range 0 ~ 100, where 0 gives the smallest size
+// and 100 the best quality. It is ignored for lossless frames.
+//
+// Returns an error if the quality is out of range, checked before any frame
+// is encoded, or if the animation cannot be encoded.
+func EncodeAnimationQuality(w io.Writer, frames []Frame, params AnimationParams, quality float32) error {
+	if quality < 0 || quality > 100 {
+		return fmt.Errorf("webp: invalid quality %v, must be in range 0 ~ 100", quality)
+	}
+	_, err := encodeAnimation(context.Background(), w, frames, params, &Options{Quality: quality}, nil, 1)
+	return err
+}
+
+// EncodeContext is like EncodeAnimation, but stops encoding when the context
+// is cancelled.
+//
+// The context is checked before each frame is encoded and before the animation
+// is assembled. When it is cancelled, EncodeContext returns ctx.Err() without
+// writing anything to w.
+func EncodeContext(ctx context.Context, w io.Writer, frames []Frame, params AnimationParams) error {
+	_, err := encodeAnimation(ctx, w, frames, params, nil, nil, 1)
+	return err
+}
+
+// EncodeAnimationWithProgress is like EncodeAnimation, but calls progress after
+// each frame is added to the animation, with the zero-based index of the frame
+// and the total number of frames. Progress is not called after an error.
+func EncodeAnimationWithProgress(w io.Writer, frames []Frame, params AnimationParams, progress func(frameIndex, totalFrames int)) error {
+	_, err := encodeAnimation(context.Background(), w, frames, params, nil, progress, 1)
+	return err
+}
+
+// EncodeAnimationConcurrent is like EncodeAnimation, but encodes up to workers
+// frames concurrently, or runtime.GOMAXPROCS(0) frames if workers <= 0.
+//
+// The frames are still added to the animation in order, so the output is
+// identical to EncodeAnimation. All the encoded frames are held in memory
+// until the animation is assembled.
+func EncodeAnimationConcurrent(w io.Writer, frames []Frame, params AnimationParams, workers int) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	_, err := encodeAnimation(context.Background(), w, frames, params, nil, nil, workers)
+	return err
+}
+
+// encodeAnimation encodes the frames with opt, as with AddFrameWithOptions,
+// and returns the number of bytes written to w.
+func encodeAnimation(ctx context.Context, w io.Writer, frames []Frame, params AnimationParams, opt *Options, progress func(frameIndex, totalFrames int), workers int) (n int, err error) {
 	enc := NewAnimationEncoder()
 	defer enc.Close()
 
 	// Set animation parameters
 	if err := enc.SetAnimationParams(params); err != nil {
-		return err
+		return 0, err
+	}
+
+	// Reject invalid frames before encoding any, including the frames that
+	// do not fit the canvas, set or established by the first one
+	for i, frame := range frames {
+		if err := checkFrame(frame); err != nil {
+			return 0, err
+		}
+		if err := enc.checkFrameBounds(frame); err != nil {
+			return 0, err
+		}
+		if i > 0 && params.CanvasWidth == 0 && params.CanvasHeight == 0 && frame.Image != nil && frames[0].Image != nil {
+			width, height := frameBounds(frames[0])
+			if right, bottom := frameBounds(frame); right > width || bottom > height {
+				b := frame.Image.Bounds()
+				return 0, fmt.Errorf("webp: frame %d of %dx%d at offset (%d, %d) does not fit the %dx%d canvas of the first frame",
+					i, b.Dx(), b.Dy(), frame.X, frame.Y, width, height)
+			}
+		}
+	}
+
+	// Encode the frames ahead of adding them
+	var data [][]byte
+	if workers > 1 {
+		aligned := make([]Frame, len(frames))
+		var canvas *image.RGBA
+		width, height := enc.canvasWidth, enc.canvasHeight
+		if width == 0 && height == 0 && len(frames) > 0 && frames[0].Image != nil {
+			width, height = frameBounds(frames[0])
+		}
+		for i, frame := range frames {
+			frame, canvas = enc.subframe(enc.keyframe(enc.frameModes(frame), i), i, canvas, width, height)
+			if aligned[i], err = enc.alignFrame(frame); err != nil {
+				return 0, err
+			}
+		}
+		frames = aligned
+		if data, err = encodeFrames(ctx, frames, opt, workers); err != nil {
+			return 0, err
+		}
 	}
 
 	// Add frames
-	for _, frame := range frames {
-		if err := enc.AddFrame(frame); err != nil {
-			return err
+	for i, frame := range frames {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		if data != nil {
+			err = enc.addEncodedFrame(frame, data[i])
+		} else {
+			err = enc.AddFrameWithOptions(frame, opt)
+		}
+		if err != nil {
+			return 0, err
 		}
+		if progress != nil {
+			progress(i, len(frames))
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
 	}
 
 	// Encode the animation
-	return enc.Encode(w)
+	written, err := enc.WriteTo(w)
+	return int(written), err
+}
+
+// encodeFrames encodes the images of the frames with opt, up to workers at a
+// time. The encoders are independent, unlike the mux the frames are added to.
+func encodeFrames(ctx context.Context, frames []Frame, opt *Options, workers int) ([][]byte, error) {
+	data := make([][]byte, len(frames))
+	errs := make([]error, len(frames))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i := range frames {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			data[i], errs[i] = encodeImage(frames[i].Image, frameOptions(frames[i], opt))
+		}(i)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
 }
 
 // EncodeAnimationToBytes encodes an animated WebP image with the given frames and parameters
@@ -223,13 +1189,164 @@ func EncodeAnimationToBytes(frames []Frame, params AnimationParams) ([]byte, err
 	return buf.Bytes(), nil
 }
 
+// EncodeAll writes the animation a to w as an animated WebP image, the
+// counterpart of DecodeAll, so an animation can be round-tripped or built
+// from the frames of a GIF. Each image is placed on the canvas at the top-left
+// corner of its bounds, and encoded like with AddFrame. Blend may be nil, for
+// BlendModeBlend on every frame.
+//
+// The canvas has the size of a.Config when set, and is otherwise derived from
+// the first frame, which must then cover the others.
+//
+// Returns an error if a is nil, if the lengths of the image, delay, disposal
+// and blend slices differ, if an image is nil or does not fit the canvas, or
+// if the animation cannot be encoded.
+func EncodeAll(w io.Writer, a *Animation) error {
+	if a == nil || len(a.Image) == 0 {
+		return errors.New("webp: EncodeAll, no frames")
+	}
+	if len(a.Delay) != len(a.Image) || len(a.Disposal) != len(a.Image) || a.Blend != nil && len(a.Blend) != len(a.Image) {
+		return fmt.Errorf("webp: EncodeAll, mismatched lengths: %d images, %d delays, %d disposals and %d blends",
+			len(a.Image), len(a.Delay), len(a.Disposal), len(a.Blend))
+	}
+
+	frames := make([]Frame, len(a.Image))
+	for i, m := range a.Image {
+		if m == nil {
+			return fmt.Errorf("webp: EncodeAll, image %d is nil", i)
+		}
+		b := m.Bounds()
+		frames[i] = Frame{
+			Image:       m,
+			X:           b.Min.X,
+			Y:           b.Min.Y,
+			Duration:    a.Delay[i],
+			DisposeMode: a.Disposal[i],
+		}
+		if a.Blend != nil {
+			frames[i].BlendMode = a.Blend[i]
+		}
+	}
+	return EncodeAnimation(w, frames, AnimationParams{
+		BackgroundColor: a.BackgroundColor,
+		LoopCount:       a.LoopCount,
+		CanvasWidth:     a.Config.Width,
+		CanvasHeight:    a.Config.Height,
+	})
+}
+
+// EncodeImages writes the images to w as an animated WebP image at a uniform
+// frame rate, such as a directory of PNG or JPEG frames: each image is a frame
+// covering the whole canvas, shown for frameDuration milliseconds, with
+// DisposeModeBackground. The frames are encoded like with AddFrame.
+//
+// Returns an error if there are no images, if they do not all have the same
+// size, if the duration or the parameters are invalid, or if the animation
+// cannot be encoded.
+func EncodeImages(w io.Writer, imgs []image.Image, frameDuration int, params AnimationParams) error {
+	if len(imgs) == 0 {
+		return errors.New("webp: EncodeImages, no images")
+	}
+	size := imgs[0].Bounds().Size()
+	frames := make([]Frame, len(imgs))
+	for i, m := range imgs {
+		if s := m.Bounds().Size(); s != size {
+			return fmt.Errorf("webp: EncodeImages, image %d is %dx%d, unlike the %dx%d of the first image",
+				i, s.X, s.Y, size.X, size.Y)
+		}
+		frames[i] = Frame{
+			Image:       m,
+			Duration:    frameDuration,
+			DisposeMode: DisposeModeBackground,
+		}
+	}
+	return EncodeAnimation(w, frames, params)
+}
+
+// RetimeAnimation returns the animation data with the duration of every frame
+// multiplied by factor, so a factor of 2 plays it twice as slow and 0.5 twice
+// as fast. The frames are copied in their compressed form, without decoding
+// and re-encoding them, and the animation parameters and the metadata are
+// kept.
+//
+// The durations are rounded to whole milliseconds. Frames with a non-zero
+// duration keep at least 1ms, since viewers treat zero durations specially.
+//
+// Returns an error if factor is not a positive number or if data cannot be
+// parsed.
+func RetimeAnimation(data []byte, factor float64) ([]byte, error) {
+	if !(factor > 0) || math.IsInf(factor, 0) {
+		return nil, fmt.Errorf("webp: invalid retiming factor %v", factor)
+	}
+
+	dec, err := NewAnimationDecoder(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	params, _, _ := dec.Info()
+	frames, err := dec.RawFrames()
+	if err != nil {
+		return nil, err
+	}
+
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	if err := enc.SetAnimationParams(params); err != nil {
+		return nil, err
+	}
+	for _, frame := range frames {
+		frame.Duration = retime(frame.Duration, factor)
+		if err := enc.AddRawFrame(frame); err != nil {
+			return nil, err
+		}
+	}
+	if exif, err := webpGetEXIF(data); err == nil && len(exif) > 0 {
+		if err := enc.SetEXIF(exif); err != nil {
+			return nil, err
+		}
+	}
+	if xmp, err := webpGetXMP(data); err == nil && len(xmp) > 0 {
+		if err := enc.SetXMP(xmp); err != nil {
+			return nil, err
+		}
+	}
+
+	output, err := enc.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	if iccp, err := webpGetICCP(data); err == nil && len(iccp) > 0 {
+		return webpSetICCP(output, iccp)
+	}
+	return output, nil
+}
+
+// retime returns the duration multiplied by factor, rounded, at least 1ms if
+// the duration is not zero, and within the range of frame durations.
+func retime(duration int, factor float64) int {
+	if duration == 0 {
+		return 0
+	}
+	d := math.Round(float64(duration) * factor)
+	switch {
+	case d < 1:
+		return 1
+	case d > maxFrameDuration:
+		return maxFrameDuration
+	}
+	return int(d)
+}
+
 // Close releases resources used by the AnimationEncoder.
 //
 // This method should be called when the encoder is no longer needed to avoid
-// memory leaks. After calling Close, the encoder cannot be used anymore.
+// memory leaks. After calling Close, the encoder cannot be used anymore: its
+// methods return ErrEncoderClosed. Close is safe to call multiple times.
 func (enc *AnimationEncoder) Close() {
 	if enc.mux != nil {
 		webpAnimDelete(enc.mux)
 		enc.mux = nil
 	}
+	runtime.SetFinalizer(enc, nil)
 }
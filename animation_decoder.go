@@ -0,0 +1,422 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package webp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+// AnimationDecoder decodes animated WebP images frame by frame.
+// Still images are decoded as an animation with a single frame.
+//
+// Usage:
+//
+//	dec, err := webp.NewAnimationDecoder(inputFile)
+//	if err != nil {
+//		return err
+//	}
+//	defer dec.Close()
+//
+//	for {
+//		m, duration, err := dec.Next()
+//		if err == io.EOF {
+//			break
+//		}
+//		if err != nil {
+//			return err
+//		}
+//		// Use the frame
+//	}
+type AnimationDecoder struct {
+	dec *WebPAnimDecoder
+
+	params        AnimationParams
+	width, height int
+	frameCount    int
+	flatten       bool
+
+	frame     int // index of the next frame
+	timestamp int // end time of the previous frame
+}
+
+// NewAnimationDecoder reads an animated WebP image from r and creates a decoder
+// for its frames.
+//
+// The returned decoder must be closed with Close() when no longer needed
+// to avoid memory leaks.
+func NewAnimationDecoder(r io.Reader) (*AnimationDecoder, error) {
+	return NewAnimationDecoderWithOptions(r, nil)
+}
+
+// NewAnimationDecoderWithOptions is like NewAnimationDecoder, but decodes the
+// frames with the given options. A nil opt uses the default options.
+func NewAnimationDecoderWithOptions(r io.Reader, opt *DecoderOptions) (*AnimationDecoder, error) {
+	if opt == nil {
+		opt = &DecoderOptions{}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := webpAnimDecoderNew(data)
+	if err != nil {
+		return nil, err
+	}
+	width, height, loopCount, bgColor, frameCount, err := webpAnimDecoderGetInfo(dec)
+	if err != nil {
+		webpAnimDecoderDelete(dec)
+		return nil, err
+	}
+
+	return &AnimationDecoder{
+		dec: dec,
+		params: AnimationParams{
+			BackgroundColor: bgColor,
+			LoopCount:       loopCount,
+			CanvasWidth:     width,
+			CanvasHeight:    height,
+		},
+		width:      width,
+		height:     height,
+		frameCount: frameCount,
+		flatten:    opt.FlattenToBackground,
+	}, nil
+}
+
+// Info returns the animation parameters stored in the ANIM chunk and the
+// canvas dimensions, without decoding any frame. The canvas dimensions are
+// also reported in params, so it can be passed to SetAnimationParams as is.
+//
+// Images without an ANIM chunk (such as still images) report a loop count of 1,
+// a white background and the image size as the canvas.
+func (dec *AnimationDecoder) Info() (params AnimationParams, width, height int) {
+	return dec.params, dec.width, dec.height
+}
+
+// FrameCount returns the number of frames of the animation, 1 for a still
+// image, as stored in the file. It is known as soon as the decoder is created,
+// without decoding any frame, for reporting progress like "frame 12 of 300".
+func (dec *AnimationDecoder) FrameCount() int {
+	return dec.frameCount
+}
+
+// Next decodes the next frame of the animation.
+//
+// The returned image is the full canvas with the frame composited onto it,
+// taking the background color and the dispose and blend modes of the previous
+// frames into account. The duration is the display duration of the frame in
+// milliseconds.
+//
+// Returns io.EOF when there are no more frames, or ErrDecoderClosed after
+// Close.
+func (dec *AnimationDecoder) Next() (m image.Image, duration int, err error) {
+	last := dec.timestamp
+	m, timestamp, err := dec.NextTimestamp()
+	if err != nil {
+		return nil, 0, err
+	}
+	return m, timestamp - last, nil
+}
+
+// NextTimestamp is like Next, but returns the timestamp of the frame instead
+// of its duration. The timestamp is the accumulated display time in
+// milliseconds from the start of the animation to the end of the frame, as
+// reported by libwebp.
+func (dec *AnimationDecoder) NextTimestamp() (m image.Image, timestamp int, err error) {
+	if dec.dec == nil {
+		return nil, 0, ErrDecoderClosed
+	}
+	if !webpAnimDecoderHasMoreFrames(dec.dec) {
+		return nil, 0, io.EOF
+	}
+
+	pix, timestamp, err := webpAnimDecoderGetNext(dec.dec, dec.width, dec.height)
+	if err != nil {
+		return nil, 0, err
+	}
+	dec.frame, dec.timestamp = dec.frame+1, timestamp
+	if dec.flatten {
+		flattenRGBA(pix, dec.params.BackgroundColor)
+	}
+
+	m = &image.RGBA{
+		Pix:    pix,
+		Stride: 4 * dec.width,
+		Rect:   image.Rect(0, 0, dec.width, dec.height),
+	}
+	return m, timestamp, nil
+}
+
+// DecodedFrame is a frame of an animation as returned by NextFrame: the
+// composited canvas together with the frame on its own, for inspecting the
+// composition.
+type DecodedFrame struct {
+	// Canvas is the full canvas with the frame composited onto it, as
+	// returned by Next.
+	Canvas image.Image
+
+	// Frame is the frame decoded alone, before composition, with straight
+	// alpha. Its bounds are the rectangle it covers on the canvas.
+	Frame *image.NRGBA
+
+	// Duration and Timestamp are the duration and end time of the frame in
+	// milliseconds, as returned by Next and NextTimestamp.
+	Duration, Timestamp int
+
+	// DisposeMode and BlendMode are the modes the frame is composited with.
+	DisposeMode int
+	BlendMode   int
+}
+
+// NextFrame is like Next, but also returns the frame before composition and
+// the modes it is composited with, to check the composition against another
+// renderer. The frame is decoded a second time on its own, so NextFrame is
+// slower than Next.
+//
+// Returns io.EOF when there are no more frames.
+func (dec *AnimationDecoder) NextFrame() (*DecodedFrame, error) {
+	index, last := dec.frame, dec.timestamp
+	canvas, timestamp, err := dec.NextTimestamp()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, raw, err := webpAnimDecoderGetFrame(dec.dec, index+1)
+	if err != nil {
+		return nil, err
+	}
+	pix, width, height, err := webpDecodeRGBA(rawFrameData(payload, raw.Width, raw.Height))
+	if err != nil {
+		return nil, err
+	}
+	return &DecodedFrame{
+		Canvas: canvas,
+		Frame: &image.NRGBA{
+			Pix:    pix,
+			Stride: 4 * width,
+			Rect:   image.Rect(raw.X, raw.Y, raw.X+width, raw.Y+height),
+		},
+		Duration:    timestamp - last,
+		Timestamp:   timestamp,
+		DisposeMode: raw.DisposeMode,
+		BlendMode:   raw.BlendMode,
+	}, nil
+}
+
+// Animation is an animated WebP image as a whole, shaped like gif.GIF so that
+// code handling GIF animations can handle WebP ones, as returned by DecodeAll
+// and taken by EncodeAll.
+//
+// Unlike gif.GIF, the delays are in milliseconds, the dispose modes are the
+// DisposeMode constants and a loop count of 0 means forever.
+type Animation struct {
+	// Image holds the frames, decoded alone before composition with straight
+	// alpha, as *image.NRGBA. Their bounds are the rectangles they cover on
+	// the canvas.
+	Image []image.Image
+
+	// Delay holds the durations of the frames in milliseconds.
+	Delay []int
+
+	// Disposal and Blend hold the dispose and blend modes of the frames.
+	Disposal []int
+	Blend    []int
+
+	// LoopCount is the number of times the animation is played, 0 meaning
+	// forever.
+	LoopCount int
+
+	// BackgroundColor is the background color of the canvas as ARGB.
+	BackgroundColor uint32
+
+	// Config holds the canvas size, with the color model of the frames.
+	Config image.Config
+}
+
+// DecodeAll reads an animated WebP image from r and returns all its frames
+// with their delays and modes, like gif.DecodeAll. Still images are returned
+// as an animation with a single frame.
+//
+// The frames are not composited onto the canvas, use AnimationDecoder for the
+// frames as displayed. All the frames are decoded upfront and held in memory.
+func DecodeAll(r io.Reader) (*Animation, error) {
+	dec, err := NewAnimationDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	frames, err := dec.RawFrames()
+	if err != nil {
+		return nil, err
+	}
+	params, width, height := dec.Info()
+	a := &Animation{
+		Image:           make([]image.Image, len(frames)),
+		Delay:           make([]int, len(frames)),
+		Disposal:        make([]int, len(frames)),
+		Blend:           make([]int, len(frames)),
+		LoopCount:       params.LoopCount,
+		BackgroundColor: params.BackgroundColor,
+		Config: image.Config{
+			ColorModel: color.NRGBAModel,
+			Width:      width,
+			Height:     height,
+		},
+	}
+	for i, frame := range frames {
+		pix, w, h, err := webpDecodeRGBA(frame.Data)
+		if err != nil {
+			return nil, fmt.Errorf("webp: DecodeAll, invalid or corrupt frame %d", i)
+		}
+		a.Image[i] = &image.NRGBA{
+			Pix:    pix,
+			Stride: 4 * w,
+			Rect:   image.Rect(frame.X, frame.Y, frame.X+w, frame.Y+h),
+		}
+		a.Delay[i] = frame.Duration
+		a.Disposal[i], a.Blend[i] = frame.DisposeMode, frame.BlendMode
+	}
+	return a, nil
+}
+
+// DecodeFirstFrame reads an animated WebP image from r and returns its first
+// frame composited onto the canvas, as the first image of Next, for use as a
+// static cover or poster image. The other frames are not decoded, which
+// makes it much faster than DecodeAll on long animations. For a still image,
+// the image itself is returned.
+//
+// The canvas is transparent where the frame does not cover it. Use an
+// AnimationDecoder with DecoderOptions.FlattenToBackground for an opaque
+// image over the background color.
+//
+// Returns an error if the data is invalid or if the image has no frames.
+func DecodeFirstFrame(r io.Reader) (image.Image, error) {
+	dec, err := NewAnimationDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	if dec.FrameCount() == 0 {
+		return nil, errors.New("webp: DecodeFirstFrame, the image has no frames")
+	}
+	m, _, err := dec.Next()
+	if err == io.EOF {
+		return nil, errors.New("webp: DecodeFirstFrame, the image has no frames")
+	}
+	return m, err
+}
+
+// flattenRGBA composites the premultiplied RGBA pixels over the opaque ARGB
+// background color.
+func flattenRGBA(pix []byte, background uint32) {
+	r, g, b := background>>16&0xff, background>>8&0xff, background&0xff
+	for i := 0; i+3 < len(pix); i += 4 {
+		a := uint32(pix[i+3])
+		if a == 0xff {
+			continue
+		}
+		pix[i+0] += uint8((r*(0xff-a) + 0x7f) / 0xff)
+		pix[i+1] += uint8((g*(0xff-a) + 0x7f) / 0xff)
+		pix[i+2] += uint8((b*(0xff-a) + 0x7f) / 0xff)
+		pix[i+3] = 0xff
+	}
+}
+
+// Seek positions the decoder so that the next call to Next returns the frame
+// with the given zero-based index, composited as if all the preceding frames
+// had been decoded.
+//
+// Since each frame is composited onto the canvas left by the previous ones,
+// random access is O(index): seeking forward decodes the frames in between,
+// and seeking backward restarts decoding from the first frame. The skipped
+// frames are only decoded onto the canvas, not copied out.
+func (dec *AnimationDecoder) Seek(frameIndex int) error {
+	if dec.dec == nil {
+		return ErrDecoderClosed
+	}
+	if frameIndex < 0 || frameIndex >= dec.frameCount {
+		return fmt.Errorf("webp: invalid frame index %d, the animation has %d frames", frameIndex, dec.frameCount)
+	}
+
+	if frameIndex < dec.frame {
+		webpAnimDecoderReset(dec.dec)
+		dec.frame, dec.timestamp = 0, 0
+	}
+	for dec.frame < frameIndex {
+		timestamp, err := webpAnimDecoderSkip(dec.dec)
+		if err != nil {
+			return err
+		}
+		dec.frame, dec.timestamp = dec.frame+1, timestamp
+	}
+	return nil
+}
+
+// RawFrames returns the frames of the animation in their compressed form,
+// without decoding them. Unlike Next, the frames are not composited onto the
+// canvas, and the position of the decoder is not changed.
+//
+// The frames can be added to an AnimationEncoder with AddRawFrame, for
+// instance to change their durations without re-encoding the pixels.
+func (dec *AnimationDecoder) RawFrames() ([]RawFrame, error) {
+	if dec.dec == nil {
+		return nil, ErrDecoderClosed
+	}
+
+	frames := make([]RawFrame, dec.frameCount)
+	for i := range frames {
+		payload, frame, err := webpAnimDecoderGetFrame(dec.dec, i+1)
+		if err != nil {
+			return nil, err
+		}
+		frame.Data = rawFrameData(payload, frame.Width, frame.Height)
+		frame.Format = frameFormat(payload)
+		frames[i] = frame
+	}
+	return frames, nil
+}
+
+// rawFrameData wraps the payload of a frame, its optional ALPH chunk followed
+// by its VP8 or VP8L chunk, in the RIFF container of a still WebP image.
+func rawFrameData(payload []byte, width, height int) []byte {
+	var header []byte
+	if len(payload) >= 4 && string(payload[:4]) == "ALPH" {
+		// The ALPH chunk requires the extended format
+		header = []byte{
+			'V', 'P', '8', 'X', 10, 0, 0, 0,
+			FlagAlpha, 0, 0, 0,
+			byte(width - 1), byte((width - 1) >> 8), byte((width - 1) >> 16),
+			byte(height - 1), byte((height - 1) >> 8), byte((height - 1) >> 16),
+		}
+	}
+
+	size := 4 + len(header) + len(payload)
+	data := make([]byte, 0, 8+size)
+	data = append(data, 'R', 'I', 'F', 'F', byte(size), byte(size>>8), byte(size>>16), byte(size>>24))
+	data = append(data, 'W', 'E', 'B', 'P')
+	data = append(data, header...)
+	return append(data, payload...)
+}
+
+// Close releases resources used by the AnimationDecoder.
+//
+// This method should be called when the decoder is no longer needed to avoid
+// memory leaks. After calling Close, the decoder cannot be used anymore.
+func (dec *AnimationDecoder) Close() {
+	if dec.dec != nil {
+		webpAnimDecoderDelete(dec.dec)
+		dec.dec = nil
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+)
+
+func tEncodeTestAnimation(t *testing.T) []byte {
+	t.Helper()
+	frames := []Frame{
+		{
+			Image:       createImage(32, 24, color.RGBA{255, 0, 0, 255}),
+			Duration:    100,
+			DisposeMode: DisposeModeBackground,
+			BlendMode:   BlendModeNoBlend,
+			Lossless:    true,
+		},
+		{
+			Image:       createImage(32, 24, color.RGBA{0, 0, 255, 255}),
+			Duration:    250,
+			DisposeMode: DisposeModeBackground,
+			BlendMode:   BlendModeNoBlend,
+			Lossless:    true,
+		},
+	}
+	data, err := EncodeAnimationToBytes(frames, AnimationParams{LoopCount: 3})
+	tAssertNil(t, err)
+	return data
+}
+
+func TestAnimationDecoder_Next(t *testing.T) {
+	dec, err := NewAnimationDecoder(bytes.NewReader(tEncodeTestAnimation(t)))
+	tAssertNil(t, err)
+	defer dec.Close()
+
+	want := []struct {
+		c        color.RGBA
+		duration int
+	}{
+		{color.RGBA{255, 0, 0, 255}, 100},
+		{color.RGBA{0, 0, 255, 255}, 250},
+	}
+	for i, v := range want {
+		m, duration, err := dec.Next()
+		tAssertNil(t, err, "frame ", i)
+		tAssertEQ(t, 32, m.Bounds().Dx(), "frame ", i)
+		tAssertEQ(t, 24, m.Bounds().Dy(), "frame ", i)
+		tAssertEQ(t, v.duration, duration, "frame ", i)
+		tAssertEQ(t, v.c, color.RGBAModel.Convert(m.At(5, 5)), "frame ", i)
+	}
+
+	_, _, err = dec.Next()
+	tAssertEQ(t, io.EOF, err)
+}
+
+func TestAnimationDecoder_still(t *testing.T) {
+	dec, err := NewAnimationDecoder(bytes.NewReader(xLoadData("1_webp_ll.webp")))
+	tAssertNil(t, err)
+	defer dec.Close()
+
+	m, _, err := dec.Next()
+	tAssertNil(t, err)
+	tAssertEQ(t, 400, m.Bounds().Dx())
+	tAssertEQ(t, 301, m.Bounds().Dy())
+
+	_, _, err = dec.Next()
+	tAssertEQ(t, io.EOF, err)
+}
+
+func TestAnimationDecoder_invalid(t *testing.T) {
+	_, err := NewAnimationDecoder(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WEBP")))
+	tAssert(t, err != nil)
+
+	dec, err := NewAnimationDecoder(bytes.NewReader(tEncodeTestAnimation(t)))
+	tAssertNil(t, err)
+	dec.Close()
+	dec.Close()
+	_, _, err = dec.Next()
+	tAssert(t, errors.Is(err, ErrDecoderClosed), err)
+	_, err = dec.NextFrame()
+	tAssert(t, errors.Is(err, ErrDecoderClosed), err)
+	_, err = dec.RawFrames()
+	tAssert(t, errors.Is(err, ErrDecoderClosed), err)
+	tAssert(t, errors.Is(dec.Seek(0), ErrDecoderClosed))
+}
+
+func TestAnimationDecoder_Info(t *testing.T) {
+	data, err := EncodeAnimationToBytes([]Frame{
+		{Image: createImage(40, 30, color.RGBA{255, 0, 0, 255}), Duration: 100},
+		{Image: createImage(40, 30, color.RGBA{0, 255, 0, 255}), Duration: 100},
+	}, AnimationParams{BackgroundColor: 0xFF102030, LoopCount: 7})
+	tAssertNil(t, err)
+
+	dec, err := NewAnimationDecoder(bytes.NewReader(data))
+	tAssertNil(t, err)
+	defer dec.Close()
+
+	params, width, height := dec.Info()
+	tAssertEQ(t, uint32(0xFF102030), params.BackgroundColor)
+	tAssertEQ(t, 7, params.LoopCount)
+	tAssertEQ(t, 40, width)
+	tAssertEQ(t, 30, height)
+}
+
+func TestAnimationDecoder_NextTimestamp(t *testing.T) {
+	dec, err := NewAnimationDecoder(bytes.NewReader(tEncodeTestAnimation(t)))
+	tAssertNil(t, err)
+	defer dec.Close()
+
+	for i, want := range []int{100, 350} {
+		_, timestamp, err := dec.NextTimestamp()
+		tAssertNil(t, err, "frame ", i)
+		tAssertEQ(t, want, timestamp, "frame ", i)
+	}
+	_, _, err = dec.NextTimestamp()
+	tAssertEQ(t, io.EOF, err)
+}
+
+func TestAnimationDecoder_Seek(t *testing.T) {
+	colors := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+	var frames []Frame
+	for i, c := range colors {
+		frames = append(frames, Frame{
+			Image:    createImage(16, 16, c),
+			Duration: 100 * (i + 1),
+			Lossless: true,
+		})
+	}
+	data, err := EncodeAnimationToBytes(frames, AnimationParams{})
+	tAssertNil(t, err)
+
+	dec, err := NewAnimationDecoder(bytes.NewReader(data))
+	tAssertNil(t, err)
+	defer dec.Close()
+
+	for _, i := range []int{2, 0, 1, 1, 2} {
+		tAssertNil(t, dec.Seek(i), "seek ", i)
+		m, duration, err := dec.Next()
+		tAssertNil(t, err, "frame ", i)
+		tAssertEQ(t, colors[i], color.RGBAModel.Convert(m.At(8, 8)), "frame ", i)
+		tAssertEQ(t, frames[i].Duration, duration, "frame ", i)
+	}
+
+	tAssert(t, dec.Seek(-1) != nil)
+	tAssert(t, dec.Seek(3) != nil)
+	dec.Close()
+	tAssert(t, dec.Seek(0) != nil)
+}
+
+func TestAnimationDecoder_RawFrames(t *testing.T) {
+	// Lossy frames with alpha, stored with an ALPH chunk, and a lossless one.
+	frames := []Frame{
+		{Image: createImage(32, 24, color.RGBA{255, 0, 0, 128}), Duration: 100},
+		{Image: createImage(16, 8, color.RGBA{0, 0, 255, 255}), X: 8, Y: 4, Duration: 200, BlendMode: BlendModeNoBlend},
+		{Image: createImage(32, 24, color.RGBA{0, 255, 0, 255}), Duration: 300, DisposeMode: DisposeModeBackground, Lossless: true},
+	}
+	data, err := EncodeAnimationToBytes(frames, AnimationParams{LoopCount: 2})
+	tAssertNil(t, err)
+
+	dec, err := NewAnimationDecoder(bytes.NewReader(data))
+	tAssertNil(t, err)
+	defer dec.Close()
+
+	raw, err := dec.RawFrames()
+	tAssertNil(t, err)
+	tAssertEQ(t, len(frames), len(raw))
+	for i, frame := range raw {
+		b := frames[i].Image.Bounds()
+		tAssertEQ(t, frames[i].X, frame.X, "frame ", i)
+		tAssertEQ(t, frames[i].Y, frame.Y, "frame ", i)
+		tAssertEQ(t, b.Dx(), frame.Width, "frame ", i)
+		tAssertEQ(t, b.Dy(), frame.Height, "frame ", i)
+		tAssertEQ(t, frames[i].Duration, frame.Duration, "frame ", i)
+		tAssertEQ(t, frames[i].DisposeMode, frame.DisposeMode, "frame ", i)
+		tAssertEQ(t, frames[i].BlendMode, frame.BlendMode, "frame ", i)
+		format := FormatLossy
+		if frames[i].Lossless {
+			format = FormatLossless
+		}
+		tAssertEQ(t, format, frame.Format, "frame ", i)
+
+		// The data is a still image.
+		m, err := DecodeNRGBA(bytes.NewReader(frame.Data))
+		tAssertNil(t, err, "frame ", i)
+		tAssertEQ(t, b.Size(), m.Bounds().Size(), "frame ", i)
+	}
+
+	// Re-time the animation without re-encoding the frames.
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	params, _, _ := dec.Info()
+	tAssertNil(t, enc.SetAnimationParams(params))
+	for _, frame := range raw {
+		frame.Duration *= 2
+		tAssertNil(t, enc.AddRawFrame(frame))
+	}
+	tAssert(t, enc.AddRawFrame(RawFrame{Data: []byte("garbage")}) != nil, "invalid data accepted")
+	var buf bytes.Buffer
+	tAssertNil(t, enc.Encode(&buf))
+
+	retimed, err := NewAnimationDecoder(&buf)
+	tAssertNil(t, err)
+	defer retimed.Close()
+	for i := range frames {
+		want, _, err := dec.Next()
+		tAssertNil(t, err, "frame ", i)
+		got, duration, err := retimed.Next()
+		tAssertNil(t, err, "frame ", i)
+		tAssertEQ(t, frames[i].Duration*2, duration, "frame ", i)
+		tAssertEQ(t, want.(*image.RGBA).Pix, got.(*image.RGBA).Pix, "frame ", i)
+	}
+}
+
+func TestAnimationDecoder_FlattenToBackground(t *testing.T) {
+	// A transparent frame, then a half transparent one.
+	frames := []Frame{
+		{Image: createImage(16, 16, color.RGBA{}), Duration: 100, Lossless: true},
+		{Image: createImage(16, 16, color.RGBA{255, 0, 0, 128}), Duration: 100, BlendMode: BlendModeNoBlend, Lossless: true},
+	}
+	data, err := EncodeAnimationToBytes(frames, AnimationParams{BackgroundColor: 0x800000ff})
+	tAssertNil(t, err)
+
+	for _, flatten := range []bool{false, true} {
+		dec, err := NewAnimationDecoderWithOptions(bytes.NewReader(data), &DecoderOptions{FlattenToBackground: flatten})
+		tAssertNil(t, err)
+		defer dec.Close()
+
+		m, _, err := dec.Next()
+		tAssertNil(t, err)
+		c := color.RGBAModel.Convert(m.At(8, 8)).(color.RGBA)
+		if flatten {
+			tAssertEQ(t, color.RGBA{0, 0, 255, 255}, c)
+		} else {
+			tAssertEQ(t, color.RGBA{}, c)
+		}
+
+		m, _, err = dec.Next()
+		tAssertNil(t, err)
+		c = color.RGBAModel.Convert(m.At(8, 8)).(color.RGBA)
+		if flatten {
+			tAssertEQ(t, uint8(255), c.A)
+			tAssert(t, c.R > 100 && c.B > 100, "not blended over the background: ", c)
+		} else {
+			tAssertEQ(t, uint8(128), c.A)
+			tAssertEQ(t, uint8(0), c.B)
+		}
+	}
+}
+
+func TestAnimationDecoder_NextFrame(t *testing.T) {
+	frames := []Frame{
+		{Image: createImage(16, 16, color.RGBA{255, 0, 0, 255}), Duration: 100, Lossless: true},
+		{Image: createImage(4, 6, color.RGBA{0, 0, 255, 128}), X: 6, Y: 8, Duration: 200, DisposeMode: DisposeModeBackground, Lossless: true},
+	}
+	data, err := EncodeAnimationToBytes(frames, AnimationParams{})
+	tAssertNil(t, err)
+	dec, err := NewAnimationDecoder(bytes.NewReader(data))
+	tAssertNil(t, err)
+	defer dec.Close()
+
+	f, err := dec.NextFrame()
+	tAssertNil(t, err)
+	tAssertEQ(t, image.Rect(0, 0, 16, 16), f.Frame.Rect)
+	tAssertEQ(t, 100, f.Duration)
+	tAssertEQ(t, 100, f.Timestamp)
+
+	f, err = dec.NextFrame()
+	tAssertNil(t, err)
+	tAssertEQ(t, image.Rect(6, 8, 10, 14), f.Frame.Rect)
+	tAssertEQ(t, image.Rect(0, 0, 16, 16), f.Canvas.Bounds())
+	tAssertEQ(t, 200, f.Duration)
+	tAssertEQ(t, 300, f.Timestamp)
+	tAssertEQ(t, DisposeModeBackground, f.DisposeMode)
+	tAssertEQ(t, BlendModeBlend, f.BlendMode)
+
+	// The frame keeps its own translucent pixels, the canvas has them blended.
+	tAssertEQ(t, color.NRGBA{0, 0, 255, 128}, f.Frame.NRGBAAt(7, 9))
+	r, _, b, a := f.Canvas.At(7, 9).RGBA()
+	tAssert(t, a == 0xffff && r > 0 && b > 0, "frame not blended onto the canvas")
+
+	_, err = dec.NextFrame()
+	tAssertEQ(t, io.EOF, err)
+}
+
+func TestDecodeAll(t *testing.T) {
+	frames := []Frame{
+		{Image: tNoiseImage(32, 24), Duration: 100, Lossless: true},
+		{Image: tNoiseImage(8, 6), X: 4, Y: 2, Duration: 250,
+			DisposeMode: DisposeModeBackground, BlendMode: BlendModeNoBlend, Lossless: true},
+	}
+	data, err := EncodeAnimationToBytes(frames, AnimationParams{LoopCount: 3, BackgroundColor: 0xff102030})
+	tAssertNil(t, err)
+
+	a, err := DecodeAll(bytes.NewReader(data))
+	tAssertNil(t, err)
+	tAssertEQ(t, 2, len(a.Image))
+	tAssertEQ(t, []int{100, 250}, a.Delay)
+	tAssertEQ(t, []int{DisposeModeNone, DisposeModeBackground}, a.Disposal)
+	tAssertEQ(t, []int{BlendModeBlend, BlendModeNoBlend}, a.Blend)
+	tAssertEQ(t, 3, a.LoopCount)
+	tAssertEQ(t, uint32(0xff102030), a.BackgroundColor)
+	tAssert(t, a.Config.Width == 32 && a.Config.Height == 24, "canvas: ", a.Config.Width, a.Config.Height)
+
+	for i, frame := range frames {
+		m := a.Image[i].(*image.NRGBA)
+		src := frame.Image.(*image.RGBA)
+		tAssertEQ(t, src.Rect.Add(image.Pt(frame.X, frame.Y)), m.Rect)
+		tAssertEQ(t, src.Pix, m.Pix)
+	}
+
+	// A still image is a single frame.
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, tNoiseImage(8, 8), &Options{Lossless: true}))
+	a, err = DecodeAll(&buf)
+	tAssertNil(t, err)
+	tAssertEQ(t, 1, len(a.Image))
+
+	_, err = DecodeAll(bytes.NewReader([]byte("RIFF")))
+	tAssert(t, err != nil, "invalid data accepted")
+}
+
+func TestAnimationDecoder_FrameCount(t *testing.T) {
+	dec, err := NewAnimationDecoder(bytes.NewReader(tEncodeTestAnimation(t)))
+	tAssertNil(t, err)
+	defer dec.Close()
+	tAssertEQ(t, 2, dec.FrameCount())
+
+	n := 0
+	for {
+		_, _, err := dec.Next()
+		if err == io.EOF {
+			break
+		}
+		tAssertNil(t, err)
+		n++
+	}
+	tAssertEQ(t, dec.FrameCount(), n)
+
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, tNoiseImage(8, 8), nil))
+	still, err := NewAnimationDecoder(&buf)
+	tAssertNil(t, err)
+	defer still.Close()
+	tAssertEQ(t, 1, still.FrameCount())
+}
+
+func TestDecodeFirstFrame(t *testing.T) {
+	m, err := DecodeFirstFrame(bytes.NewReader(tEncodeTestAnimation(t)))
+	tAssertNil(t, err)
+	tAssertEQ(t, image.Rect(0, 0, 32, 24), m.Bounds())
+	tAssertEQ(t, color.RGBAModel.Convert(color.RGBA{255, 0, 0, 255}), color.RGBAModel.Convert(m.At(16, 12)))
+
+	still := tNoiseImage(8, 8)
+	data, err := EncodeExactLosslessRGBA(still)
+	tAssertNil(t, err)
+	m, err = DecodeFirstFrame(bytes.NewReader(data))
+	tAssertNil(t, err)
+	tAssertEQ(t, 0, averageDelta(still, m))
+
+	_, err = DecodeFirstFrame(bytes.NewReader([]byte("RIFF\x04\x00\x00\x00WEBP")))
+	tAssert(t, err != nil, "invalid data decoded")
+}
+
+func TestAnimationDecoder_finiteLoopCount(t *testing.T) {
+	frames := []Frame{
+		{Image: tNoiseImage(8, 8), Duration: 100},
+		{Image: tNoiseImage(8, 8), Duration: 100},
+	}
+	for _, loopCount := range []int{0, 1, 3, maxLoopCount} {
+		data, err := EncodeAnimationToBytes(frames, AnimationParams{LoopCount: loopCount})
+		tAssertNil(t, err)
+
+		// The demuxer reads the ANIM chunk as written, without adjustment.
+		dec, err := NewAnimationDecoder(bytes.NewReader(data))
+		tAssertNil(t, err)
+		params, _, _ := dec.Info()
+		dec.Close()
+		tAssertEQ(t, loopCount, params.LoopCount)
+
+		info, err := Inspect(bytes.NewReader(data))
+		tAssertNil(t, err)
+		tAssertEQ(t, loopCount, info.LoopCount)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package webp
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"sync"
+)
+
+// LazyAnimation holds the frames of an animated WebP image in their
+// compressed form and decodes each one only when its pixels are accessed,
+// keeping the most recently used ones decoded. It is the lazy counterpart
+// of DecodeAll, for viewers of large animations that only show a few frames
+// at a time: the memory is that of the compressed file plus the cached
+// frames, and opening the animation decodes nothing.
+//
+// As with DecodeAll, the frames are not composited onto the canvas: each
+// one only covers its own rectangle, with straight alpha.
+//
+// A LazyAnimation and its frames are safe for concurrent use. Frames are
+// decoded outside of the lock, so different frames decode in parallel, and
+// a frame requested by two goroutines at once may be decoded twice.
+type LazyAnimation struct {
+	// Delay holds the display durations of the frames in milliseconds.
+	Delay []int
+
+	// Disposal and Blend hold the dispose and blend modes of the frames.
+	Disposal []int
+	Blend    []int
+
+	// LoopCount is the number of times the animation is played, 0 meaning
+	// forever.
+	LoopCount int
+
+	// BackgroundColor is the background color of the canvas as ARGB.
+	BackgroundColor uint32
+
+	// Config holds the canvas size, with the color model of the frames.
+	Config image.Config
+
+	frames []*LazyFrame
+
+	mu        sync.Mutex
+	cached    []*LazyFrame // decoded frames, least recently used first
+	maxCached int
+}
+
+// NewLazyAnimation reads an animated WebP image from r and returns it with
+// its frames left compressed. Still images are returned as an animation with
+// a single frame.
+//
+// At most maxCached frames are kept decoded, at least one; accessing another
+// frame evicts the least recently used one.
+//
+// Returns an error if the data is invalid.
+func NewLazyAnimation(r io.Reader, maxCached int) (*LazyAnimation, error) {
+	dec, err := NewAnimationDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	raw, err := dec.RawFrames()
+	if err != nil {
+		return nil, err
+	}
+	if maxCached < 1 {
+		maxCached = 1
+	}
+	params, width, height := dec.Info()
+	a := &LazyAnimation{
+		Delay:           make([]int, len(raw)),
+		Disposal:        make([]int, len(raw)),
+		Blend:           make([]int, len(raw)),
+		LoopCount:       params.LoopCount,
+		BackgroundColor: params.BackgroundColor,
+		Config: image.Config{
+			ColorModel: color.NRGBAModel,
+			Width:      width,
+			Height:     height,
+		},
+		frames:    make([]*LazyFrame, len(raw)),
+		maxCached: maxCached,
+	}
+	for i, frame := range raw {
+		a.frames[i] = &LazyFrame{
+			a:     a,
+			index: i,
+			data:  frame.Data,
+			rect:  image.Rect(frame.X, frame.Y, frame.X+frame.Width, frame.Y+frame.Height),
+		}
+		a.Delay[i] = frame.Duration
+		a.Disposal[i], a.Blend[i] = frame.DisposeMode, frame.BlendMode
+	}
+	return a, nil
+}
+
+// Len returns the number of frames.
+func (a *LazyAnimation) Len() int {
+	return len(a.frames)
+}
+
+// Frame returns the frame with the given zero-based index, without decoding
+// it. It panics if the index is out of range.
+func (a *LazyAnimation) Frame(i int) *LazyFrame {
+	return a.frames[i]
+}
+
+// cachedImage returns the decoded image of f, or nil if it is not cached,
+// and marks f as the most recently used frame.
+func (a *LazyAnimation) cachedImage(f *LazyFrame) *image.NRGBA {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if f.m == nil {
+		return nil
+	}
+	a.touch(f)
+	return f.m
+}
+
+// cacheImage caches m as the decoded image of f, unless another goroutine
+// did meanwhile, and returns the cached image.
+func (a *LazyAnimation) cacheImage(f *LazyFrame, m *image.NRGBA) *image.NRGBA {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if f.m == nil {
+		f.m = m
+		if len(a.cached) == a.maxCached {
+			a.cached[0].m = nil
+			a.cached = a.cached[1:]
+		}
+		a.cached = append(a.cached, f)
+	} else {
+		a.touch(f)
+	}
+	return f.m
+}
+
+// touch moves the cached frame f to the end of the cache.
+func (a *LazyAnimation) touch(f *LazyFrame) {
+	for i, c := range a.cached {
+		if c == f {
+			copy(a.cached[i:], a.cached[i+1:])
+			a.cached[len(a.cached)-1] = f
+			return
+		}
+	}
+}
+
+// LazyFrame is a frame of a LazyAnimation. It implements image.Image over
+// the rectangle of the canvas it covers, and decodes the frame on the first
+// access to its pixels.
+type LazyFrame struct {
+	a     *LazyAnimation
+	index int
+	data  []byte
+	rect  image.Rectangle
+
+	m *image.NRGBA // decoded image, guarded by a.mu
+}
+
+// Decode returns the decoded frame, from the cache if it is there. The
+// returned image must not be modified, it is shared with the other callers.
+// Decode is faster than going through At for more than a few pixels.
+//
+// Returns an error if the frame data is corrupt.
+func (f *LazyFrame) Decode() (*image.NRGBA, error) {
+	if m := f.a.cachedImage(f); m != nil {
+		return m, nil
+	}
+	pix, width, height, err := webpDecodeRGBA(f.data)
+	if err != nil {
+		return nil, fmt.Errorf("webp: LazyFrame, invalid or corrupt frame %d", f.index)
+	}
+	m := &image.NRGBA{
+		Pix:    pix,
+		Stride: 4 * width,
+		Rect:   image.Rect(f.rect.Min.X, f.rect.Min.Y, f.rect.Min.X+width, f.rect.Min.Y+height),
+	}
+	return f.a.cacheImage(f, m), nil
+}
+
+// IsDecoded reports whether the frame is currently decoded in the cache.
+func (f *LazyFrame) IsDecoded() bool {
+	f.a.mu.Lock()
+	defer f.a.mu.Unlock()
+	return f.m != nil
+}
+
+// ColorModel returns color.NRGBAModel.
+func (f *LazyFrame) ColorModel() color.Model {
+	return color.NRGBAModel
+}
+
+// Bounds returns the rectangle of the canvas covered by the frame, without
+// decoding it.
+func (f *LazyFrame) Bounds() image.Rectangle {
+	return f.rect
+}
+
+// At returns the color of the pixel at (x, y), decoding the frame if it is
+// not cached. A frame that fails to decode reads as transparent, use Decode
+// to get the error.
+func (f *LazyFrame) At(x, y int) color.Color {
+	m, err := f.Decode()
+	if err != nil {
+		return color.NRGBA{}
+	}
+	return m.NRGBAAt(x, y)
+}
@@ -0,0 +1,85 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+)
+
+func TestLazyAnimation(t *testing.T) {
+	frames := []Frame{
+		{Image: tNoiseImage(32, 24), Duration: 100, Lossless: true},
+		{Image: tNoiseImage(8, 6), X: 4, Y: 2, Duration: 250, Lossless: true, DisposeMode: DisposeModeBackground},
+		{Image: tNoiseImage(16, 8), X: 10, Y: 12, Duration: 50, Lossless: true, BlendMode: BlendModeNoBlend},
+	}
+	data, err := EncodeAnimationToBytes(frames, AnimationParams{LoopCount: 4})
+	tAssertNil(t, err)
+	want, err := DecodeAll(bytes.NewReader(data))
+	tAssertNil(t, err)
+
+	a, err := NewLazyAnimation(bytes.NewReader(data), 2)
+	tAssertNil(t, err)
+	tAssertEQ(t, 3, a.Len())
+	tAssertEQ(t, want.Delay, a.Delay)
+	tAssertEQ(t, want.Disposal, a.Disposal)
+	tAssertEQ(t, want.Blend, a.Blend)
+	tAssertEQ(t, want.LoopCount, a.LoopCount)
+	tAssertEQ(t, want.Config, a.Config)
+	for i := 0; i < a.Len(); i++ {
+		tAssert(t, !a.Frame(i).IsDecoded(), "frame", i, "decoded upfront")
+		tAssertEQ(t, want.Image[i].Bounds(), a.Frame(i).Bounds())
+	}
+
+	// The frames decode on access, and only the last two stay cached.
+	for i := 0; i < a.Len(); i++ {
+		var m image.Image = a.Frame(i)
+		b := m.Bounds()
+		tAssertEQ(t, want.Image[i].At(b.Min.X, b.Min.Y), m.At(b.Min.X, b.Min.Y))
+		decoded, err := a.Frame(i).Decode()
+		tAssertNil(t, err)
+		tAssertEQ(t, want.Image[i], decoded)
+	}
+	tAssert(t, !a.Frame(0).IsDecoded(), "least recently used frame kept")
+	tAssert(t, a.Frame(1).IsDecoded() && a.Frame(2).IsDecoded(), "recent frames evicted")
+
+	// Using frame 1 again makes frame 2 the one to evict.
+	_, err = a.Frame(1).Decode()
+	tAssertNil(t, err)
+	_, err = a.Frame(0).Decode()
+	tAssertNil(t, err)
+	tAssert(t, a.Frame(0).IsDecoded() && a.Frame(1).IsDecoded() && !a.Frame(2).IsDecoded(), "wrong frame evicted")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 12; i++ {
+				f := a.Frame((g + i) % a.Len())
+				m, err := f.Decode()
+				if err != nil || m.Bounds() != f.Bounds() {
+					t.Error("concurrent decode of frame", (g+i)%a.Len(), "failed:", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestLazyAnimation_still(t *testing.T) {
+	data, err := EncodeExactLosslessRGBA(createImage(8, 8, color.RGBA{0, 128, 0, 255}))
+	tAssertNil(t, err)
+	a, err := NewLazyAnimation(bytes.NewReader(data), 0)
+	tAssertNil(t, err)
+	tAssertEQ(t, 1, a.Len())
+	tAssertEQ(t, color.NRGBA{0, 128, 0, 255}, a.Frame(0).At(4, 4))
+
+	_, err = NewLazyAnimation(bytes.NewReader(data[:20]), 1)
+	tAssert(t, err != nil, "truncated data accepted")
+}
@@ -0,0 +1,1139 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tNoiseImage returns an image with pseudo-random pixels, which compresses
+// poorly and makes the effect of the encoding parameters visible.
+func tNoiseImage(width, height int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for i := range m.Pix {
+		seed = seed*1664525 + 1013904223
+		m.Pix[i] = uint8(seed >> 24)
+	}
+	for i := 3; i < len(m.Pix); i += 4 {
+		m.Pix[i] = 0xff
+	}
+	return m
+}
+
+func tEncodeFrameWithQuality(t *testing.T, frame Frame, quality float32) []byte {
+	t.Helper()
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+
+	tAssertNil(t, enc.AddFrameWithQuality(frame, quality))
+	var buf bytes.Buffer
+	tAssertNil(t, enc.Encode(&buf))
+	return buf.Bytes()
+}
+
+func TestAnimationEncoder_AddFrameWithQuality(t *testing.T) {
+	frame := Frame{Image: tNoiseImage(64, 64), Duration: 100}
+
+	low := tEncodeFrameWithQuality(t, frame, 10)
+	high := tEncodeFrameWithQuality(t, frame, 95)
+	tAssert(t, len(low) < len(high), "low quality should be smaller: ", len(low), " >= ", len(high))
+}
+
+func TestAnimationEncoder_AddFrameWithQuality_outOfRange(t *testing.T) {
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+
+	frame := Frame{Image: createImage(16, 16, color.RGBA{255, 0, 0, 255}), Duration: 100}
+	for _, quality := range []float32{-1, 100.5, 200} {
+		tAssert(t, enc.AddFrameWithQuality(frame, quality) != nil, "quality: ", quality)
+	}
+	tAssertNil(t, enc.AddFrameWithQuality(frame, 0))
+	tAssertNil(t, enc.AddFrameWithQuality(frame, 100))
+}
+
+func TestAnimationEncoder_AddFrame_lossless(t *testing.T) {
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+
+	lossless := tNoiseImage(32, 32)
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: lossless, Duration: 100, Lossless: true}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(32, 32), Duration: 100}))
+
+	var buf bytes.Buffer
+	tAssertNil(t, enc.Encode(&buf))
+	tAssert(t, bytes.Contains(buf.Bytes(), []byte("VP8L")), "missing lossless frame")
+	tAssert(t, bytes.Contains(buf.Bytes(), []byte("VP8 ")), "missing lossy frame")
+
+	// The lossless frame round-trips exactly.
+	dec, err := NewAnimationDecoder(&buf)
+	tAssertNil(t, err)
+	defer dec.Close()
+	m, _, err := dec.Next()
+	tAssertNil(t, err)
+	tAssertEQ(t, lossless.Pix, m.(*image.RGBA).Pix)
+}
+
+func TestAnimationEncoder_SetEXIF(t *testing.T) {
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00")
+
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{}))
+	tAssertNil(t, enc.SetEXIF(exif))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 100}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 100}))
+
+	var buf bytes.Buffer
+	tAssertNil(t, enc.Encode(&buf))
+
+	metadata, err := GetMetadata(buf.Bytes(), "EXIF")
+	tAssertNil(t, err)
+	tAssertEQ(t, exif, metadata)
+
+	// Empty data removes the chunk.
+	tAssertNil(t, enc.SetEXIF(nil))
+	buf.Reset()
+	tAssertNil(t, enc.Encode(&buf))
+	_, err = GetMetadata(buf.Bytes(), "EXIF")
+	tAssert(t, err != nil, "EXIF chunk not removed")
+}
+
+func TestAnimationEncoder_SetXMP(t *testing.T) {
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`)
+
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{}))
+	tAssertNil(t, enc.SetXMP(xmp))
+	tAssertNil(t, enc.SetEXIF([]byte("exif")))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 100}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 100}))
+
+	var buf bytes.Buffer
+	tAssertNil(t, enc.Encode(&buf))
+
+	metadata, err := GetMetadata(buf.Bytes(), "XMP")
+	tAssertNil(t, err)
+	tAssertEQ(t, xmp, metadata)
+
+	metadata, err = GetMetadata(buf.Bytes(), "EXIF")
+	tAssertNil(t, err)
+	tAssertEQ(t, []byte("exif"), metadata)
+}
+
+func TestAnimationEncoder_AddFrameWithOptions(t *testing.T) {
+	frame := Frame{Image: tNoiseImage(64, 64), Duration: 100}
+
+	encodeWithOptions := func(opt *Options) []byte {
+		enc := NewAnimationEncoder()
+		defer enc.Close()
+
+		tAssertNil(t, enc.AddFrameWithOptions(frame, opt))
+		var buf bytes.Buffer
+		tAssertNil(t, enc.Encode(&buf))
+		return buf.Bytes()
+	}
+
+	fast := encodeWithOptions(&Options{Quality: 75, Method: MethodFastest})
+	best := encodeWithOptions(&Options{Quality: 75, Method: 6})
+	tAssert(t, !bytes.Equal(fast, best), "method has no effect")
+	tAssertEQ(t, encodeWithOptions(nil), tEncodeFrameWithQuality(t, frame, DefaulQuality))
+
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	tAssert(t, enc.AddFrameWithOptions(frame, &Options{Quality: 75, Method: 7}) != nil)
+}
+
+func TestEncodeContext(t *testing.T) {
+	frames := []Frame{
+		{Image: tNoiseImage(16, 16), Duration: 100},
+		{Image: tNoiseImage(16, 16), Duration: 100},
+	}
+
+	var buf bytes.Buffer
+	tAssertNil(t, EncodeContext(context.Background(), &buf, frames, AnimationParams{}))
+	tAssert(t, buf.Len() > 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf.Reset()
+	tAssertEQ(t, context.Canceled, EncodeContext(ctx, &buf, frames, AnimationParams{}))
+	tAssertEQ(t, 0, buf.Len(), "partial output written")
+
+	// Cancel while the frames are being encoded.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	cancelling := []Frame{frames[0], {Image: tCancelImage{frames[1].Image, cancel}, Duration: 100}, frames[1]}
+	buf.Reset()
+	tAssertEQ(t, context.Canceled, EncodeContext(ctx, &buf, cancelling, AnimationParams{}))
+	tAssertEQ(t, 0, buf.Len(), "partial output written")
+}
+
+// tCancelImage cancels a context when its bounds are queried for encoding.
+type tCancelImage struct {
+	image.Image
+	cancel context.CancelFunc
+}
+
+func (m tCancelImage) Bounds() image.Rectangle {
+	m.cancel()
+	return m.Image.Bounds()
+}
+
+func TestAnimationEncoder_Reset(t *testing.T) {
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{BackgroundColor: 0xFF102030, LoopCount: 7}))
+	tAssertNil(t, enc.SetEXIF([]byte("exif")))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(32, 32), Duration: 100}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(32, 32), Duration: 100}))
+	var buf bytes.Buffer
+	tAssertNil(t, enc.Encode(&buf))
+
+	frame := Frame{Image: createImage(16, 16, color.RGBA{255, 0, 0, 255}), Duration: 100}
+	enc.Reset()
+	tAssertNil(t, enc.AddFrame(frame))
+	buf.Reset()
+	tAssertNil(t, enc.Encode(&buf))
+
+	fresh := NewAnimationEncoder()
+	defer fresh.Close()
+	tAssertNil(t, fresh.AddFrame(frame))
+	var want bytes.Buffer
+	tAssertNil(t, fresh.Encode(&want))
+	tAssertEQ(t, want.Bytes(), buf.Bytes())
+
+	enc.Close()
+	enc.Reset()
+	tAssert(t, enc.AddFrame(frame) != nil, "closed encoder reused after Reset")
+}
+
+func TestEncodeAnimationN(t *testing.T) {
+	frames := []Frame{
+		{Image: tNoiseImage(16, 16), Duration: 100},
+		{Image: tNoiseImage(16, 16), Duration: 100},
+	}
+
+	var buf bytes.Buffer
+	n, err := EncodeAnimationN(&buf, frames, AnimationParams{})
+	tAssertNil(t, err)
+	tAssertEQ(t, buf.Len(), n)
+
+	data, err := EncodeAnimationToBytes(frames, AnimationParams{})
+	tAssertNil(t, err)
+	tAssertEQ(t, len(data), n)
+
+	n, err = EncodeAnimationN(tErrWriter{}, frames, AnimationParams{})
+	tAssertEQ(t, 0, n)
+	tAssert(t, err != nil)
+}
+
+func TestAnimationEncoder_AddFrame_canvasBounds(t *testing.T) {
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(32, 32), Duration: 100}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), X: 16, Y: 16, Duration: 100}))
+	// Odd offsets are rounded down, so the frame still fits.
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), X: 17, Y: 17, Duration: 100}))
+
+	for _, frame := range []Frame{
+		{Image: tNoiseImage(48, 32), Duration: 100},
+		{Image: tNoiseImage(32, 48), Duration: 100},
+		{Image: tNoiseImage(16, 16), X: 18, Duration: 100},
+		{Image: tNoiseImage(16, 16), X: -2, Duration: 100},
+	} {
+		tAssert(t, enc.AddFrame(frame) != nil, "frame at ", frame.X, ",", frame.Y, " accepted")
+	}
+
+	// Reset forgets the canvas size.
+	enc.Reset()
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(48, 48), Duration: 100}))
+}
+
+func TestAnimationEncoder_AddFrame_oddOffsets(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	// The blue 4x4 frame at (5, 3) is drawn over the red 16x16 frame.
+	encode := func(oddOffsets int, workers int) (image.Image, error) {
+		frames := []Frame{
+			{Image: createImage(16, 16, red), Duration: 100, Lossless: true},
+			{Image: createImage(4, 4, blue), X: 5, Y: 3, Duration: 100, Lossless: true},
+		}
+		var buf bytes.Buffer
+		if err := EncodeAnimationConcurrent(&buf, frames, AnimationParams{OddOffsets: oddOffsets}, workers); err != nil {
+			return nil, err
+		}
+		dec, err := NewAnimationDecoder(&buf)
+		tAssertNil(t, err)
+		defer dec.Close()
+		tAssertNil(t, dec.Seek(1))
+		m, _, err := dec.Next()
+		return m, err
+	}
+	at := func(m image.Image, x, y int) color.RGBA {
+		return color.RGBAModel.Convert(m.At(x, y)).(color.RGBA)
+	}
+
+	for _, workers := range []int{1, 2} {
+		// Rounded down, the frame covers (4, 2) to (8, 6).
+		m, err := encode(OddOffsetRoundDown, workers)
+		tAssertNil(t, err)
+		tAssertEQ(t, blue, at(m, 4, 2))
+		tAssertEQ(t, blue, at(m, 7, 5))
+		tAssertEQ(t, red, at(m, 8, 6))
+
+		_, err = encode(OddOffsetError, workers)
+		tAssert(t, err != nil, "odd offset accepted")
+
+		// Padded, the frame covers (5, 3) to (9, 7).
+		m, err = encode(OddOffsetPad, workers)
+		tAssertNil(t, err)
+		tAssertEQ(t, red, at(m, 4, 2))
+		tAssertEQ(t, red, at(m, 4, 5))
+		tAssertEQ(t, blue, at(m, 5, 3))
+		tAssertEQ(t, blue, at(m, 8, 6))
+		tAssertEQ(t, red, at(m, 9, 7))
+	}
+
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{OddOffsets: OddOffsetPad}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 100}))
+	tAssert(t, enc.AddFrame(Frame{Image: tNoiseImage(4, 4), X: 1, BlendMode: BlendModeNoBlend}) != nil, "padded frame without blending accepted")
+	tAssert(t, enc.AddFrame(Frame{Image: tNoiseImage(4, 4), X: 1, DisposeMode: DisposeModeBackground}) != nil, "padded frame disposed to background accepted")
+	tAssert(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), X: 1}) != nil, "padded frame outside the canvas accepted")
+	tAssert(t, enc.SetAnimationParams(AnimationParams{OddOffsets: 3}) != nil, "invalid odd offset handling accepted")
+}
+
+func TestAnimationEncoder_SetAnimationParams_canvas(t *testing.T) {
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{CanvasWidth: 64, CanvasHeight: 48}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 100}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), X: 48, Y: 32, Duration: 100}))
+	tAssert(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), X: 50, Duration: 100}) != nil, "frame outside the canvas accepted")
+
+	var buf bytes.Buffer
+	tAssertNil(t, enc.Encode(&buf))
+
+	dec, err := NewAnimationDecoder(&buf)
+	tAssertNil(t, err)
+	defer dec.Close()
+	params, width, height := dec.Info()
+	tAssertEQ(t, 64, width)
+	tAssertEQ(t, 48, height)
+	tAssertEQ(t, 64, params.CanvasWidth)
+	tAssertEQ(t, 48, params.CanvasHeight)
+
+	for _, params := range []AnimationParams{
+		{CanvasWidth: 64},
+		{CanvasHeight: 48},
+		{CanvasWidth: -1, CanvasHeight: 48},
+	} {
+		tAssert(t, enc.SetAnimationParams(params) != nil, "canvas ", params.CanvasWidth, "x", params.CanvasHeight, " accepted")
+	}
+}
+
+func TestEncodeAnimationWithProgress(t *testing.T) {
+	frames := []Frame{
+		{Image: tNoiseImage(16, 16), Duration: 100},
+		{Image: tNoiseImage(16, 16), Duration: 100},
+		{Image: tNoiseImage(16, 16), Duration: 100},
+	}
+
+	var calls [][2]int
+	progress := func(frameIndex, totalFrames int) {
+		calls = append(calls, [2]int{frameIndex, totalFrames})
+	}
+	var buf bytes.Buffer
+	tAssertNil(t, EncodeAnimationWithProgress(&buf, frames, AnimationParams{}, progress))
+	tAssertEQ(t, [][2]int{{0, 3}, {1, 3}, {2, 3}}, calls)
+
+	// Progress stops at the frame that fails.
+	calls = nil
+	frames[1].X = 1
+	err := EncodeAnimationWithProgress(&buf, frames, AnimationParams{OddOffsets: OddOffsetError}, progress)
+	tAssert(t, err != nil)
+	tAssertEQ(t, [][2]int{{0, 3}}, calls)
+}
+
+func TestEncodeAnimationConcurrent(t *testing.T) {
+	var frames []Frame
+	for i := 0; i < 8; i++ {
+		frames = append(frames, Frame{Image: tNoiseImage(32+i*2, 32), Duration: 100, Lossless: i%2 == 0})
+	}
+	frames[0].Image = tNoiseImage(64, 64)
+
+	var want bytes.Buffer
+	tAssertNil(t, EncodeAnimation(&want, frames, AnimationParams{LoopCount: 2}))
+
+	for _, workers := range []int{0, 1, 3, 16} {
+		var buf bytes.Buffer
+		tAssertNil(t, EncodeAnimationConcurrent(&buf, frames, AnimationParams{LoopCount: 2}, workers), "workers ", workers)
+		tAssertEQ(t, want.Bytes(), buf.Bytes(), "workers ", workers)
+	}
+
+	frames[5].X = 64
+	err := EncodeAnimationConcurrent(&bytes.Buffer{}, frames, AnimationParams{}, 4)
+	tAssert(t, err != nil, "frame outside the canvas accepted")
+}
+
+func TestAnimationEncoder_AddFrame_noImage(t *testing.T) {
+	for _, params := range []AnimationParams{
+		{},
+		{CanvasWidth: 16, CanvasHeight: 16},
+		{OddOffsets: OddOffsetPad},
+	} {
+		enc := NewAnimationEncoder()
+		tAssertNil(t, enc.SetAnimationParams(params))
+		for _, frame := range []Frame{{}, {X: 1, Y: 1, Duration: 100}} {
+			err := enc.AddFrame(frame)
+			tAssert(t, err != nil && strings.Contains(err.Error(), "no image"), params, "returned", err)
+		}
+		enc.Close()
+	}
+	err := EncodeAnimation(&bytes.Buffer{}, []Frame{{Image: tNoiseImage(8, 8)}, {}}, AnimationParams{})
+	tAssert(t, err != nil && strings.Contains(err.Error(), "no image"), err)
+}
+
+func TestAnimationEncoder_AddFrame_invalidModes(t *testing.T) {
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+
+	for _, frame := range []Frame{
+		{Image: tNoiseImage(16, 16), DisposeMode: 2},
+		{Image: tNoiseImage(16, 16), DisposeMode: -2},
+		{Image: tNoiseImage(16, 16), BlendMode: 2},
+	} {
+		tAssert(t, enc.AddFrame(frame) != nil, "modes ", frame.DisposeMode, ",", frame.BlendMode, " accepted")
+		err := EncodeAnimation(&bytes.Buffer{}, []Frame{{Image: tNoiseImage(16, 16)}, frame}, AnimationParams{})
+		tAssert(t, err != nil, "modes ", frame.DisposeMode, ",", frame.BlendMode, " accepted")
+		err = EncodeAnimationConcurrent(&bytes.Buffer{}, []Frame{{Image: tNoiseImage(16, 16)}, frame}, AnimationParams{}, 2)
+		tAssert(t, err != nil, "modes ", frame.DisposeMode, ",", frame.BlendMode, " accepted")
+	}
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), DisposeMode: DisposeModeBackground, BlendMode: BlendModeNoBlend}))
+}
+
+func TestAnimationEncoder_Bytes(t *testing.T) {
+	enc := NewAnimationEncoder()
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 100}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 100}))
+
+	data, err := enc.Bytes()
+	tAssertNil(t, err)
+	var buf bytes.Buffer
+	tAssertNil(t, enc.Encode(&buf))
+	tAssertEQ(t, buf.Bytes(), data)
+
+	enc.Close()
+	_, err = enc.Bytes()
+	tAssert(t, err != nil, "closed encoder assembled")
+}
+
+func TestAnimationEncoder_WriteTo(t *testing.T) {
+	enc := NewAnimationEncoder()
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 100}))
+
+	var w io.WriterTo = enc
+	var buf bytes.Buffer
+	n, err := w.WriteTo(&buf)
+	tAssertNil(t, err)
+	data, err := enc.Bytes()
+	tAssertNil(t, err)
+	tAssertEQ(t, int64(len(data)), n)
+	tAssertEQ(t, data, buf.Bytes())
+
+	enc.Close()
+	_, err = enc.WriteTo(&buf)
+	tAssert(t, err != nil, "closed encoder written")
+}
+
+func TestAnimationParams_DefaultFrameDuration(t *testing.T) {
+	frames := []Frame{
+		{Image: tNoiseImage(16, 16)},
+		{Image: tNoiseImage(16, 16), Duration: 250},
+		{Image: tNoiseImage(16, 16)},
+	}
+	for _, v := range []struct {
+		defaultDuration int
+		want            []int
+	}{
+		{0, []int{0, 250, 0}},
+		{100, []int{100, 250, 100}},
+	} {
+		data, err := EncodeAnimationToBytes(frames, AnimationParams{DefaultFrameDuration: v.defaultDuration})
+		tAssertNil(t, err)
+		raw := tRawFrames(t, data)
+		for i, frame := range raw {
+			tAssertEQ(t, v.want[i], frame.Duration, "frame ", i)
+		}
+	}
+
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	tAssert(t, enc.SetAnimationParams(AnimationParams{DefaultFrameDuration: -1}) != nil, "negative default duration accepted")
+	tAssert(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: -1}) != nil, "negative duration accepted")
+	tAssert(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 1 << 24}) != nil, "duration out of range accepted")
+}
+
+// tRawFrames returns the frames of the animation data, without decoding them.
+func tRawFrames(t *testing.T, data []byte) []RawFrame {
+	t.Helper()
+	dec, err := NewAnimationDecoder(bytes.NewReader(data))
+	tAssertNil(t, err)
+	defer dec.Close()
+	frames, err := dec.RawFrames()
+	tAssertNil(t, err)
+	return frames
+}
+
+func TestAnimationEncoder_AppendAnimation(t *testing.T) {
+	clip := func(n int, width, height int) []byte {
+		var frames []Frame
+		for i := 0; i < n; i++ {
+			frames = append(frames, Frame{Image: tNoiseImage(width, height), Duration: 100 * (i + 1)})
+		}
+		frames = append(frames, Frame{Image: tNoiseImage(8, 8), X: 4, Y: 2, Duration: 50, BlendMode: BlendModeNoBlend})
+		data, err := EncodeAnimationToBytes(frames, AnimationParams{})
+		tAssertNil(t, err)
+		return data
+	}
+	first, second := clip(2, 32, 24), clip(3, 32, 24)
+
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{}))
+	tAssertNil(t, enc.AppendAnimation(first))
+	tAssertNil(t, enc.AppendAnimation(second))
+	tAssert(t, enc.AppendAnimation(clip(1, 16, 16)) != nil, "mismatched canvas accepted")
+	tAssert(t, enc.AppendAnimation([]byte("garbage")) != nil, "invalid data accepted")
+	data, err := enc.Bytes()
+	tAssertNil(t, err)
+
+	// The frames are copied as is.
+	want := append(tRawFrames(t, first), tRawFrames(t, second)...)
+	got := tRawFrames(t, data)
+	tAssertEQ(t, len(want), len(got))
+	for i := range want {
+		tAssertEQ(t, want[i], got[i], "frame ", i)
+	}
+
+	info, err := Inspect(bytes.NewReader(data))
+	tAssertNil(t, err)
+	tAssertEQ(t, 32, info.Width)
+	tAssertEQ(t, 24, info.Height)
+}
+
+func TestAnimationParams_KeyframeInterval(t *testing.T) {
+	var frames []Frame
+	for i := 0; i < 7; i++ {
+		frames = append(frames, Frame{Image: tNoiseImage(16, 16), Duration: 100})
+	}
+	for _, workers := range []int{1, 2} {
+		var buf bytes.Buffer
+		tAssertNil(t, EncodeAnimationConcurrent(&buf, frames, AnimationParams{KeyframeInterval: 3}, workers))
+		for i, frame := range tRawFrames(t, buf.Bytes()) {
+			if i%3 == 0 {
+				tAssertEQ(t, DisposeModeBackground, frame.DisposeMode, "frame ", i)
+				tAssertEQ(t, BlendModeNoBlend, frame.BlendMode, "frame ", i)
+			} else {
+				tAssertEQ(t, DisposeModeNone, frame.DisposeMode, "frame ", i)
+				tAssertEQ(t, BlendModeBlend, frame.BlendMode, "frame ", i)
+			}
+		}
+	}
+
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	tAssert(t, enc.SetAnimationParams(AnimationParams{KeyframeInterval: -1}) != nil, "negative keyframe interval accepted")
+}
+
+func TestAnimationEncoder_AddFrame_paletted(t *testing.T) {
+	palette := color.Palette{
+		color.RGBA{0, 0, 0, 0},
+		color.RGBA{255, 200, 0, 255},
+		color.RGBA{0, 90, 200, 255},
+		color.RGBA{250, 250, 250, 255},
+	}
+	m := image.NewPaletted(image.Rect(0, 0, 48, 32), palette)
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			m.SetColorIndex(x, y, uint8((x/8+y/8)%len(palette)))
+		}
+	}
+
+	// The fast conversion matches the generic one.
+	rgba := toRGBAImage(m)
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			tAssertEQ(t, color.RGBAModel.Convert(m.At(x, y)), rgba.At(x, y))
+		}
+	}
+
+	encode := func(add func(enc *AnimationEncoder) error) []byte {
+		enc := NewAnimationEncoder()
+		defer enc.Close()
+		tAssertNil(t, add(enc))
+		data, err := enc.Bytes()
+		tAssertNil(t, err)
+		return data
+	}
+	frame := Frame{Image: m, Duration: 100}
+	auto := encode(func(enc *AnimationEncoder) error { return enc.AddFrame(frame) })
+	tAssert(t, bytes.Contains(auto, []byte("VP8L")), "paletted frame not encoded losslessly")
+
+	dec, err := NewAnimationDecoder(bytes.NewReader(auto))
+	tAssertNil(t, err)
+	defer dec.Close()
+	got, _, err := dec.Next()
+	tAssertNil(t, err)
+	tAssertEQ(t, rgba.Pix, got.(*image.RGBA).Pix)
+
+	// Explicit options are kept.
+	lossy := encode(func(enc *AnimationEncoder) error { return enc.AddFrameWithQuality(frame, 75) })
+	tAssert(t, bytes.Contains(lossy, []byte("VP8 ")), "paletted frame not encoded with the options")
+}
+
+func TestRetimeAnimation(t *testing.T) {
+	frames := []Frame{
+		{Image: tNoiseImage(16, 16), Duration: 100},
+		{Image: tNoiseImage(16, 16), Duration: 1},
+		{Image: tNoiseImage(16, 16), Duration: 0},
+	}
+	data, err := EncodeAnimationToBytes(frames, AnimationParams{LoopCount: 3, BackgroundColor: 0xff102030})
+	tAssertNil(t, err)
+	data, err = SetMetadata(data, []byte("exif"), "EXIF")
+	tAssertNil(t, err)
+
+	fast, err := RetimeAnimation(data, 0.25)
+	tAssertNil(t, err)
+	before, after := tRawFrames(t, data), tRawFrames(t, fast)
+	tAssertEQ(t, len(before), len(after))
+	for i, want := range []int{25, 1, 0} {
+		tAssertEQ(t, want, after[i].Duration, "frame ", i)
+		tAssertEQ(t, before[i].Data, after[i].Data, "frame ", i)
+	}
+
+	dec, err := NewAnimationDecoder(bytes.NewReader(fast))
+	tAssertNil(t, err)
+	params, _, _ := dec.Info()
+	dec.Close()
+	tAssertEQ(t, 3, params.LoopCount)
+	tAssertEQ(t, uint32(0xff102030), params.BackgroundColor)
+	exif, err := GetMetadata(fast, "EXIF")
+	tAssertNil(t, err)
+	tAssertEQ(t, []byte("exif"), exif)
+
+	slow, err := RetimeAnimation(data, 2.5)
+	tAssertNil(t, err)
+	tAssertEQ(t, 250, tRawFrames(t, slow)[0].Duration)
+	tAssertEQ(t, 3, tRawFrames(t, slow)[1].Duration)
+
+	for _, factor := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		_, err := RetimeAnimation(data, factor)
+		tAssert(t, err != nil, "factor accepted: ", factor)
+	}
+}
+
+func TestFrame_Clone(t *testing.T) {
+	buf := tNoiseImage(8, 8)
+	frame := Frame{Image: buf, Duration: 100}
+	c := frame.Clone()
+	tAssertEQ(t, 100, c.Duration)
+	for i := range buf.Pix {
+		buf.Pix[i] = 0
+	}
+	m, ok := c.Image.(*image.RGBA)
+	tAssert(t, ok, "type: ", c.Image.Bounds())
+	tAssert(t, m.Pix[0] != 0 || m.Pix[1] != 0 || m.Pix[2] != 0, "clone shares the pixels")
+
+	p := image.NewPaletted(image.Rect(2, 2, 6, 6), color.Palette{color.Black, color.White})
+	c = Frame{Image: p}.Clone()
+	cp, ok := c.Image.(*image.Paletted)
+	tAssert(t, ok, "type: ", c.Image.Bounds())
+	tAssertEQ(t, p.Rect, cp.Rect)
+	p.Palette[0] = color.White
+	tAssertEQ(t, color.Color(color.Black), cp.Palette[0])
+
+	c = Frame{Image: image.NewGray16(image.Rect(0, 0, 2, 2))}.Clone()
+	_, ok = c.Image.(*image.RGBA)
+	tAssert(t, ok, "type: ", c.Image.Bounds())
+}
+
+func TestAnimationEncoder_SetFeatureFlags(t *testing.T) {
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(8, 8), Duration: 100}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(8, 8), Duration: 100}))
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{}))
+
+	data, err := enc.Bytes()
+	tAssertNil(t, err)
+	info, err := Inspect(bytes.NewReader(data))
+	tAssertNil(t, err)
+	tAssertEQ(t, uint32(FlagAnimation), info.Flags)
+
+	tAssertNil(t, enc.SetFeatureFlags(FlagAnimation|FlagAlpha|FlagEXIF))
+	data, err = enc.Bytes()
+	tAssertNil(t, err)
+	info, err = Inspect(bytes.NewReader(data))
+	tAssertNil(t, err)
+	tAssertEQ(t, uint32(FlagAnimation|FlagAlpha|FlagEXIF), info.Flags)
+
+	tAssert(t, enc.SetFeatureFlags(FlagAlpha) != nil, "flags without FlagAnimation accepted")
+	tAssert(t, enc.SetFeatureFlags(FlagAnimation|0x01) != nil, "unknown flags accepted")
+
+	tAssertNil(t, enc.SetFeatureFlags(0))
+	data, err = enc.Bytes()
+	tAssertNil(t, err)
+	info, err = Inspect(bytes.NewReader(data))
+	tAssertNil(t, err)
+	tAssertEQ(t, uint32(FlagAnimation), info.Flags)
+}
+
+func TestAnimationEncoder_SetLoopCount(t *testing.T) {
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{LoopCount: 2, BackgroundColor: 0xff000000}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(8, 8), Duration: 100}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(8, 8), Duration: 100}))
+
+	// Set after the frames, the final call wins.
+	tAssertNil(t, enc.SetLoopCount(3))
+	tAssertNil(t, enc.SetBackgroundColor(0xffffffff))
+	data, err := enc.Bytes()
+	tAssertNil(t, err)
+	info, err := Inspect(bytes.NewReader(data))
+	tAssertNil(t, err)
+	tAssertEQ(t, 3, info.LoopCount)
+	tAssertEQ(t, uint32(0xffffffff), info.BackgroundColor)
+
+	tAssert(t, enc.SetLoopCount(-1) != nil, "negative loop count accepted")
+	tAssert(t, enc.SetLoopCount(1<<16) != nil, "too large loop count accepted")
+	tAssert(t, enc.SetAnimationParams(AnimationParams{LoopCount: 1 << 16}) != nil, "too large loop count accepted")
+
+	// The invalid calls keep the previous values.
+	data, err = enc.Bytes()
+	tAssertNil(t, err)
+	info, err = Inspect(bytes.NewReader(data))
+	tAssertNil(t, err)
+	tAssertEQ(t, 3, info.LoopCount)
+
+	enc.Close()
+	tAssert(t, enc.SetLoopCount(1) != nil, "closed encoder accepted the loop count")
+}
+
+func TestAnimationEncoder_SetDefaultDispose(t *testing.T) {
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	tAssertNil(t, enc.SetDefaultDispose(DisposeModeBackground))
+	tAssertNil(t, enc.SetDefaultBlend(BlendModeNoBlend))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(8, 8), Duration: 100}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(8, 8), Duration: 100,
+		DisposeModeSet: true, BlendModeSet: true}))
+	tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(8, 8), Duration: 100, BlendModeSet: true}))
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{}))
+
+	data, err := enc.Bytes()
+	tAssertNil(t, err)
+	frames := tRawFrames(t, data)
+	tAssertEQ(t, 3, len(frames))
+	want := [][2]int{
+		{DisposeModeBackground, BlendModeNoBlend},
+		{DisposeModeNone, BlendModeBlend},
+		{DisposeModeBackground, BlendModeBlend},
+	}
+	for i, f := range frames {
+		tAssertEQ(t, want[i], [2]int{f.DisposeMode, f.BlendMode})
+	}
+
+	tAssert(t, enc.SetDefaultDispose(-1) != nil, "invalid dispose mode accepted")
+	tAssert(t, enc.SetDefaultBlend(2) != nil, "invalid blend mode accepted")
+	tAssert(t, enc.AddFrame(Frame{Image: tNoiseImage(8, 8), DisposeMode: -1}) != nil, "invalid dispose mode accepted")
+	tAssert(t, enc.AddFrame(Frame{Image: tNoiseImage(8, 8), BlendMode: -1, BlendModeSet: true}) != nil, "invalid blend mode accepted")
+
+	// Without defaults, the set modes are used as is.
+	var buf bytes.Buffer
+	err = EncodeAnimationConcurrent(&buf, []Frame{
+		{Image: tNoiseImage(8, 8), Duration: 100, DisposeModeSet: true},
+		{Image: tNoiseImage(8, 8), Duration: 100, DisposeMode: DisposeModeBackground, BlendModeSet: true},
+	}, AnimationParams{}, 2)
+	tAssertNil(t, err)
+	want = [][2]int{
+		{DisposeModeNone, BlendModeBlend},
+		{DisposeModeBackground, BlendModeBlend},
+	}
+	for i, f := range tRawFrames(t, buf.Bytes()) {
+		tAssertEQ(t, want[i], [2]int{f.DisposeMode, f.BlendMode})
+	}
+}
+
+func TestEncodeAll(t *testing.T) {
+	// The second frame is placed at (4, 2).
+	m := tNoiseImage(8, 6)
+	m.Rect = m.Rect.Add(image.Pt(4, 2))
+	a := &Animation{
+		Image:           []image.Image{tNoiseImage(32, 24), m},
+		Delay:           []int{100, 250},
+		Disposal:        []int{DisposeModeNone, DisposeModeBackground},
+		LoopCount:       2,
+		BackgroundColor: 0xff000000,
+		Config:          image.Config{Width: 40, Height: 30},
+	}
+
+	var buf bytes.Buffer
+	tAssertNil(t, EncodeAll(&buf, a))
+	got, err := DecodeAll(&buf)
+	tAssertNil(t, err)
+	tAssertEQ(t, a.Delay, got.Delay)
+	tAssertEQ(t, a.Disposal, got.Disposal)
+	tAssertEQ(t, []int{BlendModeBlend, BlendModeBlend}, got.Blend)
+	tAssertEQ(t, 2, got.LoopCount)
+	tAssertEQ(t, uint32(0xff000000), got.BackgroundColor)
+	tAssert(t, got.Config.Width == 40 && got.Config.Height == 30, "canvas: ", got.Config.Width, got.Config.Height)
+	tAssertEQ(t, image.Rect(4, 2, 12, 8), got.Image[1].Bounds())
+
+	// A decoded animation round-trips.
+	buf.Reset()
+	tAssertNil(t, EncodeAll(&buf, got))
+	again, err := DecodeAll(&buf)
+	tAssertNil(t, err)
+	tAssertEQ(t, got.Delay, again.Delay)
+	tAssertEQ(t, got.Blend, again.Blend)
+
+	a.Delay = a.Delay[:1]
+	tAssert(t, EncodeAll(&bytes.Buffer{}, a) != nil, "mismatched delays accepted")
+	a.Delay = []int{100, 250}
+	a.Blend = []int{BlendModeBlend}
+	tAssert(t, EncodeAll(&bytes.Buffer{}, a) != nil, "mismatched blends accepted")
+	tAssert(t, EncodeAll(&bytes.Buffer{}, &Animation{}) != nil, "empty animation accepted")
+	tAssert(t, EncodeAll(&bytes.Buffer{}, nil) != nil, "nil animation accepted")
+
+	// The frames are placed at the origin of their bounds, which must be on
+	// the canvas.
+	a.Blend = nil
+	a.Image[1] = nil
+	tAssert(t, EncodeAll(&bytes.Buffer{}, a) != nil, "nil image accepted")
+	m.Rect = image.Rect(36, 26, 44, 32)
+	a.Image[1] = m
+	err = EncodeAll(&bytes.Buffer{}, a)
+	tAssert(t, err != nil && strings.Contains(err.Error(), "does not fit the 40x30 canvas"), "frame beyond the canvas accepted: ", err)
+	m.Rect = image.Rect(-2, 0, 6, 6)
+	err = EncodeAll(&bytes.Buffer{}, a)
+	tAssert(t, err != nil && strings.Contains(err.Error(), "must not be negative"), "negative offset accepted: ", err)
+}
+
+func TestEncodeImages(t *testing.T) {
+	imgs := []image.Image{tNoiseImage(16, 12), tNoiseImage(16, 12), tNoiseImage(16, 12)}
+	// Sub-images are placed at the origin of the canvas.
+	imgs[1] = tNoiseImage(20, 20).SubImage(image.Rect(2, 4, 18, 16))
+
+	var buf bytes.Buffer
+	tAssertNil(t, EncodeImages(&buf, imgs, 40, AnimationParams{LoopCount: 1}))
+	a, err := DecodeAll(&buf)
+	tAssertNil(t, err)
+	tAssertEQ(t, []int{40, 40, 40}, a.Delay)
+	tAssertEQ(t, []int{DisposeModeBackground, DisposeModeBackground, DisposeModeBackground}, a.Disposal)
+	for _, m := range a.Image {
+		tAssertEQ(t, image.Rect(0, 0, 16, 12), m.Bounds())
+	}
+
+	imgs[2] = tNoiseImage(16, 10)
+	err = EncodeImages(&bytes.Buffer{}, imgs, 40, AnimationParams{})
+	tAssert(t, err != nil, "images of different sizes accepted")
+	tAssert(t, strings.Contains(err.Error(), "image 2 is 16x10"), err)
+	tAssert(t, EncodeImages(&bytes.Buffer{}, imgs[:2], -1, AnimationParams{}) != nil, "invalid duration accepted")
+	tAssert(t, EncodeImages(&bytes.Buffer{}, nil, 40, AnimationParams{}) != nil, "no images accepted")
+}
+
+func TestEncodeAnimation_frameSizes(t *testing.T) {
+	// Smaller frames within the canvas of the first one are sub-frames.
+	frames := []Frame{
+		{Image: tNoiseImage(16, 16), Duration: 100},
+		{Image: tNoiseImage(8, 8), X: 8, Y: 8, Duration: 100},
+		{Image: tNoiseImage(16, 18), Duration: 100},
+	}
+	tAssertNil(t, EncodeAnimation(&bytes.Buffer{}, frames[:2], AnimationParams{}))
+
+	// Frames beyond it are reported before any frame is encoded.
+	var progress []int
+	err := EncodeAnimationWithProgress(&bytes.Buffer{}, frames, AnimationParams{}, func(i, n int) {
+		progress = append(progress, i)
+	})
+	tAssert(t, err != nil, "frame outside the canvas accepted")
+	tAssert(t, strings.Contains(err.Error(), "frame 2 of 16x18"), err)
+	tAssertEQ(t, 0, len(progress))
+
+	// An explicit canvas may be larger than the first frame.
+	tAssertNil(t, EncodeAnimation(&bytes.Buffer{}, frames, AnimationParams{CanvasWidth: 16, CanvasHeight: 18}))
+}
+
+func TestAnimationEncoder_ErrEncoderClosed(t *testing.T) {
+	enc := NewAnimationEncoder()
+	enc.Close()
+	enc.Close()
+
+	frame := Frame{Image: tNoiseImage(8, 8), Duration: 100}
+	_, err := enc.Bytes()
+	for i, err := range []error{
+		enc.AddFrame(frame),
+		enc.AddFrameWithQuality(frame, 75),
+		enc.AddFrameWithOptions(frame, nil),
+		enc.AddRawFrame(RawFrame{}),
+		enc.AppendAnimation(nil),
+		enc.SetAnimationParams(AnimationParams{}),
+		enc.SetLoopCount(1),
+		enc.SetBackgroundColor(0),
+		enc.SetDefaultDispose(DisposeModeNone),
+		enc.SetDefaultBlend(BlendModeBlend),
+		enc.SetFeatureFlags(0),
+		enc.SetEXIF(nil),
+		enc.SetXMP(nil),
+		err,
+	} {
+		tAssert(t, errors.Is(err, ErrEncoderClosed), "call", i, "returned", err)
+	}
+}
+
+// tLogWriter sends each log message to a channel.
+type tLogWriter chan string
+
+func (w tLogWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestAnimationEncoder_finalizer(t *testing.T) {
+	logs := make(tLogWriter, 16)
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	func() {
+		enc := NewAnimationEncoder()
+		tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(8, 8), Duration: 100}))
+	}()
+	func() {
+		enc := NewAnimationEncoder()
+		enc.Close()
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case msg := <-logs:
+			tAssert(t, strings.Contains(msg, "garbage collected without Close"), "unexpected warning", msg)
+			select {
+			case msg := <-logs:
+				t.Fatal("closed encoder finalized:", msg)
+			case <-time.After(100 * time.Millisecond):
+			}
+			return
+		case <-deadline:
+			t.Fatal("leaked encoder not finalized")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestAnimationEncoder_keepAlive(t *testing.T) {
+	// Bytes is the last use of the encoders, which the finalizer must not
+	// release while libwebp assembles the animation. Their warnings are
+	// expected, and drained before the log is restored.
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	defer debug.SetGCPercent(debug.SetGCPercent(1))
+
+	newEncoder := func() *AnimationEncoder {
+		enc := NewAnimationEncoder()
+		tAssertNil(t, enc.SetAnimationParams(AnimationParams{}))
+		for i := 0; i < 4; i++ {
+			tAssertNil(t, enc.AddFrame(Frame{Image: tNoiseImage(16, 16), Duration: 100}))
+		}
+		return enc
+	}
+	for i := 0; i < 100; i++ {
+		data, err := newEncoder().Bytes()
+		tAssertNil(t, err)
+		tAssertEQ(t, 4, len(tRawFrames(t, data)))
+	}
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEncodeAnimationQuality(t *testing.T) {
+	frames := []Frame{
+		{Image: tNoiseImage(64, 64), Duration: 100},
+		{Image: tNoiseImage(64, 64), Duration: 100},
+	}
+	params := AnimationParams{}
+	def, err := EncodeAnimationToBytes(frames, params)
+	tAssertNil(t, err)
+
+	var low bytes.Buffer
+	tAssertNil(t, EncodeAnimationQuality(&low, frames, params, 20))
+	tAssert(t, low.Len() < len(def), "quality 20 not smaller than the default:", low.Len(), ">=", len(def))
+
+	var same bytes.Buffer
+	tAssertNil(t, EncodeAnimationQuality(&same, frames, params, DefaulQuality))
+	tAssertEQ(t, def, same.Bytes())
+
+	for _, quality := range []float32{-1, 101} {
+		var buf bytes.Buffer
+		err = EncodeAnimationQuality(&buf, frames, params, quality)
+		tAssert(t, err != nil, "quality", quality, "accepted")
+		tAssertEQ(t, 0, buf.Len())
+	}
+}
+
+func TestAnimationEncoder_AddEncodedFrame(t *testing.T) {
+	still := tNoiseImage(16, 16)
+	data, err := EncodeExactLosslessRGBA(still)
+	tAssertNil(t, err)
+
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	tAssertNil(t, enc.SetAnimationParams(AnimationParams{CanvasWidth: 32, CanvasHeight: 32}))
+	tAssertNil(t, enc.AddEncodedFrame(data, 0, 0, 100, DisposeModeNone, BlendModeBlend))
+	tAssertNil(t, enc.AddEncodedFrame(data, 16, 16, 200, DisposeModeBackground, BlendModeNoBlend))
+
+	tAssert(t, enc.AddEncodedFrame([]byte("garbage"), 0, 0, 100, 0, 0) != nil, "invalid data accepted")
+	tAssert(t, enc.AddEncodedFrame(data, 24, 24, 100, 0, 0) != nil, "frame off the canvas accepted")
+	anim, err := EncodeAnimationToBytes([]Frame{
+		{Image: still, Duration: 100},
+		{Image: still, Duration: 100},
+	}, AnimationParams{})
+	tAssertNil(t, err)
+	tAssert(t, enc.AddEncodedFrame(anim, 0, 0, 100, 0, 0) != nil, "animation accepted as a frame")
+
+	// The frames hold the data as is.
+	out, err := enc.Bytes()
+	tAssertNil(t, err)
+	dec, err := NewAnimationDecoder(bytes.NewReader(out))
+	tAssertNil(t, err)
+	defer dec.Close()
+	frames, err := dec.RawFrames()
+	tAssertNil(t, err)
+	tAssertEQ(t, 2, len(frames))
+	tAssertEQ(t, data, frames[0].Data)
+	tAssertEQ(t, 16, frames[1].X)
+	tAssertEQ(t, 200, frames[1].Duration)
+	tAssertEQ(t, DisposeModeBackground, frames[1].DisposeMode)
+	tAssertEQ(t, BlendModeNoBlend, frames[1].BlendMode)
+}
+
+func TestAnimationParams_OptimizeSubframes(t *testing.T) {
+	// A screen recording: a square moving over a static background.
+	background := tNoiseImage(64, 48)
+	var frames []Frame
+	for i := 0; i < 4; i++ {
+		m := image.NewRGBA(background.Rect)
+		copy(m.Pix, background.Pix)
+		draw.Draw(m, image.Rect(8*i+3, 5, 8*i+11, 13), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+		frames = append(frames, Frame{Image: m, Duration: 100, Lossless: true})
+	}
+	frames = append(frames, Frame{Image: frames[3].Image, Duration: 100, Lossless: true})
+
+	full, err := EncodeAnimationToBytes(frames, AnimationParams{})
+	tAssertNil(t, err)
+	params := AnimationParams{OptimizeSubframes: true}
+	data, err := EncodeAnimationToBytes(frames, params)
+	tAssertNil(t, err)
+	tAssert(t, len(data) < len(full)/2, "optimized animation of", len(data), "bytes, unoptimized", len(full))
+
+	var buf bytes.Buffer
+	tAssertNil(t, EncodeAnimationConcurrent(&buf, frames, params, 4))
+	tAssertEQ(t, data, buf.Bytes())
+
+	dec, err := NewAnimationDecoder(bytes.NewReader(data))
+	tAssertNil(t, err)
+	defer dec.Close()
+	raw, err := dec.RawFrames()
+	tAssertNil(t, err)
+	tAssertEQ(t, 64, raw[0].Width)
+	for i, frame := range raw[1:] {
+		tAssert(t, frame.X%2 == 0 && frame.Y%2 == 0, "odd offset of frame", i+1)
+		tAssert(t, frame.Width <= 20 && frame.Height <= 10, "frame", i+1, "not cropped:", frame.Width, "x", frame.Height)
+		tAssertEQ(t, BlendModeNoBlend, frame.BlendMode)
+	}
+
+	// The canvas displays the frames as given.
+	for i := range frames {
+		m, _, err := dec.Next()
+		tAssertNil(t, err)
+		tAssertEQ(t, frames[i].Image.(*image.RGBA).Pix, m.(*image.RGBA).Pix)
+	}
+
+	// Frames that do not replace the canvas are kept whole.
+	alpha := createImage(64, 48, color.RGBA{0, 0, 128, 128})
+	data, err = EncodeAnimationToBytes([]Frame{
+		{Image: alpha, Duration: 100},
+		{Image: alpha, Duration: 100},
+	}, params)
+	tAssertNil(t, err)
+	dec2, err := NewAnimationDecoder(bytes.NewReader(data))
+	tAssertNil(t, err)
+	defer dec2.Close()
+	raw, err = dec2.RawFrames()
+	tAssertNil(t, err)
+	tAssertEQ(t, 64, raw[1].Width)
+
+	// A frame disposed to the background clears the whole canvas, so the
+	// transparent frame after it shows no trace of the first frame.
+	red := createImage(64, 48, color.RGBA{255, 0, 0, 255})
+	changed := createImage(64, 48, color.RGBA{255, 0, 0, 255})
+	changed.SetRGBA(20, 20, color.RGBA{0, 255, 0, 255})
+	frames = []Frame{
+		{Image: red, Duration: 100, Lossless: true},
+		{Image: changed, Duration: 100, Lossless: true, DisposeMode: DisposeModeBackground},
+		{Image: createImage(64, 48, color.RGBA{}), Duration: 100, Lossless: true},
+	}
+	var want []image.Image
+	for _, params := range []AnimationParams{{}, {OptimizeSubframes: true}} {
+		data, err := EncodeAnimationToBytes(frames, params)
+		tAssertNil(t, err)
+		dec, err := NewAnimationDecoder(bytes.NewReader(data))
+		tAssertNil(t, err)
+		var got []image.Image
+		for range frames {
+			m, _, err := dec.Next()
+			tAssertNil(t, err)
+			got = append(got, m)
+		}
+		dec.Close()
+		if want == nil {
+			want = got
+			tAssertEQ(t, color.RGBA{}, got[2].At(10, 10))
+			continue
+		}
+		tAssertEQ(t, want, got)
+	}
+}
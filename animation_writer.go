@@ -0,0 +1,325 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// AnimationWriter writes an animated WebP image to a file frame by frame, for
+// animations too long to hold in memory, such as time-lapses of thousands of
+// frames.
+//
+// Unlike AnimationEncoder, which keeps every compressed frame in the mux until
+// Encode, each frame is encoded and written as soon as it is added, so the
+// peak memory is that of a single frame whatever the length of the animation.
+// The sizes and flags of the RIFF and VP8X headers are only known at the end,
+// so the destination must be seekable: Close seeks back to write them.
+//
+// Usage:
+//
+//	f, err := os.Create("timelapse.webp")
+//	if err != nil {
+//		return err
+//	}
+//	defer f.Close()
+//
+//	aw, err := webp.NewAnimationWriter(f, params)
+//	if err != nil {
+//		return err
+//	}
+//	for _, frame := range frames {
+//		if err := aw.AddFrame(frame); err != nil {
+//			return err
+//		}
+//	}
+//	return aw.Close()
+type AnimationWriter struct {
+	w     io.WriteSeeker
+	start int64 // offset of the RIFF header in w
+	size  int64 // bytes written after the RIFF header
+
+	// enc checks and places the frames, its mux is left empty.
+	enc *AnimationEncoder
+
+	hasAlpha bool
+	err      error
+}
+
+// NewAnimationWriter writes the headers of an animated WebP image with the
+// given parameters to w, at its current offset, and returns a writer for its
+// frames. The parameters are as for SetAnimationParams and cannot be changed
+// afterwards.
+//
+// The returned writer must be closed with Close, which completes the file.
+//
+// Returns an error if the parameters are invalid or if writing to w fails.
+func NewAnimationWriter(w io.WriteSeeker, params AnimationParams) (*AnimationWriter, error) {
+	enc := NewAnimationEncoder()
+	if err := enc.SetAnimationParams(params); err != nil {
+		enc.Close()
+		return nil, err
+	}
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		enc.Close()
+		return nil, err
+	}
+
+	// The RIFF size, the flags and the canvas size are written by Close.
+	header := []byte{
+		'R', 'I', 'F', 'F', 0, 0, 0, 0, 'W', 'E', 'B', 'P',
+		'V', 'P', '8', 'X', 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		'A', 'N', 'I', 'M', 6, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	binary.LittleEndian.PutUint32(header[38:], params.BackgroundColor)
+	binary.LittleEndian.PutUint16(header[42:], uint16(params.LoopCount))
+	if _, err := w.Write(header); err != nil {
+		enc.Close()
+		return nil, err
+	}
+	return &AnimationWriter{
+		w:     w,
+		start: start,
+		size:  int64(len(header)) - 8,
+		enc:   enc,
+	}, nil
+}
+
+// AddFrame encodes the frame and writes it, like AnimationEncoder.AddFrame.
+// The image may be reused as soon as AddFrame returns.
+//
+// Returns an error if the writer is closed, if the frame is invalid or does
+// not fit the canvas, or if writing to w fails.
+func (aw *AnimationWriter) AddFrame(frame Frame) error {
+	return aw.AddFrameWithOptions(frame, nil)
+}
+
+// AddFrameWithOptions encodes the frame with the given options and writes
+// it, like AnimationEncoder.AddFrameWithOptions.
+//
+// Returns an error if the writer is closed, if the options or the frame are
+// invalid, or if writing to w fails.
+func (aw *AnimationWriter) AddFrameWithOptions(frame Frame, opt *Options) error {
+	if aw.enc == nil {
+		return ErrEncoderClosed
+	}
+	if aw.err != nil {
+		return aw.err
+	}
+	frame, err := aw.enc.prepareFrame(frame)
+	if err != nil {
+		return err
+	}
+	data, err := encodeImage(frame.Image, frameOptions(frame, opt))
+	if err != nil {
+		return err
+	}
+	if frame, err = aw.enc.placeFrame(frame); err != nil {
+		return err
+	}
+	if err = aw.writeFrame(frame, data); err != nil {
+		aw.err = err
+		return err
+	}
+	aw.enc.frameAdded(frame)
+	return nil
+}
+
+// writeFrame writes the frame, encoded as the still image data, as an ANMF
+// chunk.
+func (aw *AnimationWriter) writeFrame(frame Frame, data []byte) error {
+	payload, err := frameChunks(data)
+	if err != nil {
+		return err
+	}
+	width, height, hasAlpha, _, err := parseHeader(data)
+	if err != nil {
+		return err
+	}
+	aw.hasAlpha = aw.hasAlpha || hasAlpha
+
+	// The offsets are stored halved, and the size minus one, on 24 bits.
+	header := make([]byte, 8+16)
+	copy(header, "ANMF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(16+len(payload)))
+	putLE24(header[8:], frame.X/2)
+	putLE24(header[11:], frame.Y/2)
+	putLE24(header[14:], width-1)
+	putLE24(header[17:], height-1)
+	putLE24(header[20:], frame.Duration)
+	header[23] = byte(frame.BlendMode<<1 | frame.DisposeMode)
+
+	if _, err := aw.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := aw.w.Write(payload); err != nil {
+		return err
+	}
+	aw.size += int64(len(header) + len(payload))
+	return nil
+}
+
+// Close completes the file by writing the sizes and flags of its headers,
+// and leaves w at the end of the file. It does not close w.
+//
+// Returns an error if no frame was added, if a previous write failed, if the
+// file exceeds the 4GB limit of the RIFF container or if writing to w fails.
+// Calling Close again returns nil.
+func (aw *AnimationWriter) Close() error {
+	if aw.enc == nil {
+		return nil
+	}
+	enc := aw.enc
+	defer enc.Close()
+	aw.enc = nil
+
+	if aw.err != nil {
+		return aw.err
+	}
+	if enc.frameCount == 0 {
+		return errors.New("webp: animation writer closed without frames")
+	}
+	if aw.size > 1<<32-2 {
+		return fmt.Errorf("webp: animation of %d bytes exceeds the RIFF size limit", aw.size)
+	}
+
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(aw.size))
+	vp8x := make([]byte, 10)
+	vp8x[0] = FlagAnimation
+	if aw.hasAlpha {
+		vp8x[0] |= FlagAlpha
+	}
+	putLE24(vp8x[4:], enc.canvasWidth-1)
+	putLE24(vp8x[7:], enc.canvasHeight-1)
+
+	if _, err := aw.w.Seek(aw.start+4, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := aw.w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := aw.w.Seek(aw.start+20, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := aw.w.Write(vp8x); err != nil {
+		return err
+	}
+	_, err := aw.w.Seek(aw.start+8+aw.size, io.SeekStart)
+	return err
+}
+
+// frameChunks returns the chunks of the still image data that make up a
+// frame, its optional ALPH chunk followed by its VP8 or VP8L chunk, the
+// inverse of rawFrameData.
+func frameChunks(data []byte) ([]byte, error) {
+	if !IsWebP(data) {
+		return nil, errInvalidHeader
+	}
+	var chunks []byte
+	for rest := data[12:]; len(rest) > 0; {
+		if len(rest) < 8 {
+			return nil, errInvalidHeader
+		}
+		n := 8 + int(binary.LittleEndian.Uint32(rest[4:]))
+		n += n & 1
+		if n > len(rest) {
+			return nil, errInvalidHeader
+		}
+		switch string(rest[:4]) {
+		case "ALPH", "VP8 ", "VP8L":
+			chunks = append(chunks, rest[:n]...)
+		}
+		rest = rest[n:]
+	}
+	return chunks, nil
+}
+
+// putLE24 stores v in the 3 bytes of b in little-endian order.
+func putLE24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+// StreamingAnimation encodes an animated WebP image from frames pushed as a
+// live source produces them, such as a screen or camera capture, and writes
+// it to an io.Writer once the capture ends.
+//
+// Each frame is encoded as soon as it is pushed, so the producer's image may
+// be reused right away, but the compressed frames are kept in memory until
+// Finish: a WebP animation is assembled as a whole, its headers holding the
+// size of the file. The memory therefore grows with the length of the
+// capture, by the compressed size of each frame. When the destination is a
+// file or another io.WriteSeeker, AnimationWriter writes the frames as they
+// come in constant memory instead.
+//
+// Usage, with frames received from a channel:
+//
+//	s, err := webp.NewStreamingAnimation(w, params)
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//
+//	for frame := range frames {
+//		if err := s.Push(frame); err != nil {
+//			return err
+//		}
+//	}
+//	return s.Finish()
+type StreamingAnimation struct {
+	w   io.Writer
+	enc *AnimationEncoder
+}
+
+// NewStreamingAnimation returns a StreamingAnimation writing to w, with the
+// animation parameters as for SetAnimationParams.
+//
+// Returns an error if the parameters are invalid.
+func NewStreamingAnimation(w io.Writer, params AnimationParams) (*StreamingAnimation, error) {
+	enc := NewAnimationEncoder()
+	if err := enc.SetAnimationParams(params); err != nil {
+		enc.Close()
+		return nil, err
+	}
+	return &StreamingAnimation{w: w, enc: enc}, nil
+}
+
+// Push encodes the frame and adds it to the animation, like
+// AnimationEncoder.AddFrame.
+//
+// Returns ErrEncoderClosed after Finish or Close, or an error if the frame
+// is invalid.
+func (s *StreamingAnimation) Push(frame Frame) error {
+	return s.enc.AddFrame(frame)
+}
+
+// Finish assembles the animation, writes it to w and releases the frames.
+// The animation cannot be pushed to afterwards.
+//
+// Returns ErrEncoderClosed if called again, or an error if no frame was
+// pushed, if the animation cannot be assembled or if writing to w fails.
+func (s *StreamingAnimation) Finish() error {
+	if s.enc.mux == nil {
+		return ErrEncoderClosed
+	}
+	defer s.enc.Close()
+	if s.enc.frameCount == 0 {
+		return errors.New("webp: streaming animation finished without frames")
+	}
+	return s.enc.Encode(s.w)
+}
+
+// Close releases the frames without writing the animation, to abandon it.
+// After Finish, or called again, Close does nothing.
+func (s *StreamingAnimation) Close() {
+	s.enc.Close()
+}
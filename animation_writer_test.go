@@ -0,0 +1,105 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestAnimationWriter(t *testing.T) {
+	alpha := createImage(8, 6, color.RGBA{0, 0, 128, 128})
+	frames := []Frame{
+		{Image: tNoiseImage(32, 24), Duration: 100},
+		{Image: alpha, X: 4, Y: 2, Duration: 250, DisposeMode: DisposeModeBackground},
+		{Image: tNoiseImage(16, 8), X: 10, Y: 12, Duration: 50, BlendMode: BlendModeNoBlend, Lossless: true},
+	}
+	params := AnimationParams{LoopCount: 3, BackgroundColor: 0xff102030}
+	want, err := EncodeAnimationToBytes(frames, params)
+	tAssertNil(t, err)
+
+	f, err := os.CreateTemp(t.TempDir(), "*.webp")
+	tAssertNil(t, err)
+	defer f.Close()
+	_, err = f.Write([]byte("prefix"))
+	tAssertNil(t, err)
+
+	aw, err := NewAnimationWriter(f, params)
+	tAssertNil(t, err)
+	for _, frame := range frames {
+		tAssertNil(t, aw.AddFrame(frame))
+	}
+	tAssertNil(t, aw.Close())
+	tAssertNil(t, aw.Close())
+	tAssert(t, aw.AddFrame(frames[0]) != nil, "closed writer accepted a frame")
+
+	// The file holds the same animation as the mux assembles, after what
+	// was written before it.
+	_, err = f.Seek(0, io.SeekStart)
+	tAssertNil(t, err)
+	got, err := io.ReadAll(f)
+	tAssertNil(t, err)
+	tAssertEQ(t, "prefix", string(got[:6]))
+	tAssert(t, bytes.Equal(want, got[6:]), "output differs from AnimationEncoder: ", len(want), " != ", len(got)-6)
+	tAssertNil(t, Validate(got[6:]))
+}
+
+func TestAnimationWriter_invalid(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "*.webp")
+	tAssertNil(t, err)
+	defer f.Close()
+
+	_, err = NewAnimationWriter(f, AnimationParams{LoopCount: -1})
+	tAssert(t, err != nil, "invalid parameters accepted")
+
+	aw, err := NewAnimationWriter(f, AnimationParams{CanvasWidth: 16, CanvasHeight: 16})
+	tAssertNil(t, err)
+	tAssert(t, aw.AddFrame(Frame{Image: image.NewRGBA(image.Rect(0, 0, 32, 32))}) != nil, "frame outside the canvas accepted")
+	tAssert(t, aw.Close() != nil, "animation without frames accepted")
+}
+
+func TestStreamingAnimation(t *testing.T) {
+	frames := []Frame{
+		{Image: tNoiseImage(32, 24), Duration: 100},
+		{Image: tNoiseImage(8, 6), X: 4, Y: 2, Duration: 250},
+		{Image: tNoiseImage(16, 8), X: 10, Y: 12, Duration: 50, BlendMode: BlendModeNoBlend},
+	}
+	params := AnimationParams{LoopCount: 2}
+	want, err := EncodeAnimationToBytes(frames, params)
+	tAssertNil(t, err)
+
+	ch := make(chan Frame)
+	go func() {
+		for _, frame := range frames {
+			ch <- frame
+		}
+		close(ch)
+	}()
+
+	var buf bytes.Buffer
+	s, err := NewStreamingAnimation(&buf, params)
+	tAssertNil(t, err)
+	defer s.Close()
+	for frame := range ch {
+		tAssertNil(t, s.Push(frame))
+	}
+	tAssertEQ(t, 0, buf.Len())
+	tAssertNil(t, s.Finish())
+	tAssert(t, bytes.Equal(want, buf.Bytes()), "output differs from EncodeAnimationToBytes")
+
+	tAssert(t, errors.Is(s.Push(frames[0]), ErrEncoderClosed), "finished animation accepted a frame")
+	tAssert(t, errors.Is(s.Finish(), ErrEncoderClosed), "animation finished twice")
+
+	s, err = NewStreamingAnimation(&buf, params)
+	tAssertNil(t, err)
+	tAssert(t, s.Finish() != nil, "animation finished without frames")
+	_, err = NewStreamingAnimation(&buf, AnimationParams{LoopCount: -1})
+	tAssert(t, err != nil, "invalid parameters accepted")
+}
@@ -138,3 +138,26 @@ func BenchmarkEncodeAndDecode(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkEncoder_Encode(b *testing.B) {
+	img, err := loadImage("1_webp_ll.png")
+	if err != nil {
+		b.Fatal(err)
+	}
+	rgba := toRGBAImage(img)
+	s := img.Bounds().Size()
+	b.SetBytes(int64(s.X * s.Y * 4))
+
+	enc, err := NewEncoder(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer enc.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = enc.Encode(rgba); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -24,12 +24,16 @@ package webp
 #include "webp.h"
 
 #include <webp/decode.h>
+#include <webp/encode.h>
 
 #include <stdlib.h>
 */
 import "C"
 import (
 	"errors"
+	"fmt"
+	"image"
+	"io"
 	"unsafe"
 )
 
@@ -52,6 +56,28 @@ func webpGetInfo(data []byte) (width, height int, hasAlpha bool, err error) {
 	return
 }
 
+// webpGetFeatures returns the features of the bitstream reported by libwebp
+// from the headers of data.
+func webpGetFeatures(data []byte) (f Features, err error) {
+	if len(data) == 0 {
+		err = errors.New("webpGetFeatures: bad arguments, data is empty")
+		return
+	}
+
+	var features C.WebPBitstreamFeatures
+	if C.WebPGetFeatures((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &features) != C.VP8_STATUS_OK {
+		err = errors.New("webpGetFeatures: failed")
+		return
+	}
+	return Features{
+		Width:        int(features.width),
+		Height:       int(features.height),
+		HasAlpha:     features.has_alpha != 0,
+		HasAnimation: features.has_animation != 0,
+		Format:       int(features.format),
+	}, nil
+}
+
 func webpDecodeGray(data []byte) (pix []byte, width, height int, err error) {
 	if len(data) == 0 {
 		err = errors.New("webpDecodeGray: bad arguments")
@@ -106,8 +132,54 @@ func webpDecodeRGBA(data []byte) (pix []byte, width, height int, err error) {
 	}
 	defer C.free(unsafe.Pointer(cptr))
 
-	pix = make([]byte, int(cw*ch*4))
-	copy(pix, ((*[1 << 30]byte)(unsafe.Pointer(cptr)))[0:len(pix):len(pix)])
+	// The size may exceed 1GB, so the pixels are not copied through an array type.
+	pix = make([]byte, int(cw)*int(ch)*4)
+	copy(pix, unsafe.Slice((*byte)(unsafe.Pointer(cptr)), len(pix)))
+	width, height = int(cw), int(ch)
+	return
+}
+
+// webpDecodeAlpha decodes the image as RGBA in C memory and returns only its
+// alpha samples, so the color samples are never copied to Go memory.
+func webpDecodeAlpha(data []byte) (pix []byte, width, height int, err error) {
+	if len(data) == 0 {
+		err = errors.New("webpDecodeAlpha: bad arguments")
+		return
+	}
+
+	var cw, ch C.int
+	var cptr = C.webpDecodeRGBA((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &cw, &ch)
+	if cptr == nil {
+		err = errors.New("webpDecodeAlpha: failed")
+		return
+	}
+	defer C.free(unsafe.Pointer(cptr))
+
+	rgba := unsafe.Slice((*byte)(unsafe.Pointer(cptr)), int(cw)*int(ch)*4)
+	pix = make([]byte, int(cw)*int(ch))
+	for i := range pix {
+		pix[i] = rgba[4*i+3]
+	}
+	width, height = int(cw), int(ch)
+	return
+}
+
+func webpDecodeBGRA(data []byte) (pix []byte, width, height int, err error) {
+	if len(data) == 0 {
+		err = errors.New("webpDecodeBGRA: bad arguments")
+		return
+	}
+
+	var cw, ch C.int
+	var cptr = C.webpDecodeBGRA((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &cw, &ch)
+	if cptr == nil {
+		err = errors.New("webpDecodeBGRA: failed")
+		return
+	}
+	defer C.free(unsafe.Pointer(cptr))
+
+	pix = make([]byte, int(cw)*int(ch)*4)
+	copy(pix, unsafe.Slice((*byte)(unsafe.Pointer(cptr)), len(pix)))
 	width, height = int(cw), int(ch)
 	return
 }
@@ -145,6 +217,120 @@ func webpDecodeRGBAToSize(data []byte, width, height int) (pix []byte, err error
 	return
 }
 
+// webpDecodeRGBACropped decodes the rect region of the image.
+func webpDecodeRGBACropped(data []byte, rect image.Rectangle) (pix []byte, err error) {
+	if len(data) == 0 || rect.Empty() {
+		err = errors.New("webpDecodeRGBACropped: bad arguments")
+		return
+	}
+	width, height := rect.Dx(), rect.Dy()
+	pix = make([]byte, 4*width*height)
+	res := C.webpDecodeRGBACropped(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)),
+		C.int(rect.Min.X), C.int(rect.Min.Y), C.int(width), C.int(height),
+		C.int(4*width), (*C.uint8_t)(unsafe.Pointer(&pix[0])),
+	)
+	if res != C.VP8_STATUS_OK {
+		pix = nil
+		err = errors.New("webpDecodeRGBACropped: failed")
+	}
+	return
+}
+
+// webpDecodeRGBAInto decodes the image into pix, which must be large enough
+// for the image at the given stride.
+func webpDecodeRGBAInto(data, pix []byte, stride int) (err error) {
+	if len(data) == 0 || len(pix) == 0 || stride <= 0 {
+		err = errors.New("webpDecodeRGBAInto: bad arguments")
+		return
+	}
+	res := C.webpDecodeRGBAInto(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)),
+		(*C.uint8_t)(unsafe.Pointer(&pix[0])), C.size_t(len(pix)), C.int(stride),
+	)
+	if res == 0 {
+		err = errors.New("webpDecodeRGBAInto: failed")
+	}
+	return
+}
+
+// webpDecodeRGBAWithOptions decodes the image to RGBA with the color and alpha
+// dithering of opt, through the advanced decoding API.
+func webpDecodeRGBAWithOptions(data []byte, opt *DecoderOptions) (pix []byte, width, height int, err error) {
+	if width, height, _, err = webpGetInfo(data); err != nil {
+		return
+	}
+	pix = make([]byte, 4*width*height)
+	res := C.webpDecodeRGBAWithOptions(
+		(*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)),
+		C.int(opt.DitheringStrength), C.int(opt.AlphaDitheringStrength),
+		(*C.uint8_t)(unsafe.Pointer(&pix[0])), C.size_t(len(pix)), C.int(4*width),
+	)
+	if res != C.VP8_STATUS_OK {
+		return nil, 0, 0, errors.New("webpDecodeRGBAWithOptions: failed")
+	}
+	return
+}
+
+// WebPIDecoder is a Go wrapper for C.WebPIDecoder
+type WebPIDecoder struct {
+	idec *C.WebPIDecoder
+}
+
+// webpIDecoderNew creates an incremental decoder to RGBA. It must be deleted
+// with webpIDecoderDelete.
+func webpIDecoderNew() (*WebPIDecoder, error) {
+	idec := C.webpIDecoderNew()
+	if idec == nil {
+		return nil, errors.New("webpIDecoderNew: failed")
+	}
+	return &WebPIDecoder{idec: idec}, nil
+}
+
+// webpIDecoderAppend feeds data to the decoder, which keeps a copy of what it
+// still needs. Returns true once the image is complete.
+func webpIDecoderAppend(dec *WebPIDecoder, data []byte) (done bool, err error) {
+	if len(data) == 0 {
+		return false, nil
+	}
+	switch C.webpIDecoderAppend(dec.idec, (*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data))) {
+	case C.VP8_STATUS_OK:
+		return true, nil
+	case C.VP8_STATUS_SUSPENDED:
+		return false, nil
+	default:
+		return false, errors.New("webpIDecoderAppend: failed")
+	}
+}
+
+// webpIDecoderGetRGBA returns a copy of the rows decoded so far, rows is the
+// number of them, which is 0 until the headers have been decoded.
+func webpIDecoderGetRGBA(dec *WebPIDecoder) (pix []byte, rows, width, height int) {
+	var lastY, cw, ch, stride C.int
+	cptr := C.webpIDecoderGetRGBA(dec.idec, &lastY, &cw, &ch, &stride)
+	if cptr == nil {
+		return nil, 0, 0, 0
+	}
+	rows, width, height = int(lastY), int(cw), int(ch)
+
+	pix = make([]byte, 4*width*height)
+	if rows > 0 {
+		src := unsafe.Slice((*byte)(unsafe.Pointer(cptr)), int(stride)*(rows-1)+4*width)
+		for y := 0; y < rows; y++ {
+			copy(pix[4*width*y:4*width*(y+1)], src[int(stride)*y:])
+		}
+	}
+	return pix, rows, width, height
+}
+
+// webpIDecoderDelete deletes the decoder and its output.
+func webpIDecoderDelete(dec *WebPIDecoder) {
+	if dec.idec != nil {
+		C.webpIDecoderDelete(dec.idec)
+		dec.idec = nil
+	}
+}
+
 func webpEncodeGray(pix []byte, width, height, stride int, quality float32) (output []byte, err error) {
 	if len(pix) == 0 || width <= 0 || height <= 0 || stride <= 0 || quality < 0.0 {
 		err = errors.New("webpEncodeGray: bad arguments")
@@ -307,6 +493,402 @@ func webpEncodeLosslessRGBA(exact int, pix []byte, width, height, stride int) (o
 	return
 }
 
+// webpConfigCreate creates a WebPConfig for the given encoding options.
+func webpConfigCreate(opt *Options) (config C.WebPConfig, err error) {
+	if opt.Quality < 0 || opt.Quality > 100 {
+		err = fmt.Errorf("webp: invalid quality %v, must be in range 0 ~ 100", opt.Quality)
+		return
+	}
+	if opt.Method < MethodFastest || opt.Method > 6 {
+		err = fmt.Errorf("webp: invalid method %d, must be in range 0 ~ 6", opt.Method)
+		return
+	}
+	if opt.SNSStrength > 100 {
+		err = fmt.Errorf("webp: invalid SNS strength %d, must be in range 0 ~ 100", opt.SNSStrength)
+		return
+	}
+	if opt.FilterStrength > 100 {
+		err = fmt.Errorf("webp: invalid filter strength %d, must be in range 0 ~ 100", opt.FilterStrength)
+		return
+	}
+	if opt.FilterSharpness < 0 || opt.FilterSharpness > 7 {
+		err = fmt.Errorf("webp: invalid filter sharpness %d, must be in range 0 ~ 7", opt.FilterSharpness)
+		return
+	}
+	if opt.AlphaQuality < 0 || opt.AlphaQuality > 100 {
+		err = fmt.Errorf("webp: invalid alpha quality %d, must be in range 0 ~ 100", opt.AlphaQuality)
+		return
+	}
+	if opt.AlphaFiltering < AlphaFilterNone || opt.AlphaFiltering > AlphaFilterBest {
+		err = fmt.Errorf("webp: invalid alpha filtering %d", opt.AlphaFiltering)
+		return
+	}
+	if opt.NearLossless < 0 || opt.NearLossless > 100 {
+		err = fmt.Errorf("webp: invalid near lossless %d, must be in range 0 ~ 100", opt.NearLossless)
+		return
+	}
+	if opt.LosslessLevel < LosslessLevelFastest || opt.LosslessLevel > 9 {
+		err = fmt.Errorf("webp: invalid lossless level %d, must be in range 0 ~ 9", opt.LosslessLevel)
+		return
+	}
+	if opt.TargetSize < 0 {
+		err = fmt.Errorf("webp: invalid target size %d", opt.TargetSize)
+		return
+	}
+	if opt.TargetPSNR < 0 {
+		err = fmt.Errorf("webp: invalid target PSNR %v", opt.TargetPSNR)
+		return
+	}
+	if opt.Segments < 0 || opt.Segments > 4 {
+		err = fmt.Errorf("webp: invalid segments %d, must be in range 0 ~ 4", opt.Segments)
+		return
+	}
+	if opt.Preprocessing < PreprocessNone || opt.Preprocessing > PreprocessSegmentSmooth|PreprocessDithering {
+		err = fmt.Errorf("webp: invalid preprocessing %d", opt.Preprocessing)
+		return
+	}
+	if opt.ImageHint < ImageHintDefault || opt.ImageHint > ImageHintGraph {
+		err = fmt.Errorf("webp: invalid image hint %d", opt.ImageHint)
+		return
+	}
+	if opt.Preset < PresetDefault || opt.Preset > PresetText {
+		err = fmt.Errorf("webp: invalid preset %d", opt.Preset)
+		return
+	}
+	if opt.MaxOutputBytes < 0 {
+		err = fmt.Errorf("webp: invalid max output bytes %d", opt.MaxOutputBytes)
+		return
+	}
+	if opt.ColorSpace < ColorSpaceDefault || opt.ColorSpace > ColorSpaceBT709 {
+		err = fmt.Errorf("webp: invalid color space %d", opt.ColorSpace)
+		return
+	}
+	if opt.ColorSpace != ColorSpaceDefault && len(opt.ICCProfile) > 0 {
+		err = errors.New("webp: invalid color space, an ICC profile is already set")
+		return
+	}
+
+	lossless := opt.Lossless || opt.NearLossless > 0
+	quality := opt.Quality
+	if lossless {
+		quality = 100 // the quality is the compression effort in lossless mode
+	}
+	if C.WebPConfigPreset(&config, C.WebPPreset(opt.Preset), C.float(quality)) == 0 {
+		err = errors.New("webpConfigCreate: failed")
+		return
+	}
+	config.image_hint = C.WebPImageHint(opt.ImageHint)
+
+	if lossless {
+		config.lossless = 1
+	}
+	if opt.NearLossless > 0 {
+		config.near_lossless = C.int(opt.NearLossless)
+	}
+	if opt.SharpYUV {
+		config.use_sharp_yuv = 1
+	}
+	if opt.Exact {
+		config.exact = 1
+	}
+	switch {
+	case opt.Method == MethodFastest:
+		config.method = 0
+	case opt.Method > 0:
+		config.method = C.int(opt.Method)
+	}
+	switch {
+	case opt.SNSStrength < 0:
+		config.sns_strength = 0
+	case opt.SNSStrength > 0:
+		config.sns_strength = C.int(opt.SNSStrength)
+	}
+	switch {
+	case opt.FilterStrength < 0:
+		config.filter_strength = 0
+	case opt.FilterStrength > 0:
+		config.filter_strength = C.int(opt.FilterStrength)
+	}
+	if opt.FilterSharpness > 0 {
+		config.filter_sharpness = C.int(opt.FilterSharpness)
+	}
+	if opt.AlphaQuality > 0 {
+		config.alpha_quality = C.int(opt.AlphaQuality)
+	}
+	if opt.AlphaUncompressed {
+		config.alpha_compression = 0
+	}
+	switch opt.AlphaFiltering {
+	case AlphaFilterNone:
+		config.alpha_filtering = 0
+	case AlphaFilterFast, AlphaFilterBest:
+		config.alpha_filtering = C.int(opt.AlphaFiltering)
+	}
+	if opt.Segments > 0 {
+		config.segments = C.int(opt.Segments)
+	}
+	switch {
+	case opt.Preprocessing == PreprocessNone:
+		config.preprocessing &^= PreprocessSegmentSmooth | PreprocessDithering
+	case opt.Preprocessing > 0:
+		config.preprocessing = config.preprocessing&^(PreprocessSegmentSmooth|PreprocessDithering) | C.int(opt.Preprocessing)
+	}
+	if opt.MultiThread {
+		config.thread_level = 1
+	}
+	if opt.TargetSize > 0 || opt.TargetPSNR > 0 {
+		config.target_size = C.int(opt.TargetSize)
+		config.target_PSNR = C.float(opt.TargetPSNR)
+		config.pass = 6 // a single pass does not search, same as cwebp
+	}
+	if lossless && opt.LosslessLevel != 0 {
+		level := opt.LosslessLevel
+		if level == LosslessLevelFastest {
+			level = 0
+		}
+		if C.WebPConfigLosslessPreset(&config, C.int(level)) == 0 {
+			err = errors.New("webpConfigCreate: failed")
+			return
+		}
+	}
+
+	if C.WebPValidateConfig(&config) == 0 {
+		err = &EncodeError{Code: int(C.VP8_ENC_ERROR_INVALID_CONFIGURATION)}
+		return
+	}
+	return
+}
+
+// webpBuffer holds encoder output, either in C memory allocated by libwebp,
+// which must be released with free, or in Go memory.
+type webpBuffer struct {
+	data []byte
+	cptr unsafe.Pointer
+}
+
+// free releases the C memory of the buffer. The data must not be used afterwards.
+func (b *webpBuffer) free() {
+	if b.cptr != nil {
+		C.free(b.cptr)
+		b.cptr = nil
+	}
+	b.data = nil
+}
+
+func newWebPBuffer(cptr *C.uint8_t, size C.size_t) *webpBuffer {
+	return &webpBuffer{
+		data: unsafe.Slice((*byte)(unsafe.Pointer(cptr)), int(size)),
+		cptr: unsafe.Pointer(cptr),
+	}
+}
+
+func webpEncodeWithConfig(config *C.WebPConfig, channels int, pix []byte, width, height, stride int) (output []byte, err error) {
+	buf, err := webpEncodeWithConfigBuffer(config, channels, pix, width, height, stride, 0)
+	if err != nil {
+		return
+	}
+	defer buf.free()
+
+	output = make([]byte, len(buf.data))
+	copy(output, buf.data)
+	return
+}
+
+// channelsBGRA selects 4 channels in BGRA order in webpEncodeWithConfig.
+const channelsBGRA = -4
+
+// webpEncodeWithConfigBuffer encodes the pixels into a buffer in C memory,
+// which the caller must free. The channels are 1, 3, 4 or channelsBGRA.
+// The encoding stops with ErrOutputTooLarge once the output exceeds maxSize
+// bytes, unless maxSize is 0.
+func webpEncodeWithConfigBuffer(config *C.WebPConfig, channels int, pix []byte, width, height, stride, maxSize int) (buf *webpBuffer, err error) {
+	if len(pix) == 0 || width <= 0 || height <= 0 || stride <= 0 {
+		err = errors.New("webpEncodeWithConfig: bad arguments")
+		return
+	}
+	n := channels
+	if n < 0 {
+		n = -n
+	}
+	if stride < width*n || len(pix) < (height-1)*stride+width*n {
+		err = errors.New("webpEncodeWithConfig: bad arguments")
+		return
+	}
+
+	var cptr_size C.size_t
+	var code C.WebPEncodingError
+	var cptr = C.webpEncodeWithConfig(
+		config, C.int(channels),
+		(*C.uint8_t)(unsafe.Pointer(&pix[0])), C.int(width), C.int(height),
+		C.int(stride),
+		C.size_t(maxSize), &cptr_size, &code,
+	)
+	if cptr == nil || cptr_size == 0 {
+		err = encodeError(code, maxSize)
+		return
+	}
+	return newWebPBuffer(cptr, cptr_size), nil
+}
+
+// webpEncodeYCbCrWithConfigBuffer encodes a 4:2:0 YCbCr image into a buffer
+// in C memory, which the caller must free. maxSize is as for
+// webpEncodeWithConfigBuffer.
+func webpEncodeYCbCrWithConfigBuffer(config *C.WebPConfig, m *image.YCbCr, maxSize int) (buf *webpBuffer, err error) {
+	if m.SubsampleRatio != image.YCbCrSubsampleRatio420 || m.Rect.Empty() {
+		err = errors.New("webpEncodeYCbCrWithConfig: bad arguments")
+		return
+	}
+	var (
+		width, height = m.Rect.Dx(), m.Rect.Dy()
+		yi            = m.YOffset(m.Rect.Min.X, m.Rect.Min.Y)
+		ci            = m.COffset(m.Rect.Min.X, m.Rect.Min.Y)
+	)
+	if len(m.Y) < yi+(height-1)*m.YStride+width || len(m.Cb) < ci+(height-1)/2*m.CStride+(width+1)/2 || len(m.Cr) != len(m.Cb) {
+		err = errors.New("webpEncodeYCbCrWithConfig: bad arguments")
+		return
+	}
+
+	var cptr_size C.size_t
+	var code C.WebPEncodingError
+	var cptr = C.webpEncodeYUV420WithConfig(
+		config,
+		(*C.uint8_t)(unsafe.Pointer(&m.Y[yi])), C.int(m.YStride),
+		(*C.uint8_t)(unsafe.Pointer(&m.Cb[ci])), (*C.uint8_t)(unsafe.Pointer(&m.Cr[ci])), C.int(m.CStride),
+		C.int(width), C.int(height),
+		C.size_t(maxSize), &cptr_size, &code,
+	)
+	if cptr == nil || cptr_size == 0 {
+		err = encodeError(code, maxSize)
+		return
+	}
+	return newWebPBuffer(cptr, cptr_size), nil
+}
+
+// encodeError returns the error for the code of a failed encoding, which
+// stopped at the writer if the output exceeded a maxSize set.
+func encodeError(code C.WebPEncodingError, maxSize int) error {
+	if code == C.VP8_ENC_ERROR_BAD_WRITE && maxSize > 0 {
+		return ErrOutputTooLarge
+	}
+	return &EncodeError{Code: int(code)}
+}
+
+// WebPEncoder is a Go wrapper for C.webpEncoder and the config it encodes with.
+type WebPEncoder struct {
+	enc    *C.webpEncoder
+	config C.WebPConfig
+}
+
+// webpEncoderNew creates a new WebPEncoder encoding with the given options.
+func webpEncoderNew(opt *Options) (*WebPEncoder, error) {
+	config, err := webpConfigCreate(opt)
+	if err != nil {
+		return nil, err
+	}
+	enc := C.webpEncoderNew()
+	if enc == nil {
+		return nil, errors.New("webpEncoderNew: failed")
+	}
+	return &WebPEncoder{enc: enc, config: config}, nil
+}
+
+// webpEncoderEncodeRGBA encodes the RGBA pixels into dst, and returns the number
+// of bytes written. It returns io.ErrShortBuffer if the output does not fit dst.
+func webpEncoderEncodeRGBA(enc *WebPEncoder, pix []byte, width, height, stride int, dst []byte) (n int, err error) {
+	if len(pix) == 0 || width <= 0 || height <= 0 || stride < width*4 || len(pix) < (height-1)*stride+width*4 {
+		err = errors.New("webpEncoderEncodeRGBA: bad arguments")
+		return
+	}
+	if len(dst) == 0 {
+		err = io.ErrShortBuffer
+		return
+	}
+
+	var size C.size_t
+	switch code := C.webpEncoderEncodeRGBA(
+		enc.enc, &enc.config,
+		(*C.uint8_t)(unsafe.Pointer(&pix[0])), C.int(width), C.int(height), C.int(stride),
+		(*C.uint8_t)(unsafe.Pointer(&dst[0])), C.size_t(len(dst)),
+		&size,
+	); code {
+	case C.VP8_ENC_OK:
+		n = int(size)
+	case C.VP8_ENC_ERROR_BAD_WRITE:
+		err = io.ErrShortBuffer
+	default:
+		err = &EncodeError{Code: int(code)}
+	}
+	return
+}
+
+// webpEncoderDelete deletes a WebPEncoder.
+func webpEncoderDelete(enc *WebPEncoder) {
+	if enc != nil && enc.enc != nil {
+		C.webpEncoderDelete(enc.enc)
+		enc.enc = nil
+	}
+}
+
+// webpPictureDistortion computes the distortion of the RGBA pixels of ref
+// against those of src with the given metric, in dB over all the channels.
+func webpPictureDistortion(src []byte, srcStride int, ref []byte, refStride int, width, height, metric int) (distortion float64, err error) {
+	if width <= 0 || height <= 0 ||
+		srcStride < 4*width || len(src) < (height-1)*srcStride+4*width ||
+		refStride < 4*width || len(ref) < (height-1)*refStride+4*width {
+		err = errors.New("webpPictureDistortion: bad arguments")
+		return
+	}
+
+	var result [5]C.float
+	if C.webpPictureDistortion(
+		(*C.uint8_t)(unsafe.Pointer(&src[0])), C.int(srcStride),
+		(*C.uint8_t)(unsafe.Pointer(&ref[0])), C.int(refStride),
+		C.int(width), C.int(height), C.int(metric),
+		&result[0],
+	) == 0 {
+		err = errors.New("webpPictureDistortion: failed")
+		return
+	}
+	return float64(result[4]), nil
+}
+
+// webpInspect parses the chunks of data without decoding the image.
+func webpInspect(data []byte) (info FileInfo, err error) {
+	if len(data) == 0 {
+		err = errors.New("webpInspect: bad arguments")
+		return
+	}
+
+	var cinfo C.webpFileInfo
+	if C.webpInspect((*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data)), &cinfo) == 0 {
+		err = errors.New("webpInspect: failed")
+		return
+	}
+
+	info = FileInfo{
+		Width:           int(cinfo.width),
+		Height:          int(cinfo.height),
+		Flags:           uint32(cinfo.flags),
+		FrameCount:      int(cinfo.frame_count),
+		LoopCount:       int(cinfo.loop_count),
+		BackgroundColor: uint32(cinfo.bgcolor),
+		Chunks:          make(map[string]int),
+	}
+	for fourcc, size := range map[string]C.int64_t{
+		"ICCP": cinfo.iccp_size,
+		"EXIF": cinfo.exif_size,
+		"XMP ": cinfo.xmp_size,
+		"ALPH": cinfo.alph_size,
+		"VP8 ": cinfo.vp8_size,
+		"VP8L": cinfo.vp8l_size,
+	} {
+		if size >= 0 {
+			info.Chunks[fourcc] = int(size)
+		}
+	}
+	return
+}
+
 func webpGetEXIF(data []byte) (metadata []byte, err error) {
 	if len(data) == 0 {
 		err = errors.New("webpGetEXIF: bad arguments")
@@ -586,9 +1168,39 @@ func webpAnimSetAnimationParams(mux *WebPMux, params *WebPMuxAnimParams) int {
 	return int(C.webpAnimSetAnimationParams(mux.mux, &params.params))
 }
 
-// webpAnimAssemble assembles an animation from a WebPMux.
-func webpAnimAssemble(mux *WebPMux, webpData *WebPData) int {
-	return int(C.webpAnimAssemble(mux.mux, &webpData.data))
+// webpAnimSetCanvasSize sets the canvas size of a WebPMux.
+// A zero size lets the canvas be derived from the frames.
+func webpAnimSetCanvasSize(mux *WebPMux, width, height int) int {
+	return int(C.webpAnimSetCanvasSize(mux.mux, C.int(width), C.int(height)))
+}
+
+// webpAnimAssemble assembles an animation from a WebPMux. The assembled data
+// is copied to Go memory and freed, whether or not the assembly succeeds.
+func webpAnimAssemble(mux *WebPMux) (data []byte, err error) {
+	var assembled C.WebPData
+	res := C.webpAnimAssemble(mux.mux, &assembled)
+	defer C.WebPDataClear(&assembled)
+	if res != C.WEBP_MUX_OK {
+		err = errors.New("webpAnimAssemble: failed")
+		return
+	}
+	data = C.GoBytes(unsafe.Pointer(assembled.bytes), C.int(assembled.size))
+	return
+}
+
+// webpAnimSetChunk sets a metadata chunk (EXIF, ICCP or XMP) of a WebPMux,
+// replacing any previous chunk with the same fourcc. Empty data deletes the chunk.
+func webpAnimSetChunk(mux *WebPMux, fourcc string, data []byte) int {
+	cFourcc := C.CString(fourcc)
+	defer C.free(unsafe.Pointer(cFourcc))
+
+	if len(data) == 0 {
+		if err := C.webpAnimDeleteChunk(mux.mux, cFourcc); err != C.WEBP_MUX_NOT_FOUND {
+			return int(err)
+		}
+		return int(C.WEBP_MUX_OK)
+	}
+	return int(C.webpAnimSetChunk(mux.mux, cFourcc, (*C.uint8_t)(unsafe.Pointer(&data[0])), C.size_t(len(data))))
 }
 
 // webpMuxAnimDispose converts a dispose mode to a WebPMuxAnimDispose.
@@ -610,11 +1222,6 @@ func webpDataCreate(data []byte) (WebPData, unsafe.Pointer) {
 	return webpData, cData
 }
 
-// webpDataToBytes converts a WebPData to a Go byte slice.
-func webpDataToBytes(webpData WebPData) []byte {
-	return unsafe.Slice((*byte)(unsafe.Pointer(webpData.data.bytes)), int(webpData.data.size))
-}
-
 // webpMuxFrameInfoCreate creates a WebPMuxFrameInfo structure.
 func webpMuxFrameInfoCreate(data []byte, x, y, duration, disposeMode, blendMode int) (WebPMuxFrameInfo, unsafe.Pointer) {
 	webpData, cData := webpDataCreate(data)
@@ -639,3 +1246,112 @@ func webpMuxAnimParamsCreate(backgroundColor uint32, loopCount int) WebPMuxAnimP
 	animParams.params.loop_count = C.int(loopCount)
 	return animParams
 }
+
+// WebPAnimDecoder is a Go wrapper for C.WebPAnimDecoder
+type WebPAnimDecoder struct {
+	dec   *C.WebPAnimDecoder
+	cData unsafe.Pointer // The input must stay valid while the decoder is alive
+}
+
+// webpAnimDecoderNew creates a new WebPAnimDecoder decoding frames to
+// premultiplied RGBA. The data is copied to C memory, so the caller may reuse it.
+func webpAnimDecoderNew(data []byte) (*WebPAnimDecoder, error) {
+	if len(data) == 0 {
+		return nil, errors.New("webpAnimDecoderNew: bad arguments, data is empty")
+	}
+
+	cData := C.CBytes(data)
+	dec := C.webpAnimDecoderNew((*C.uint8_t)(cData), C.size_t(len(data)))
+	if dec == nil {
+		C.free(cData)
+		return nil, errors.New("webpAnimDecoderNew: failed")
+	}
+	return &WebPAnimDecoder{dec: dec, cData: cData}, nil
+}
+
+// webpAnimDecoderDelete deletes a WebPAnimDecoder and frees its input data.
+func webpAnimDecoderDelete(dec *WebPAnimDecoder) {
+	if dec != nil && dec.dec != nil {
+		C.webpAnimDecoderDelete(dec.dec)
+		C.free(dec.cData)
+		dec.dec = nil
+		dec.cData = nil
+	}
+}
+
+// webpAnimDecoderGetInfo returns the canvas size, loop count, background color
+// and frame count of the animation.
+func webpAnimDecoderGetInfo(dec *WebPAnimDecoder) (width, height, loopCount int, bgColor uint32, frameCount int, err error) {
+	var info C.WebPAnimInfo
+	if C.webpAnimDecoderGetInfo(dec.dec, &info) == 0 {
+		err = errors.New("webpAnimDecoderGetInfo: failed")
+		return
+	}
+	width, height = int(info.canvas_width), int(info.canvas_height)
+	loopCount = int(info.loop_count)
+	bgColor = uint32(info.bgcolor)
+	frameCount = int(info.frame_count)
+	return
+}
+
+// webpAnimDecoderHasMoreFrames reports whether there are frames left to decode.
+func webpAnimDecoderHasMoreFrames(dec *WebPAnimDecoder) bool {
+	return C.webpAnimDecoderHasMoreFrames(dec.dec) != 0
+}
+
+// webpAnimDecoderGetNext decodes the next frame into a copy of the composited
+// canvas. The timestamp is the end time of the frame in milliseconds.
+func webpAnimDecoderGetNext(dec *WebPAnimDecoder, width, height int) (pix []byte, timestamp int, err error) {
+	var cbuf *C.uint8_t
+	var ctimestamp C.int
+	if C.webpAnimDecoderGetNext(dec.dec, &cbuf, &ctimestamp) == 0 {
+		err = errors.New("webpAnimDecoderGetNext: failed")
+		return
+	}
+
+	// The buffer is owned by the decoder and is only valid until the next call.
+	pix = make([]byte, width*height*4)
+	copy(pix, unsafe.Slice((*byte)(unsafe.Pointer(cbuf)), len(pix)))
+	timestamp = int(ctimestamp)
+	return
+}
+
+// webpAnimDecoderSkip decodes the next frame onto the canvas without copying
+// it out. The timestamp is the end time of the frame in milliseconds.
+func webpAnimDecoderSkip(dec *WebPAnimDecoder) (timestamp int, err error) {
+	var cbuf *C.uint8_t
+	var ctimestamp C.int
+	if C.webpAnimDecoderGetNext(dec.dec, &cbuf, &ctimestamp) == 0 {
+		err = errors.New("webpAnimDecoderGetNext: failed")
+		return
+	}
+	timestamp = int(ctimestamp)
+	return
+}
+
+// webpAnimDecoderGetFrame returns the compressed payload of the frameNum-th
+// frame (1-based), with its chunk headers, and its placement on the canvas.
+func webpAnimDecoderGetFrame(dec *WebPAnimDecoder, frameNum int) (payload []byte, frame RawFrame, err error) {
+	var iter C.WebPIterator
+	if C.webpAnimDecoderGetFrame(dec.dec, C.int(frameNum), &iter) == 0 {
+		err = errors.New("webpAnimDecoderGetFrame: failed")
+		return
+	}
+
+	payload = C.GoBytes(unsafe.Pointer(iter.fragment.bytes), C.int(iter.fragment.size))
+	frame = RawFrame{
+		X:           int(iter.x_offset),
+		Y:           int(iter.y_offset),
+		Width:       int(iter.width),
+		Height:      int(iter.height),
+		Duration:    int(iter.duration),
+		DisposeMode: int(iter.dispose_method),
+		BlendMode:   int(iter.blend_method),
+	}
+	return
+}
+
+// webpAnimDecoderReset restarts decoding from the first frame.
+func webpAnimDecoderReset(dec *WebPAnimDecoder) {
+	C.webpAnimDecoderReset(dec.dec)
+}
@@ -25,12 +25,20 @@ type (
 	C_int32_t C.int32_t
 	C_int64_t C.int64_t
 
+	C_WebPConfig        C.WebPConfig
 	C_WebPMux           C.WebPMux
 	C_WebPMuxFrameInfo  C.WebPMuxFrameInfo
 	C_WebPMuxAnimParams C.WebPMuxAnimParams
 	C_WebPData          C.WebPData
 	C_WebPMuxError      C.WebPMuxError
 	C_WebPChunkId       C.WebPChunkId
+	C_WebPAnimDecoder   C.WebPAnimDecoder
+	C_WebPAnimInfo      C.WebPAnimInfo
+	C_WebPIterator      C.WebPIterator
+	C_WebPIDecoder      C.WebPIDecoder
+	C_WebPEncodingError C.WebPEncodingError
+	C_webpEncoder       C.webpEncoder
+	C_webpFileInfo      C.webpFileInfo
 )
 
 func C_webpGetInfo(
@@ -75,6 +83,28 @@ func C_webpDecodeRGBA(
 	))
 }
 
+func C_webpDecodeBGRA(
+	data *C_uint8_t, data_size C_size_t,
+	width *C_int, height *C_int,
+) *C_uint8_t {
+	return (*C_uint8_t)(C.webpDecodeBGRA(
+		(*C.uint8_t)(data), (C.size_t)(data_size),
+		(*C.int)(width), (*C.int)(height),
+	))
+}
+
+func C_webpDecodeRGBAWithOptions(
+	data *C_uint8_t, data_size C_size_t,
+	dithering_strength C_int, alpha_dithering_strength C_int,
+	out *C_uint8_t, out_size C_size_t, out_stride C_int,
+) C_int {
+	return (C_int)(C.webpDecodeRGBAWithOptions(
+		(*C.uint8_t)(data), (C.size_t)(data_size),
+		(C.int)(dithering_strength), (C.int)(alpha_dithering_strength),
+		(*C.uint8_t)(out), (C.size_t)(out_size), (C.int)(out_stride),
+	))
+}
+
 func C_webpDecodeGrayToSize(
 	data *C_uint8_t, data_size C_size_t,
 	width C_int, height C_int, outStride C_int,
@@ -114,6 +144,54 @@ func C_webpDecodeRGBAToSize(
 	))
 }
 
+func C_webpDecodeRGBACropped(
+	data *C_uint8_t, data_size C_size_t,
+	left C_int, top C_int, width C_int, height C_int, outStride C_int,
+	out *C_uint8_t,
+) C_int {
+	return (C_int)(C.webpDecodeRGBACropped(
+		(*C.uint8_t)(data), (C.size_t)(data_size),
+		(C.int)(left), (C.int)(top), (C.int)(width), (C.int)(height),
+		(C.int)(outStride),
+		(*C.uint8_t)(out),
+	))
+}
+
+func C_webpDecodeRGBAInto(
+	data *C_uint8_t, data_size C_size_t,
+	out *C_uint8_t, out_size C_size_t, out_stride C_int,
+) C_int {
+	return (C_int)(C.webpDecodeRGBAInto(
+		(*C.uint8_t)(data), (C.size_t)(data_size),
+		(*C.uint8_t)(out), (C.size_t)(out_size), (C.int)(out_stride),
+	))
+}
+
+func C_webpIDecoderNew() *C_WebPIDecoder {
+	return (*C_WebPIDecoder)(C.webpIDecoderNew())
+}
+
+func C_webpIDecoderAppend(idec *C_WebPIDecoder, data *C_uint8_t, data_size C_size_t) C_int {
+	return (C_int)(C.webpIDecoderAppend(
+		(*C.WebPIDecoder)(idec),
+		(*C.uint8_t)(data), (C.size_t)(data_size),
+	))
+}
+
+func C_webpIDecoderGetRGBA(
+	idec *C_WebPIDecoder,
+	last_y *C_int, width *C_int, height *C_int, stride *C_int,
+) *C_uint8_t {
+	return (*C_uint8_t)(C.webpIDecoderGetRGBA(
+		(*C.WebPIDecoder)(idec),
+		(*C.int)(last_y), (*C.int)(width), (*C.int)(height), (*C.int)(stride),
+	))
+}
+
+func C_webpIDecoderDelete(idec *C_WebPIDecoder) {
+	C.webpIDecoderDelete((*C.WebPIDecoder)(idec))
+}
+
 func C_webpEncodeGray(
 	pix *C_uint8_t,
 	width C_int, height C_int, stride C_int,
@@ -194,6 +272,79 @@ func C_webpEncodeLosslessRGBA(
 	))
 }
 
+func C_webpEncodeWithConfig(
+	config *C_WebPConfig, channels C_int,
+	pix *C_uint8_t,
+	width C_int, height C_int, stride C_int,
+	max_size C_size_t, output_size *C_size_t, error_code *C_WebPEncodingError,
+) *C_uint8_t {
+	return (*C_uint8_t)(C.webpEncodeWithConfig(
+		(*C.WebPConfig)(config), (C.int)(channels),
+		(*C.uint8_t)(pix),
+		(C.int)(width), (C.int)(height), (C.int)(stride),
+		(C.size_t)(max_size), (*C.size_t)(output_size), (*C.WebPEncodingError)(error_code),
+	))
+}
+
+func C_webpEncodeYUV420WithConfig(
+	config *C_WebPConfig,
+	y *C_uint8_t, y_stride C_int,
+	u *C_uint8_t, v *C_uint8_t, uv_stride C_int,
+	width C_int, height C_int,
+	max_size C_size_t, output_size *C_size_t, error_code *C_WebPEncodingError,
+) *C_uint8_t {
+	return (*C_uint8_t)(C.webpEncodeYUV420WithConfig(
+		(*C.WebPConfig)(config),
+		(*C.uint8_t)(y), (C.int)(y_stride),
+		(*C.uint8_t)(u), (*C.uint8_t)(v), (C.int)(uv_stride),
+		(C.int)(width), (C.int)(height),
+		(C.size_t)(max_size), (*C.size_t)(output_size), (*C.WebPEncodingError)(error_code),
+	))
+}
+
+func C_webpEncoderNew() *C_webpEncoder {
+	return (*C_webpEncoder)(C.webpEncoderNew())
+}
+
+func C_webpEncoderEncodeRGBA(
+	enc *C_webpEncoder, config *C_WebPConfig,
+	rgba *C_uint8_t, width C_int, height C_int, stride C_int,
+	dst *C_uint8_t, dst_size C_size_t,
+	output_size *C_size_t,
+) C_WebPEncodingError {
+	return (C_WebPEncodingError)(C.webpEncoderEncodeRGBA(
+		(*C.webpEncoder)(enc), (*C.WebPConfig)(config),
+		(*C.uint8_t)(rgba), (C.int)(width), (C.int)(height), (C.int)(stride),
+		(*C.uint8_t)(dst), (C.size_t)(dst_size),
+		(*C.size_t)(output_size),
+	))
+}
+
+func C_webpEncoderDelete(enc *C_webpEncoder) {
+	C.webpEncoderDelete((*C.webpEncoder)(enc))
+}
+
+func C_webpPictureDistortion(
+	src *C_uint8_t, src_stride C_int,
+	ref *C_uint8_t, ref_stride C_int,
+	width C_int, height C_int, metric_type C_int,
+	result *C_float,
+) C_int {
+	return C_int(C.webpPictureDistortion(
+		(*C.uint8_t)(src), (C.int)(src_stride),
+		(*C.uint8_t)(ref), (C.int)(ref_stride),
+		(C.int)(width), (C.int)(height), (C.int)(metric_type),
+		(*C.float)(result),
+	))
+}
+
+func C_webpInspect(data *C_uint8_t, data_size C_size_t, info *C_webpFileInfo) C_int {
+	return C_int(C.webpInspect(
+		(*C.uint8_t)(data), (C.size_t)(data_size),
+		(*C.webpFileInfo)(info),
+	))
+}
+
 func C_webpMalloc(size C_size_t) unsafe.Pointer {
 	return C.webpMalloc(C.size_t(size))
 }
@@ -221,6 +372,13 @@ func C_webpAnimSetAnimationParams(mux *C_WebPMux, params *C_WebPMuxAnimParams) C
 	))
 }
 
+func C_webpAnimSetCanvasSize(mux *C_WebPMux, width, height C_int) C_WebPMuxError {
+	return (C_WebPMuxError)(C.webpAnimSetCanvasSize(
+		(*C.WebPMux)(mux),
+		(C.int)(width), (C.int)(height),
+	))
+}
+
 func C_webpAnimAssemble(mux *C_WebPMux, assembled_data *C_WebPData) C_WebPMuxError {
 	return (C_WebPMuxError)(C.webpAnimAssemble(
 		(*C.WebPMux)(mux),
@@ -228,6 +386,59 @@ func C_webpAnimAssemble(mux *C_WebPMux, assembled_data *C_WebPData) C_WebPMuxErr
 	))
 }
 
+func C_webpAnimSetChunk(mux *C_WebPMux, fourcc *C.char, data *C_uint8_t, data_size C_size_t) C_WebPMuxError {
+	return (C_WebPMuxError)(C.webpAnimSetChunk(
+		(*C.WebPMux)(mux),
+		fourcc,
+		(*C.uint8_t)(data), (C.size_t)(data_size),
+	))
+}
+
+func C_webpAnimDeleteChunk(mux *C_WebPMux, fourcc *C.char) C_WebPMuxError {
+	return (C_WebPMuxError)(C.webpAnimDeleteChunk((*C.WebPMux)(mux), fourcc))
+}
+
 func C_webpAnimDelete(mux *C_WebPMux) {
 	C.webpAnimDelete((*C.WebPMux)(mux))
 }
+
+func C_webpAnimDecoderNew(data *C_uint8_t, data_size C_size_t) *C_WebPAnimDecoder {
+	return (*C_WebPAnimDecoder)(C.webpAnimDecoderNew(
+		(*C.uint8_t)(data), (C.size_t)(data_size),
+	))
+}
+
+func C_webpAnimDecoderGetInfo(dec *C_WebPAnimDecoder, info *C_WebPAnimInfo) C_int {
+	return C_int(C.webpAnimDecoderGetInfo(
+		(*C.WebPAnimDecoder)(dec),
+		(*C.WebPAnimInfo)(info),
+	))
+}
+
+func C_webpAnimDecoderHasMoreFrames(dec *C_WebPAnimDecoder) C_int {
+	return C_int(C.webpAnimDecoderHasMoreFrames((*C.WebPAnimDecoder)(dec)))
+}
+
+func C_webpAnimDecoderGetNext(dec *C_WebPAnimDecoder, buf **C_uint8_t, timestamp *C_int) C_int {
+	return C_int(C.webpAnimDecoderGetNext(
+		(*C.WebPAnimDecoder)(dec),
+		(**C.uint8_t)(unsafe.Pointer(buf)),
+		(*C.int)(timestamp),
+	))
+}
+
+func C_webpAnimDecoderGetFrame(dec *C_WebPAnimDecoder, frame_num C_int, iter *C_WebPIterator) C_int {
+	return C_int(C.webpAnimDecoderGetFrame(
+		(*C.WebPAnimDecoder)(dec),
+		(C.int)(frame_num),
+		(*C.WebPIterator)(iter),
+	))
+}
+
+func C_webpAnimDecoderReset(dec *C_WebPAnimDecoder) {
+	C.webpAnimDecoderReset((*C.WebPAnimDecoder)(dec))
+}
+
+func C_webpAnimDecoderDelete(dec *C_WebPAnimDecoder) {
+	C.webpAnimDecoderDelete((*C.WebPAnimDecoder)(dec))
+}
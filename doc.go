@@ -83,6 +83,15 @@ Decode and Encode as RGB format:
 		log.Fatal(err)
 	}
 
+RIFF Padding
+
+All the files written by this package follow the padding rule of the RIFF
+container: a chunk with an odd payload size is followed by a zero byte, which
+is not counted in the chunk size but is in the RIFF size. libwebp pads the
+chunks it writes, the encoder and the muxer alike, and so does this package
+for the chunks it writes itself. The padding is part of the format, so there
+is no option to leave it out.
+
 BUGS
 
 Report bugs to <chaishushan@gmail.com>.
@@ -0,0 +1,135 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package webp
+
+import (
+	"image"
+	"io"
+)
+
+// Encoder encodes RGBA images with fixed options, reusing its buffers across
+// calls. It suits servers encoding many images of the same size: the input
+// picture is only reallocated when the image size changes, and the output is
+// written straight into a buffer provided by the caller.
+//
+// An Encoder holds a single libwebp picture, which is imported into on each
+// call, so it is not safe for concurrent use: use one Encoder per goroutine.
+//
+// Usage:
+//
+//	enc, err := webp.NewEncoder(&webp.Options{Quality: 80})
+//	if err != nil {
+//		return err
+//	}
+//	defer enc.Close()
+//
+//	buf := make([]byte, 1<<20)
+//	for _, m := range images {
+//		n, err := enc.EncodeInto(buf, m)
+//		if err != nil {
+//			return err
+//		}
+//		// Use buf[:n]
+//	}
+type Encoder struct {
+	enc       *WebPEncoder
+	verify    bool
+	maxOutput int // Options.MaxOutputBytes
+
+	// buf is the output buffer of Encode, grown as needed and reused.
+	buf []byte
+}
+
+// NewEncoder creates an Encoder with the given options. A nil opt encodes
+// lossy images with DefaulQuality. The metadata fields of opt are ignored.
+//
+// The returned encoder must be closed with Close() when no longer needed
+// to avoid memory leaks.
+func NewEncoder(opt *Options) (*Encoder, error) {
+	if opt == nil {
+		opt = &Options{Quality: DefaulQuality}
+	}
+	enc, err := webpEncoderNew(opt)
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{enc: enc, verify: opt.VerifyOutput, maxOutput: opt.MaxOutputBytes}, nil
+}
+
+// EncodeInto encodes m into dst and returns the number of bytes written.
+//
+// The output is written directly into dst, so dst must be large enough to
+// hold the encoded image, otherwise io.ErrShortBuffer is returned. The size
+// of a previous output is a good hint for images of the same kind. With
+// Options.MaxOutputBytes set, at most that much of dst is used, and
+// ErrOutputTooLarge is returned instead when the output does not fit.
+// Returns ErrEncoderClosed after Close.
+func (e *Encoder) EncodeInto(dst []byte, m *image.RGBA) (n int, err error) {
+	if e.enc == nil {
+		return 0, ErrEncoderClosed
+	}
+	if err = checkDimensions(m.Rect); err != nil {
+		return 0, err
+	}
+	limited := e.maxOutput > 0 && len(dst) >= e.maxOutput
+	if limited {
+		dst = dst[:e.maxOutput]
+	}
+	if n, err = webpEncoderEncodeRGBA(e.enc, m.Pix, m.Rect.Dx(), m.Rect.Dy(), m.Stride, dst); err != nil {
+		if err == io.ErrShortBuffer && limited {
+			err = ErrOutputTooLarge
+		}
+		return 0, err
+	}
+	if e.verify {
+		if err = verifyOutput(dst[:n], m.Rect.Dx(), m.Rect.Dy()); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Encode encodes m and returns the output, like EncodeInto without having to
+// size the output buffer. The output is written into a buffer kept by the
+// encoder, which is grown and the image encoded again when the output does
+// not fit, so after the first images of a kind, each call encodes once and
+// only allocates the returned copy. The buffer is not grown past
+// Options.MaxOutputBytes.
+func (e *Encoder) Encode(m *image.RGBA) (data []byte, err error) {
+	if len(e.buf) == 0 {
+		e.buf = make([]byte, 64<<10)
+	}
+	for {
+		n, err := e.EncodeInto(e.buf, m)
+		if err == io.ErrShortBuffer {
+			size := 2 * len(e.buf)
+			if e.maxOutput > 0 && size > e.maxOutput {
+				size = e.maxOutput
+			}
+			e.buf = make([]byte, size)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data = make([]byte, n)
+		copy(data, e.buf)
+		return data, nil
+	}
+}
+
+// Close releases resources used by the Encoder.
+//
+// After calling Close, the encoder cannot be used anymore.
+func (e *Encoder) Close() {
+	if e.enc != nil {
+		webpEncoderDelete(e.enc)
+		e.enc = nil
+		e.buf = nil
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestEncoder_EncodeInto(t *testing.T) {
+	enc, err := NewEncoder(&Options{Lossless: true})
+	tAssertNil(t, err)
+	defer enc.Close()
+
+	buf := make([]byte, 1<<16)
+	for _, size := range []int{32, 32, 48, 16} {
+		img := tNoiseImage(size, size)
+		n, err := enc.EncodeInto(buf, img)
+		tAssertNil(t, err, "size ", size)
+
+		m, err := DecodeRGBA(buf[:n])
+		tAssertNil(t, err, "size ", size)
+		tAssertEQ(t, img.Rect, m.Rect, "size ", size)
+		tAssertEQ(t, img.Pix, m.Pix, "size ", size)
+	}
+
+	// Sub-images are encoded from their origin.
+	img := tNoiseImage(32, 32)
+	sub := img.SubImage(image.Rect(3, 5, 19, 21)).(*image.RGBA)
+	n, err := enc.EncodeInto(buf, sub)
+	tAssertNil(t, err)
+	m, err := DecodeRGBA(buf[:n])
+	tAssertNil(t, err)
+	tAssertEQ(t, 16, m.Rect.Dx())
+	tAssertEQ(t, sub.At(3, 5), m.At(0, 0))
+
+	_, err = enc.EncodeInto(buf[:64], img)
+	tAssertEQ(t, io.ErrShortBuffer, err)
+	_, err = enc.EncodeInto(nil, img)
+	tAssertEQ(t, io.ErrShortBuffer, err)
+
+	enc.Close()
+	_, err = enc.EncodeInto(buf, img)
+	tAssert(t, err != nil)
+}
+
+func TestEncoder_lossy(t *testing.T) {
+	img, err := loadImage("video-001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := toRGBAImage(img)
+
+	enc, err := NewEncoder(nil)
+	tAssertNil(t, err)
+	defer enc.Close()
+
+	buf := make([]byte, 1<<20)
+	for i := 0; i < 2; i++ {
+		n, err := enc.EncodeInto(buf, rgba)
+		tAssertNil(t, err)
+
+		m, err := Decode(bytes.NewReader(buf[:n]))
+		tAssertNil(t, err)
+		if got := averageDelta(rgba, m); got > 5 {
+			t.Fatalf("average delta too high; got %d, want <= 5", got)
+		}
+	}
+
+	_, err = NewEncoder(&Options{Quality: 101})
+	tAssert(t, err != nil)
+}
+
+func TestEncoder_Encode(t *testing.T) {
+	enc, err := NewEncoder(&Options{Lossless: true})
+	tAssertNil(t, err)
+	defer enc.Close()
+
+	// The noise does not compress, so the output outgrows the initial buffer.
+	for _, size := range []int{256, 16, 256} {
+		img := tNoiseImage(size, size)
+		data, err := enc.Encode(img)
+		tAssertNil(t, err, "size ", size)
+		m, err := DecodeRGBA(data)
+		tAssertNil(t, err, "size ", size)
+		tAssertEQ(t, img.Pix, m.Pix, "size ", size)
+	}
+
+	enc.Close()
+	_, err = enc.Encode(tNoiseImage(16, 16))
+	tAssert(t, errors.Is(err, ErrEncoderClosed), err)
+	_, err = enc.EncodeInto(make([]byte, 1024), tNoiseImage(16, 16))
+	tAssert(t, errors.Is(err, ErrEncoderClosed), err)
+}
+
+func TestEncoder_MaxOutputBytes(t *testing.T) {
+	m := tNoiseImage(256, 256)
+	data, err := EncodeStill(m, &Options{Lossless: true})
+	tAssertNil(t, err)
+
+	enc, err := NewEncoder(&Options{Lossless: true, MaxOutputBytes: len(data) - 1})
+	tAssertNil(t, err)
+	defer enc.Close()
+	_, err = enc.Encode(m)
+	tAssertEQ(t, ErrOutputTooLarge, err)
+	tAssert(t, len(enc.buf) < len(data), "buffer grown to", len(enc.buf))
+	_, err = enc.EncodeInto(make([]byte, 2*len(data)), m)
+	tAssertEQ(t, ErrOutputTooLarge, err)
+	_, err = enc.EncodeInto(make([]byte, 16), m)
+	tAssertEQ(t, io.ErrShortBuffer, err)
+}
@@ -0,0 +1,116 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"errors"
+	"fmt"
+	"image"
+)
+
+// MaxDimension is the maximum width and height of a WebP image, in pixels.
+const MaxDimension = 16383
+
+// ErrDimensionTooLarge is matched by errors.Is against the DimensionError
+// returned when an image is wider or taller than MaxDimension.
+var ErrDimensionTooLarge = errors.New("webp: image dimension too large")
+
+// DimensionError is returned by the encoding functions, before calling into
+// libwebp, for an image wider or taller than MaxDimension. It matches both
+// ErrDimensionTooLarge and ErrBadDimension.
+type DimensionError struct {
+	Width, Height int
+}
+
+func (e *DimensionError) Error() string {
+	switch {
+	case e.Width > MaxDimension && e.Height > MaxDimension:
+		return fmt.Sprintf("webp: image size %dx%d exceeds the maximum of %d pixels", e.Width, e.Height, MaxDimension)
+	case e.Width > MaxDimension:
+		return fmt.Sprintf("webp: image width %d exceeds the maximum of %d pixels", e.Width, MaxDimension)
+	default:
+		return fmt.Sprintf("webp: image height %d exceeds the maximum of %d pixels", e.Height, MaxDimension)
+	}
+}
+
+// Is reports whether target is ErrDimensionTooLarge or ErrBadDimension.
+func (e *DimensionError) Is(target error) bool {
+	return target == ErrDimensionTooLarge || target == ErrBadDimension
+}
+
+// checkDimensions returns a DimensionError if r is too large to be encoded.
+func checkDimensions(r image.Rectangle) error {
+	if r.Dx() > MaxDimension || r.Dy() > MaxDimension {
+		return &DimensionError{Width: r.Dx(), Height: r.Dy()}
+	}
+	return nil
+}
+
+// ErrOutputTooLarge is returned by the encoding functions when the encoded
+// image exceeds Options.MaxOutputBytes.
+var ErrOutputTooLarge = errors.New("webp: encoded image exceeds the maximum output size")
+
+// ErrEncoderClosed is returned by the methods of an Encoder, AnimationEncoder,
+// AnimationWriter or StreamingAnimation called after Close.
+var ErrEncoderClosed = errors.New("webp: encoder is closed")
+
+// ErrDecoderClosed is returned by the methods of a Decoder or an
+// AnimationDecoder called after Close.
+var ErrDecoderClosed = errors.New("webp: decoder is closed")
+
+// Common causes of encoding failures, matched by errors.Is against the
+// EncodeError returned by the encoding functions.
+var (
+	ErrOutOfMemory          = errors.New("webp: out of memory")
+	ErrInvalidConfiguration = errors.New("webp: invalid configuration")
+	ErrBadDimension         = errors.New("webp: bad picture dimension")
+	ErrFileTooBig           = errors.New("webp: file too big")
+)
+
+// EncodeError is the error returned when libwebp fails to encode an image,
+// with the reason reported by the encoder.
+//
+// Use errors.Is with ErrOutOfMemory, ErrInvalidConfiguration, ErrBadDimension
+// or ErrFileTooBig to check for the common causes.
+type EncodeError struct {
+	// Code is the WebPEncodingError code of libwebp, from 1 to 10.
+	Code int
+}
+
+// encodeErrorMessages are the descriptions of the WebPEncodingError codes.
+var encodeErrorMessages = [...]string{
+	1:  "out of memory",
+	2:  "out of memory for the bitstream",
+	3:  "null parameter",
+	4:  "invalid configuration",
+	5:  "bad picture dimension, the maximum width and height are 16383 pixels",
+	6:  "partition #0 is over 512KB, try a lower quality",
+	7:  "partition is over 16MB",
+	8:  "picture writer failed",
+	9:  "file would be over 4GB",
+	10: "encoding aborted",
+}
+
+func (e *EncodeError) Error() string {
+	if e.Code > 0 && e.Code < len(encodeErrorMessages) {
+		return "webp: encoding failed, " + encodeErrorMessages[e.Code]
+	}
+	return fmt.Sprintf("webp: encoding failed, error code %d", e.Code)
+}
+
+// Is reports whether target is the sentinel error matching the code.
+func (e *EncodeError) Is(target error) bool {
+	switch target {
+	case ErrOutOfMemory:
+		return e.Code == 1 || e.Code == 2
+	case ErrInvalidConfiguration:
+		return e.Code == 4
+	case ErrBadDimension:
+		return e.Code == 5
+	case ErrFileTooBig:
+		return e.Code == 6 || e.Code == 7 || e.Code == 9
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"errors"
+	"image"
+	"io"
+	"testing"
+)
+
+func TestEncodeError(t *testing.T) {
+	err := error(&EncodeError{Code: 1})
+	tAssert(t, errors.Is(err, ErrOutOfMemory))
+	tAssert(t, !errors.Is(err, ErrBadDimension))
+	tAssertEQ(t, "webp: encoding failed, out of memory", err.Error())
+	tAssert(t, errors.Is(&EncodeError{Code: 5}, ErrBadDimension))
+	tAssert(t, errors.Is(&EncodeError{Code: 9}, ErrFileTooBig))
+	tAssertEQ(t, "webp: encoding failed, error code 42", (&EncodeError{Code: 42}).Error())
+}
+
+func TestDimensionError(t *testing.T) {
+	// WebP is limited to 16383 pixels in width and height.
+	img := image.NewRGBA(image.Rect(0, 0, MaxDimension+1, 1))
+	check := func(err error) {
+		t.Helper()
+		var dimErr *DimensionError
+		tAssert(t, errors.As(err, &dimErr), "not a DimensionError: ", err)
+		tAssertEQ(t, MaxDimension+1, dimErr.Width)
+		tAssert(t, errors.Is(err, ErrDimensionTooLarge), "not ErrDimensionTooLarge: ", err)
+		tAssert(t, errors.Is(err, ErrBadDimension), "not ErrBadDimension: ", err)
+		tAssertEQ(t, "webp: image width 16384 exceeds the maximum of 16383 pixels", err.Error())
+	}
+
+	for _, opt := range []*Options{{Quality: 75}, {Lossless: true}} {
+		check(Encode(io.Discard, img, opt))
+	}
+	_, err := EncodeRGBA(img, 75)
+	check(err)
+	_, err = EncodeLosslessRGB(img)
+	check(err)
+
+	enc := NewAnimationEncoder()
+	defer enc.Close()
+	check(enc.AddFrame(Frame{Image: img, Duration: 100}))
+
+	tAssertNil(t, checkDimensions(image.Rect(0, 0, MaxDimension, MaxDimension)))
+	tAssertEQ(t, "webp: image size 20000x20000 exceeds the maximum of 16383 pixels",
+		checkDimensions(image.Rect(0, 0, 20000, 20000)).Error())
+}
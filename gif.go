@@ -0,0 +1,150 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package webp
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// EncodeGIF converts an animated GIF to an animated WebP image and writes it
+// to w. It returns the number of bytes written to w.
+//
+// Each GIF frame becomes a lossless WebP frame at the same position, with its
+// delay converted from centiseconds to milliseconds. Transparent pixels of a
+// frame let the previous canvas show through, as in the GIF. The disposal
+// methods are mapped to DisposeModeNone and DisposeModeBackground; where the
+// WebP format cannot express the GIF behaviour, such as gif.DisposalPrevious
+// or a frame at an odd offset, the next frame is stored as the whole canvas
+// instead.
+//
+// The canvas size and loop count are taken from g, unless set in params.
+// params.BackgroundColor is used as is.
+//
+// Returns an error if g has no frames or if the animation cannot be encoded.
+func EncodeGIF(w io.Writer, g *gif.GIF, params AnimationParams) (n int, err error) {
+	if len(g.Image) == 0 {
+		return 0, errors.New("webp: GIF has no frames")
+	}
+
+	if params.CanvasWidth == 0 && params.CanvasHeight == 0 {
+		params.CanvasWidth, params.CanvasHeight = gifCanvasSize(g)
+	}
+	if params.LoopCount == 0 {
+		params.LoopCount = gifLoopCount(g.LoopCount)
+	}
+
+	frames := gifFrames(g, image.Rect(0, 0, params.CanvasWidth, params.CanvasHeight))
+	return encodeAnimation(context.Background(), w, frames, params, nil, nil, 1)
+}
+
+// gifCanvasSize returns the logical screen size of g, or the size covering
+// all of its frames if it is not set.
+func gifCanvasSize(g *gif.GIF) (width, height int) {
+	if g.Config.Width != 0 && g.Config.Height != 0 {
+		return g.Config.Width, g.Config.Height
+	}
+	for _, m := range g.Image {
+		if b := m.Bounds(); b.Max.X > width {
+			width = b.Max.X
+		}
+		if b := m.Bounds(); b.Max.Y > height {
+			height = b.Max.Y
+		}
+	}
+	return width, height
+}
+
+// gifLoopCount converts a GIF loop count, the number of repetitions after the
+// first play with -1 for a single play, to the WebP loop count, the total
+// number of plays.
+func gifLoopCount(loopCount int) int {
+	switch {
+	case loopCount < 0:
+		return 1
+	case loopCount == 0:
+		return 0
+	default:
+		return loopCount + 1
+	}
+}
+
+// gifFrames converts the frames of g to WebP frames on the canvas.
+//
+// The GIF is composed on a copy of the canvas along the way, so the frames
+// that follow a disposal WebP cannot express can be stored as the whole
+// canvas.
+func gifFrames(g *gif.GIF, canvasRect image.Rectangle) []Frame {
+	canvas := image.NewRGBA(canvasRect)
+	var previous *image.RGBA
+
+	frames := make([]Frame, 0, len(g.Image))
+	fullCanvas := false
+	for i, m := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var delay int
+		if i < len(g.Delay) {
+			delay = g.Delay[i]
+		}
+
+		// Compose the frame, keeping the canvas to restore it afterwards
+		bounds := m.Bounds().Intersect(canvasRect)
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+		draw.Draw(canvas, bounds, m, bounds.Min, draw.Over)
+
+		frame := Frame{
+			Duration: delay * 10,
+			Lossless: true,
+		}
+		if fullCanvas {
+			frame.Image = cloneRGBA(canvas)
+			frame.BlendMode = BlendModeNoBlend
+			fullCanvas = disposal == gif.DisposalBackground || disposal == gif.DisposalPrevious
+		} else {
+			// Extend the frame to an even offset with transparent pixels,
+			// which leave the canvas unchanged when blended.
+			rect := image.Rect(bounds.Min.X&^1, bounds.Min.Y&^1, bounds.Max.X, bounds.Max.Y)
+			dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+			draw.Draw(dst, bounds.Sub(rect.Min), m, bounds.Min, draw.Src)
+			frame.Image = dst
+			frame.X, frame.Y = rect.Min.X, rect.Min.Y
+			frame.BlendMode = BlendModeBlend
+			if disposal == gif.DisposalBackground {
+				frame.DisposeMode = DisposeModeBackground
+			}
+			fullCanvas = disposal == gif.DisposalPrevious ||
+				disposal == gif.DisposalBackground && rect != bounds
+		}
+		frames = append(frames, frame)
+
+		// Dispose of the frame for the next one
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, bounds, image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+// cloneRGBA returns a copy of m.
+func cloneRGBA(m *image.RGBA) *image.RGBA {
+	c := *m
+	c.Pix = append([]byte(nil), m.Pix...)
+	return &c
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestEncodeGIF(t *testing.T) {
+	var (
+		red         = color.RGBA{255, 0, 0, 255}
+		blue        = color.RGBA{0, 0, 255, 255}
+		green       = color.RGBA{0, 255, 0, 255}
+		transparent = color.RGBA{}
+	)
+	palette := color.Palette{red, blue, green, transparent}
+	paletted := func(r image.Rectangle, index uint8) *image.Paletted {
+		m := image.NewPaletted(r, palette)
+		for i := range m.Pix {
+			m.Pix[i] = index
+		}
+		return m
+	}
+
+	// A green frame at an odd offset with a transparent pixel.
+	f2 := paletted(image.Rect(9, 9, 11, 11), 2)
+	f2.SetColorIndex(9, 9, 3)
+
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			paletted(image.Rect(0, 0, 16, 16), 0),
+			paletted(image.Rect(3, 3, 7, 7), 1),
+			f2,
+			paletted(image.Rect(0, 0, 2, 2), 1),
+		},
+		Delay:     []int{5, 10, 2, 0},
+		Disposal:  []byte{gif.DisposalNone, gif.DisposalPrevious, gif.DisposalBackground, gif.DisposalNone},
+		LoopCount: 2,
+	}
+
+	var buf bytes.Buffer
+	n, err := EncodeGIF(&buf, g, AnimationParams{})
+	tAssertNil(t, err)
+	tAssertEQ(t, buf.Len(), n)
+
+	dec, err := NewAnimationDecoder(&buf)
+	tAssertNil(t, err)
+	defer dec.Close()
+
+	params, width, height := dec.Info()
+	tAssertEQ(t, 16, width)
+	tAssertEQ(t, 16, height)
+	tAssertEQ(t, 3, params.LoopCount)
+
+	want := []struct {
+		duration int
+		pixels   map[image.Point]color.RGBA
+	}{
+		{50, map[image.Point]color.RGBA{{0, 0}: red, {15, 15}: red}},
+		{100, map[image.Point]color.RGBA{{2, 2}: red, {3, 3}: blue, {6, 6}: blue, {7, 7}: red}},
+		{20, map[image.Point]color.RGBA{{3, 3}: red, {9, 9}: red, {10, 9}: green, {10, 10}: green}},
+		{0, map[image.Point]color.RGBA{{1, 1}: blue, {2, 2}: red, {8, 8}: red, {9, 9}: transparent, {10, 10}: transparent}},
+	}
+	for i, v := range want {
+		m, duration, err := dec.Next()
+		tAssertNil(t, err, "frame ", i)
+		tAssertEQ(t, v.duration, duration, "frame ", i)
+		for p, c := range v.pixels {
+			tAssertEQ(t, c, color.RGBAModel.Convert(m.At(p.X, p.Y)), "frame ", i, " at ", p)
+		}
+	}
+}
+
+func TestEncodeGIF_noFrames(t *testing.T) {
+	_, err := EncodeGIF(&bytes.Buffer{}, &gif.GIF{}, AnimationParams{})
+	tAssert(t, err != nil)
+}
@@ -0,0 +1,101 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// maxWebpHeaderSize is the size of the RIFF header followed by the VP8X,
+	// VP8 or VP8L headers, which hold the dimensions and features of the image.
+	maxWebpHeaderSize = 32
+)
+
+// Feature flags of the VP8X chunk, reported in FileInfo.Flags.
+const (
+	FlagAnimation = 0x02
+	FlagXMP       = 0x04
+	FlagEXIF      = 0x08
+	FlagAlpha     = 0x10
+	FlagICCP      = 0x20
+)
+
+var errInvalidHeader = errors.New("webp: invalid format")
+
+// IsWebP reports whether data starts with the RIFF header of a WebP file. It
+// only matches the "RIFF" and "WEBP" magic, see Validate for a stricter check.
+func IsWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// readHeader reads the first maxWebpHeaderSize bytes of r, or less if r is
+// shorter.
+func readHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, maxWebpHeaderSize)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// parseHeader parses the RIFF header and the first chunk of a WebP image in
+// Go, without libwebp, and returns the dimensions and features of the image.
+//
+// Only the headers are checked, not the rest of the bitstream.
+func parseHeader(data []byte) (width, height int, hasAlpha, hasAnimation bool, err error) {
+	if len(data) < 20 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, false, false, errInvalidHeader
+	}
+	le24 := func(b []byte) int {
+		return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+	}
+
+	payload := data[20:]
+	switch string(data[12:16]) {
+	case "VP8X":
+		// Flags, 3 reserved bytes, then the canvas size minus one on 24 bits.
+		if len(payload) < 10 {
+			return 0, 0, false, false, errInvalidHeader
+		}
+		flags := payload[0]
+		width, height = 1+le24(payload[4:7]), 1+le24(payload[7:10])
+		hasAlpha, hasAnimation = flags&FlagAlpha != 0, flags&FlagAnimation != 0
+
+	case "VP8 ":
+		// The frame tag of a key frame, the start code, then the size on
+		// 14 bits with 2 bits of scaling.
+		if len(payload) < 10 || payload[0]&1 != 0 ||
+			payload[3] != 0x9d || payload[4] != 0x01 || payload[5] != 0x2a {
+			return 0, 0, false, false, errInvalidHeader
+		}
+		width = int(binary.LittleEndian.Uint16(payload[6:]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(payload[8:]) & 0x3fff)
+
+	case "VP8L":
+		// The signature, then the size minus one on 14 bits each, the alpha
+		// hint and the version on 3 bits.
+		if len(payload) < 5 || payload[0] != 0x2f {
+			return 0, 0, false, false, errInvalidHeader
+		}
+		bits := binary.LittleEndian.Uint32(payload[1:])
+		if bits>>29 != 0 {
+			return 0, 0, false, false, errInvalidHeader
+		}
+		width, height = 1+int(bits&0x3fff), 1+int(bits>>14&0x3fff)
+		hasAlpha = bits>>28&1 != 0
+
+	default:
+		return 0, 0, false, false, errInvalidHeader
+	}
+
+	if width == 0 || height == 0 {
+		return 0, 0, false, false, errInvalidHeader
+	}
+	return width, height, hasAlpha, hasAnimation, nil
+}
@@ -0,0 +1,48 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	files, err := filepath.Glob(testdataDir + "*.webp")
+	tAssertNil(t, err)
+	tAssert(t, len(files) > 0, "no test files")
+
+	// The headers agree with libwebp.
+	for _, name := range files {
+		data, err := os.ReadFile(name)
+		tAssertNil(t, err, name)
+		width, height, hasAlpha, hasAnimation, err := parseHeader(data[:maxWebpHeaderSize])
+		tAssertNil(t, err, name)
+		wantWidth, wantHeight, wantAlpha, err := GetInfo(data)
+		tAssertNil(t, err, name)
+		tAssertEQ(t, wantWidth, width, name)
+		tAssertEQ(t, wantHeight, height, name)
+		tAssertEQ(t, wantAlpha, hasAlpha, name)
+		tAssert(t, !hasAnimation, name, " is animated")
+	}
+
+	width, height, _, hasAnimation, err := parseHeader(tEncodeTestAnimation(t))
+	tAssertNil(t, err)
+	tAssertEQ(t, 32, width)
+	tAssertEQ(t, 24, height)
+	tAssert(t, hasAnimation, "animation not detected")
+
+	for _, data := range []string{
+		"",
+		"RIFF\x00\x00\x00\x00WEBP",
+		"RIFF\x00\x00\x00\x00WEBPVP8 \x00\x00\x00\x00",
+		"RIFF\x00\x00\x00\x00WEBPVP8L\x05\x00\x00\x00\x2e\x00\x00\x00\x00",
+		"RIFF\x00\x00\x00\x00WAVEVP8X\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00",
+	} {
+		_, _, _, _, err := parseHeader([]byte(data))
+		tAssert(t, err != nil, "invalid header ", []byte(data), " accepted")
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"encoding/binary"
+	"math"
+	"unicode/utf16"
+)
+
+// Color spaces for Options.ColorSpace, tagged in the output with an ICC
+// profile generated for them.
+const (
+	ColorSpaceDefault = 0 // untagged, which decoders take as sRGB
+	ColorSpaceSRGB    = 1 // sRGB, IEC 61966-2-1
+	ColorSpaceBT709   = 2 // ITU-R BT.709, the sRGB primaries with the BT.709 transfer curve
+)
+
+// iccColorSpace holds what tells the color spaces apart in their profiles:
+// the description and the parameters of the transfer curve, of the ICC
+// parametric function type 3: Y = (aX+b)^g for X >= d, and Y = cX below.
+type iccColorSpace struct {
+	desc          string
+	g, a, b, c, d float64
+}
+
+var iccColorSpaces = [...]iccColorSpace{
+	ColorSpaceSRGB:  {"sRGB", 2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045},
+	ColorSpaceBT709: {"ITU-R BT.709", 1 / 0.45, 1 / 1.099, 0.099 / 1.099, 1 / 4.5, 0.081},
+}
+
+// The sRGB and BT.709 primaries and the D65 white, adapted to the D50
+// illuminant of the profile connection space with the Bradford transform.
+var (
+	iccD50   = [3]float64{0.9642, 1, 0.8249}
+	iccRed   = [3]float64{0.4360747, 0.2225045, 0.0139322}
+	iccGreen = [3]float64{0.3850649, 0.7168786, 0.0971045}
+	iccBlue  = [3]float64{0.1430804, 0.0606169, 0.7141733}
+	iccChad  = [9]float64{
+		1.0478112, 0.0228866, -0.0501270,
+		0.0295424, 0.9904844, -0.0170491,
+		-0.0092345, 0.0150436, 0.7521316,
+	}
+)
+
+// iccProfile returns an ICC v4 display profile for the color space, or nil
+// for ColorSpaceDefault.
+func iccProfile(colorSpace int) []byte {
+	if colorSpace <= ColorSpaceDefault || colorSpace >= len(iccColorSpaces) {
+		return nil
+	}
+	cs := iccColorSpaces[colorSpace]
+
+	trc := iccTag("para", []byte{0, 3, 0, 0})
+	for _, v := range []float64{cs.g, cs.a, cs.b, cs.c, cs.d} {
+		trc = appendS15Fixed16(trc, v)
+	}
+	chad := iccTag("sf32", nil)
+	for _, v := range iccChad {
+		chad = appendS15Fixed16(chad, v)
+	}
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", iccText(cs.desc)},
+		{"cprt", iccText("No copyright, use freely")},
+		{"wtpt", iccXYZ(iccD50)},
+		{"chad", chad},
+		{"rXYZ", iccXYZ(iccRed)},
+		{"gXYZ", iccXYZ(iccGreen)},
+		{"bXYZ", iccXYZ(iccBlue)},
+		{"rTRC", trc},
+		{"gTRC", trc},
+		{"bTRC", trc},
+	}
+
+	// The header, then the tag table, then the tag data aligned on 4 bytes.
+	// The three curves share their data.
+	profile := make([]byte, 128+4+12*len(tags))
+	binary.BigEndian.PutUint32(profile[128:], uint32(len(tags)))
+	offsets := make(map[string]int)
+	for i, tag := range tags {
+		key := string(tag.data)
+		offset, ok := offsets[key]
+		if !ok {
+			offset = len(profile)
+			offsets[key] = offset
+			profile = append(profile, tag.data...)
+			for len(profile)%4 != 0 {
+				profile = append(profile, 0)
+			}
+		}
+		entry := profile[128+4+12*i:]
+		copy(entry, tag.sig)
+		binary.BigEndian.PutUint32(entry[4:], uint32(offset))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(tag.data)))
+	}
+
+	binary.BigEndian.PutUint32(profile[0:], uint32(len(profile)))
+	binary.BigEndian.PutUint32(profile[8:], 0x04300000) // version 4.3
+	copy(profile[12:], "mntrRGB XYZ ")
+	binary.BigEndian.PutUint16(profile[24:], 2025) // creation date, 2025-01-01
+	binary.BigEndian.PutUint16(profile[26:], 1)
+	binary.BigEndian.PutUint16(profile[28:], 1)
+	copy(profile[36:], "acsp")
+	illuminant := iccXYZ(iccD50)
+	copy(profile[68:], illuminant[8:])
+	return profile
+}
+
+// iccTag returns the start of the data of a tag of the given type.
+func iccTag(typ string, data []byte) []byte {
+	return append([]byte(typ+"\x00\x00\x00\x00"), data...)
+}
+
+// iccXYZ returns an XYZType tag holding the color.
+func iccXYZ(xyz [3]float64) []byte {
+	tag := iccTag("XYZ ", nil)
+	for _, v := range xyz {
+		tag = appendS15Fixed16(tag, v)
+	}
+	return tag
+}
+
+// iccText returns a multiLocalizedUnicodeType tag holding s in English.
+func iccText(s string) []byte {
+	tag := iccTag("mluc", nil)
+	tag = appendBE32(tag, 1)  // number of records
+	tag = appendBE32(tag, 12) // record size
+	tag = append(tag, "enUS"...)
+	text := utf16.Encode([]rune(s))
+	tag = appendBE32(tag, uint32(2*len(text)))
+	tag = appendBE32(tag, 28) // offset of the text
+	for _, c := range text {
+		tag = append(tag, byte(c>>8), byte(c))
+	}
+	return tag
+}
+
+// appendS15Fixed16 appends v as a signed 15.16 fixed-point number.
+func appendS15Fixed16(b []byte, v float64) []byte {
+	return appendBE32(b, uint32(int32(math.Round(v*65536))))
+}
+
+// appendBE32 appends v in big-endian order, the byte order of ICC profiles.
+func appendBE32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,135 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FileInfo describes the structure of a WebP file, as reported by Inspect.
+type FileInfo struct {
+	// Width and Height are the canvas dimensions.
+	Width, Height int
+
+	// Flags holds the feature flags, FlagAnimation, FlagAlpha and so on.
+	// For a file without a VP8X chunk, only FlagAlpha may be set.
+	Flags uint32
+
+	// FrameCount is the number of frames, 1 for a still image.
+	FrameCount int
+
+	// FrameFormats holds the bitstream format of each frame, FormatLossy or
+	// FormatLossless, from the FourCC of its VP8 or VP8L chunk.
+	FrameFormats []int
+
+	// LoopCount and BackgroundColor are the animation parameters, see
+	// AnimationParams. They are zero for a still image.
+	LoopCount       int
+	BackgroundColor uint32
+
+	// Chunks maps the FourCC of the chunks present in the file, such as
+	// "VP8X", "ICCP", "ANIM", "ALPH", "VP8 ", "VP8L", "EXIF" and "XMP ", to
+	// their payload size in bytes. The sizes of the per-frame chunks are
+	// summed over all the frames.
+	Chunks map[string]int
+}
+
+// Inspect reads a WebP file from r and reports its structure: the canvas size,
+// the feature flags, the number of frames, the animation parameters and the
+// chunks present with their sizes.
+//
+// Inspect reads the whole file, but does not decode the image.
+func Inspect(r io.Reader) (FileInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	info, err := webpInspect(data)
+	if err != nil {
+		return FileInfo{}, errors.New("webp: Inspect, invalid or corrupt data")
+	}
+
+	// The demuxer validated the RIFF header, followed by the first chunk.
+	if string(data[12:16]) == "VP8X" {
+		info.Chunks["VP8X"] = 10
+	}
+	if info.Flags&FlagAnimation != 0 {
+		info.Chunks["ANIM"] = 6
+	}
+	info.FrameFormats = frameFormats(data[12:], info.Flags&FlagAnimation != 0)
+	return info, nil
+}
+
+// frameFormats returns the bitstream formats of the frames held in the chunks
+// of a file validated by the demuxer: those of its ANMF chunks for an
+// animation, or the format of the still image.
+func frameFormats(chunks []byte, animated bool) []int {
+	if !animated {
+		return []int{frameFormat(chunks)}
+	}
+	var formats []int
+	for len(chunks) >= 8 {
+		size := int(binary.LittleEndian.Uint32(chunks[4:]))
+		if 8+size > len(chunks) {
+			break
+		}
+		if string(chunks[:4]) == "ANMF" && size >= 16 {
+			formats = append(formats, frameFormat(chunks[8+16:8+size]))
+		}
+		chunks = chunks[8+size:]
+		if size&1 != 0 && len(chunks) > 0 {
+			chunks = chunks[1:]
+		}
+	}
+	return formats
+}
+
+// frameFormat returns the bitstream format of the first VP8 or VP8L chunk in
+// chunks, or FormatUndefined if there is none.
+func frameFormat(chunks []byte) int {
+	for len(chunks) >= 8 {
+		switch string(chunks[:4]) {
+		case "VP8 ":
+			return FormatLossy
+		case "VP8L":
+			return FormatLossless
+		}
+		size := int(binary.LittleEndian.Uint32(chunks[4:]))
+		if 8+size+size&1 >= len(chunks) {
+			break
+		}
+		chunks = chunks[8+size+size&1:]
+	}
+	return FormatUndefined
+}
+
+// Validate checks that data holds a complete, well-formed WebP file: the RIFF
+// header and size, the chunk structure, and the headers of the bitstream, as
+// parsed by libwebp. The pixels are not decoded, so a corrupt bitstream past
+// its headers is only detected when decoding.
+//
+// Validate is safe on any input, including empty and truncated data, and is
+// meant to screen untrusted uploads before processing them.
+func Validate(data []byte) error {
+	if !IsWebP(data) {
+		return errors.New("webp: not a WebP file")
+	}
+	if size := int64(binary.LittleEndian.Uint32(data[4:])) + 8; size > int64(len(data)) {
+		return fmt.Errorf("webp: truncated file, %d of %d bytes", len(data), size)
+	}
+	if _, _, _, err := webpGetInfo(data); err != nil {
+		return errors.New("webp: invalid bitstream header")
+	}
+	if _, err := webpInspect(data); err != nil {
+		return errors.New("webp: invalid file structure")
+	}
+	return nil
+}
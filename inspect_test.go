@@ -0,0 +1,177 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	// A lossless still image without a VP8X chunk.
+	info, err := Inspect(bytes.NewReader(xLoadData("1_webp_ll.webp")))
+	tAssertNil(t, err)
+	tAssertEQ(t, 400, info.Width)
+	tAssertEQ(t, 301, info.Height)
+	tAssertEQ(t, 1, info.FrameCount)
+	tAssertEQ(t, []int{FormatLossless}, info.FrameFormats)
+	_, ok := info.Chunks["VP8L"]
+	tAssert(t, ok, "VP8L chunk missing: ", info.Chunks)
+	_, ok = info.Chunks["VP8X"]
+	tAssert(t, !ok, "unexpected VP8X chunk")
+
+	// A lossy still image with alpha and metadata.
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, createImage(32, 24, color.RGBA{255, 0, 0, 128}), &Options{
+		Quality: 75,
+		EXIF:    []byte("exif data"),
+		XMP:     []byte("<xmp/>"),
+	}))
+	info, err = Inspect(&buf)
+	tAssertNil(t, err)
+	tAssertEQ(t, 32, info.Width)
+	tAssertEQ(t, 24, info.Height)
+	tAssertEQ(t, uint32(FlagAlpha|FlagEXIF|FlagXMP), info.Flags)
+	tAssertEQ(t, []int{FormatLossy}, info.FrameFormats)
+	tAssertEQ(t, 9, info.Chunks["EXIF"])
+	tAssertEQ(t, 6, info.Chunks["XMP "])
+	for _, fourcc := range []string{"VP8X", "ALPH", "VP8 "} {
+		_, ok := info.Chunks[fourcc]
+		tAssert(t, ok, fourcc, " chunk missing: ", info.Chunks)
+	}
+	_, ok = info.Chunks["ICCP"]
+	tAssert(t, !ok, "unexpected ICCP chunk")
+
+	// An animation.
+	info, err = Inspect(bytes.NewReader(tEncodeTestAnimation(t)))
+	tAssertNil(t, err)
+	tAssertEQ(t, 2, info.FrameCount)
+	tAssertEQ(t, 3, info.LoopCount)
+	tAssert(t, info.Flags&FlagAnimation != 0, "animation flag not set")
+	tAssertEQ(t, 6, info.Chunks["ANIM"])
+	_, ok = info.Chunks["VP8L"]
+	tAssert(t, ok, "VP8L chunk missing: ", info.Chunks)
+	tAssertEQ(t, []int{FormatLossless, FormatLossless}, info.FrameFormats)
+
+	// An animation mixing lossy frames, one with an ALPH chunk, and lossless
+	// frames.
+	mixed, err := EncodeAnimationToBytes([]Frame{
+		{Image: createImage(8, 8, color.RGBA{255, 0, 0, 128}), Duration: 100},
+		{Image: tNoiseImage(5, 5), Duration: 100, Lossless: true},
+		{Image: createImage(8, 8, color.RGBA{0, 255, 0, 255}), Duration: 100},
+	}, AnimationParams{})
+	tAssertNil(t, err)
+	info, err = Inspect(bytes.NewReader(mixed))
+	tAssertNil(t, err)
+	tAssertEQ(t, []int{FormatLossy, FormatLossless, FormatLossy}, info.FrameFormats)
+
+	_, err = Inspect(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WEBP")))
+	tAssert(t, err != nil)
+}
+
+func TestValidate(t *testing.T) {
+	for _, filename := range []string{"1_webp_ll.webp", "1_webp_a.webp"} {
+		data := xLoadData(filename)
+		tAssert(t, IsWebP(data), filename)
+		tAssertNil(t, Validate(data), filename)
+	}
+	anim, err := EncodeAnimationToBytes([]Frame{{Image: tNoiseImage(8, 8), Duration: 100}}, AnimationParams{})
+	tAssertNil(t, err)
+	tAssertNil(t, Validate(anim))
+
+	data := xLoadData("1_webp_ll.webp")
+	for _, bad := range [][]byte{
+		nil,
+		[]byte("RIFF"),
+		[]byte("RIFF\x04\x00\x00\x00WEBP"),
+		xLoadData("1_webp_ll.png"),
+		data[:len(data)/2],
+		data[:40],
+		append(append([]byte(nil), data[:12]...), "VP8 \x00\x00\x00\x00"...),
+	} {
+		tAssert(t, Validate(bad) != nil, "invalid data accepted: ", len(bad))
+	}
+	tAssert(t, !IsWebP(nil) && !IsWebP([]byte("RIFF\x00\x00\x00\x00WEBX")), "IsWebP")
+	tAssert(t, IsWebP(data[:12]), "IsWebP on the header only")
+}
+
+func TestRIFFPadding(t *testing.T) {
+	// A 5x5 noise image encodes losslessly to an odd VP8L chunk, and the
+	// metadata sizes are odd as well.
+	m := tNoiseImage(5, 5)
+	opt := &Options{Lossless: true, EXIF: []byte("exif"), XMP: []byte("<x:xmp/>"), ICCProfile: []byte("icc")}
+	lossless, err := EncodeStill(m, &Options{Lossless: true})
+	tAssertNil(t, err)
+	tAssertEQ(t, uint32(1), binary.LittleEndian.Uint32(lossless[16:])%2)
+	tCheckRIFF(t, "lossless", lossless)
+	data, err := EncodeStill(m, opt)
+	tAssertNil(t, err)
+	tCheckRIFF(t, "metadata", data)
+	data, err = EncodeStill(m, &Options{Quality: 75, KeepAlpha: true, EXIF: []byte("exif")})
+	tAssertNil(t, err)
+	tCheckRIFF(t, "keep alpha", data)
+
+	frames := []Frame{
+		{Image: m, Duration: 100, Lossless: true},
+		{Image: createImage(5, 5, color.RGBA{0, 0, 255, 128}), Duration: 100},
+	}
+	anim, err := EncodeAnimationToBytes(frames, AnimationParams{})
+	tAssertNil(t, err)
+	tCheckRIFF(t, "animation", anim)
+	for i, frame := range tRawFrames(t, anim) {
+		tCheckRIFF(t, fmt.Sprint("raw frame ", i), frame.Data)
+	}
+
+	f, err := os.CreateTemp(t.TempDir(), "*.webp")
+	tAssertNil(t, err)
+	defer f.Close()
+	aw, err := NewAnimationWriter(f, AnimationParams{})
+	tAssertNil(t, err)
+	for _, frame := range frames {
+		tAssertNil(t, aw.AddFrame(frame))
+	}
+	tAssertNil(t, aw.Close())
+	_, err = f.Seek(0, io.SeekStart)
+	tAssertNil(t, err)
+	written, err := io.ReadAll(f)
+	tAssertNil(t, err)
+	tCheckRIFF(t, "animation writer", written)
+}
+
+// tCheckRIFF checks that the RIFF size of data matches its length and that
+// its chunks, and those nested in ANMF chunks, are padded to an even size
+// with a zero byte.
+func tCheckRIFF(t *testing.T, name string, data []byte) {
+	t.Helper()
+	tAssert(t, IsWebP(data), name, ": not a WebP file")
+	tAssertEQ(t, len(data)-8, int(binary.LittleEndian.Uint32(data[4:])), name)
+	tAssertEQ(t, 0, len(data)%2, name)
+
+	var walk func(chunks []byte)
+	walk = func(chunks []byte) {
+		for len(chunks) > 0 {
+			if len(chunks) < 8 {
+				t.Fatalf("%s: %d trailing bytes", name, len(chunks))
+			}
+			fourcc, size := string(chunks[:4]), int(binary.LittleEndian.Uint32(chunks[4:]))
+			if 8+size+size&1 > len(chunks) {
+				t.Fatalf("%s: %q chunk of %d bytes overflows, or misses its padding", name, fourcc, size)
+			}
+			if size&1 != 0 && chunks[8+size] != 0 {
+				t.Fatalf("%s: %q chunk padded with a nonzero byte", name, fourcc)
+			}
+			if fourcc == "ANMF" {
+				walk(chunks[8+16 : 8+size])
+			}
+			chunks = chunks[8+size+size&1:]
+		}
+	}
+	walk(data[12:])
+}
@@ -8,6 +8,8 @@
 #include <stddef.h>
 #include <stdint.h>
 #include <webp/decode.h>
+#include <webp/demux.h>
+#include <webp/encode.h>
 #include <webp/mux.h>
 #include <webp/mux_types.h>
 
@@ -15,6 +17,26 @@
 extern "C" {
 #endif
 
+// webpEncoder keeps its picture between calls, and writes the output to a
+// buffer provided by the caller.
+typedef struct {
+	WebPPicture pic;
+	uint8_t* dst;
+	size_t dst_size;
+	size_t size;
+} webpEncoder;
+
+// webpFileInfo describes the chunks of a WebP file. The chunk sizes are the
+// payload sizes summed over all the frames, or -1 if the chunk is absent.
+typedef struct {
+	int width, height;
+	uint32_t flags;
+	int frame_count, loop_count;
+	uint32_t bgcolor;
+	int64_t iccp_size, exif_size, xmp_size;
+	int64_t alph_size, vp8_size, vp8l_size;
+} webpFileInfo;
+
 int webpGetInfo(
 	const uint8_t* data, size_t data_size,
 	int* width, int* height,
@@ -33,6 +55,10 @@ uint8_t* webpDecodeRGBA(
 	const uint8_t* data, size_t data_size,
 	int* width, int* height
 );
+uint8_t* webpDecodeBGRA(
+	const uint8_t* data, size_t data_size,
+	int* width, int* height
+);
 
 int webpDecodeGrayToSize(const uint8_t* data, size_t data_size,
 	int width, int height, int outStride, uint8_t* out
@@ -43,6 +69,23 @@ int webpDecodeRGBToSize(const uint8_t* data, size_t data_size,
 int webpDecodeRGBAToSize(const uint8_t* data, size_t data_size,
 	int width, int height, int outStride, uint8_t* out
 );
+int webpDecodeRGBACropped(const uint8_t* data, size_t data_size,
+	int left, int top, int width, int height, int outStride, uint8_t* out
+);
+int webpDecodeRGBAInto(const uint8_t* data, size_t data_size,
+	uint8_t* out, size_t out_size, int out_stride
+);
+int webpDecodeRGBAWithOptions(const uint8_t* data, size_t data_size,
+	int dithering_strength, int alpha_dithering_strength,
+	uint8_t* out, size_t out_size, int out_stride
+);
+
+WebPIDecoder* webpIDecoderNew(void);
+int webpIDecoderAppend(WebPIDecoder* idec, const uint8_t* data, size_t data_size);
+const uint8_t* webpIDecoderGetRGBA(const WebPIDecoder* idec,
+	int* last_y, int* width, int* height, int* stride
+);
+void webpIDecoderDelete(WebPIDecoder* idec);
 
 uint8_t* webpEncodeGray(
 	const uint8_t* gray, int width, int height, int stride, float quality_factor,
@@ -70,6 +113,38 @@ uint8_t* webpEncodeLosslessRGBA(
 	size_t* output_size
 );
 
+uint8_t* webpEncodeWithConfig(
+	const WebPConfig* config, int channels,
+	const uint8_t* pix, int width, int height, int stride,
+	size_t max_size, size_t* output_size, WebPEncodingError* error_code
+);
+
+uint8_t* webpEncodeYUV420WithConfig(
+	const WebPConfig* config,
+	const uint8_t* y, int y_stride,
+	const uint8_t* u, const uint8_t* v, int uv_stride,
+	int width, int height,
+	size_t max_size, size_t* output_size, WebPEncodingError* error_code
+);
+
+webpEncoder* webpEncoderNew();
+WebPEncodingError webpEncoderEncodeRGBA(
+	webpEncoder* enc, const WebPConfig* config,
+	const uint8_t* rgba, int width, int height, int stride,
+	uint8_t* dst, size_t dst_size,
+	size_t* output_size
+);
+void webpEncoderDelete(webpEncoder* enc);
+
+int webpPictureDistortion(
+	const uint8_t* src, int src_stride,
+	const uint8_t* ref, int ref_stride,
+	int width, int height, int metric_type,
+	float result[5]
+);
+
+int webpInspect(const uint8_t* data, size_t data_size, webpFileInfo* info);
+
 char* webpGetEXIF(const uint8_t* data, size_t data_size, size_t* metadata_size);
 char* webpGetICCP(const uint8_t* data, size_t data_size, size_t* metadata_size);
 char* webpGetXMP(const uint8_t* data, size_t data_size, size_t* metadata_size);
@@ -88,9 +163,20 @@ void webpFree(void* p);
 WebPMux* webpAnimCreate();
 WebPMuxError webpAnimPushFrame(WebPMux* mux, const WebPMuxFrameInfo* frame, int copy_data);
 WebPMuxError webpAnimSetAnimationParams(WebPMux* mux, const WebPMuxAnimParams* params);
+WebPMuxError webpAnimSetCanvasSize(WebPMux* mux, int width, int height);
 WebPMuxError webpAnimAssemble(WebPMux* mux, WebPData* assembled_data);
+WebPMuxError webpAnimSetChunk(WebPMux* mux, const char* fourcc, const uint8_t* data, size_t data_size);
+WebPMuxError webpAnimDeleteChunk(WebPMux* mux, const char* fourcc);
 void webpAnimDelete(WebPMux* mux);
 
+WebPAnimDecoder* webpAnimDecoderNew(const uint8_t* data, size_t data_size);
+int webpAnimDecoderGetInfo(const WebPAnimDecoder* dec, WebPAnimInfo* info);
+int webpAnimDecoderHasMoreFrames(const WebPAnimDecoder* dec);
+int webpAnimDecoderGetNext(WebPAnimDecoder* dec, uint8_t** buf, int* timestamp);
+int webpAnimDecoderGetFrame(const WebPAnimDecoder* dec, int frame_num, WebPIterator* iter);
+void webpAnimDecoderReset(WebPAnimDecoder* dec);
+void webpAnimDecoderDelete(WebPAnimDecoder* dec);
+
 #ifdef __cplusplus
 }
 #endif
@@ -88,6 +88,63 @@ uint8_t* webpDecodeRGBA(
 	return WebPDecodeRGBA(data, data_size, width, height);
 }
 
+uint8_t* webpDecodeBGRA(
+	const uint8_t* data, size_t data_size,
+	int* width, int* height
+) {
+	return WebPDecodeBGRA(data, data_size, width, height);
+}
+
+int webpDecodeRGBAInto(const uint8_t* data, size_t data_size,
+	uint8_t* out, size_t out_size, int out_stride
+) {
+	return WebPDecodeRGBAInto(data, data_size, out, out_size, out_stride) != NULL;
+}
+
+// webpDecodeRGBAWithOptions decodes like webpDecodeRGBAInto, with the given
+// decoding options.
+int webpDecodeRGBAWithOptions(const uint8_t* data, size_t data_size,
+	int dithering_strength, int alpha_dithering_strength,
+	uint8_t* out, size_t out_size, int out_stride
+) {
+	WebPDecoderConfig config;
+	if(!WebPInitDecoderConfig(&config)) {
+		return -1;
+	}
+
+	config.options.dithering_strength = dithering_strength;
+	config.options.alpha_dithering_strength = alpha_dithering_strength;
+	config.output.colorspace = MODE_RGBA;
+	config.output.u.RGBA.rgba = out;
+	config.output.u.RGBA.stride = out_stride;
+	config.output.u.RGBA.size = out_size;
+	config.output.is_external_memory = 1;
+
+	return WebPDecode(data, data_size, &config);
+}
+
+// webpIDecoderNew creates an incremental decoder to RGBA, which allocates the
+// output itself.
+WebPIDecoder* webpIDecoderNew(void) {
+	return WebPINewRGB(MODE_RGBA, NULL, 0, 0);
+}
+
+// webpIDecoderAppend copies the data to the decoder and decodes as much of the
+// image as possible. Returns VP8_STATUS_SUSPENDED until the image is complete.
+int webpIDecoderAppend(WebPIDecoder* idec, const uint8_t* data, size_t data_size) {
+	return WebPIAppend(idec, data, data_size);
+}
+
+const uint8_t* webpIDecoderGetRGBA(const WebPIDecoder* idec,
+	int* last_y, int* width, int* height, int* stride
+) {
+	return WebPIDecGetRGB(idec, last_y, width, height, stride);
+}
+
+void webpIDecoderDelete(WebPIDecoder* idec) {
+	WebPIDelete(idec);
+}
+
 int webpDecodeGrayToSize(const uint8_t* data, size_t data_size,
 	int width, int height, int outStride, uint8_t* out
 ) {
@@ -167,6 +224,28 @@ int webpDecodeRGBAToSize(const uint8_t* data, size_t data_size,
 	return WebPDecode(data, data_size, &config);
 }
 
+int webpDecodeRGBACropped(const uint8_t* data, size_t data_size,
+	int left, int top, int width, int height, int outStride, uint8_t* out
+) {
+	WebPDecoderConfig config;
+	if(!WebPInitDecoderConfig(&config)) {
+		return -1;
+	}
+
+	config.options.use_cropping = 1;
+	config.options.crop_left = left;
+	config.options.crop_top = top;
+	config.options.crop_width = width;
+	config.options.crop_height = height;
+	config.output.colorspace = MODE_RGBA;
+	config.output.u.RGBA.rgba = out;
+	config.output.u.RGBA.stride = outStride;
+	config.output.u.RGBA.size = outStride * height;
+	config.output.is_external_memory = 1;
+
+	return WebPDecode(data, data_size, &config);
+}
+
 uint8_t* webpEncodeGray(
 	const uint8_t* gray, int width, int height, int stride, float quality_factor,
 	size_t* output_size
@@ -287,6 +366,331 @@ uint8_t* webpEncodeLosslessRGBA(
 	return wrt.mem;
 }
 
+// webpLimitedWriter is a WebPMemoryWriter failing the writes beyond max_size
+// bytes, unless max_size is 0, which stops the encoding before more memory
+// is allocated.
+typedef struct {
+	WebPMemoryWriter wrt; // first, as WebPMemoryWrite takes custom_ptr for it
+	size_t max_size;
+} webpLimitedWriter;
+
+static int webpLimitedWrite(const uint8_t* data, size_t data_size, const WebPPicture* pic) {
+	webpLimitedWriter* w = (webpLimitedWriter*)pic->custom_ptr;
+	if(w->max_size > 0 && data_size > w->max_size - w->wrt.size) {
+		return 0;
+	}
+	return WebPMemoryWrite(data, data_size, pic);
+}
+
+// On failure, the encoding functions return NULL and set error_code to the
+// reason reported by libwebp, VP8_ENC_ERROR_BAD_WRITE if the output exceeds
+// max_size.
+//
+// The channels are 1 for gray, 3 for RGB, 4 for RGBA and -4 for BGRA.
+uint8_t* webpEncodeWithConfig(
+	const WebPConfig* config, int channels,
+	const uint8_t* pix, int width, int height, int stride,
+	size_t max_size, size_t* output_size, WebPEncodingError* error_code
+) {
+	WebPPicture pic;
+	webpLimitedWriter wrt;
+	uint8_t* rgb;
+	int x, y;
+	int ok;
+
+	*error_code = VP8_ENC_ERROR_NULL_PARAMETER;
+	if (!WebPPictureInit(&pic)) {
+		return NULL;
+	}
+
+	// The sharp and the dithered RGB to YUV conversions happen in WebPEncode,
+	// on ARGB samples.
+	pic.use_argb = config->lossless || config->use_sharp_yuv || (config->preprocessing & 2);
+	pic.width = width;
+	pic.height = height;
+
+	pic.writer = webpLimitedWrite;
+	pic.custom_ptr = &wrt;
+	WebPMemoryWriterInit(&wrt.wrt);
+	wrt.max_size = max_size;
+
+	switch(channels) {
+	case 1:
+		if((rgb = (uint8_t*)malloc(width*height*3)) == NULL) {
+			*error_code = VP8_ENC_ERROR_OUT_OF_MEMORY;
+			return NULL;
+		}
+		for(y = 0; y < height; ++y) {
+			const uint8_t* src = pix + y*stride;
+			uint8_t* dst = rgb + y*width*3;
+			for(x = 0; x < width; ++x) {
+				uint8_t v = *src++;
+				*dst++ = v;
+				*dst++ = v;
+				*dst++ = v;
+			}
+		}
+		ok = WebPPictureImportRGB(&pic, rgb, width*3);
+		free(rgb);
+		break;
+	case 3:
+		ok = WebPPictureImportRGB(&pic, pix, stride);
+		break;
+	case 4:
+		ok = WebPPictureImportRGBA(&pic, pix, stride);
+		break;
+	case -4:
+		ok = WebPPictureImportBGRA(&pic, pix, stride);
+		break;
+	default:
+		ok = 0;
+	}
+	ok = ok && WebPEncode(config, &pic);
+
+	*error_code = pic.error_code;
+	WebPPictureFree(&pic);
+	if (!ok) {
+		WebPMemoryWriterClear(&wrt.wrt);
+		return NULL;
+	}
+	*output_size = wrt.wrt.size;
+
+	return wrt.wrt.mem;
+}
+
+// The planes use full range (JFIF) YCbCr, as produced by JPEG decoders,
+// and are scaled to the limited range (BT.601) expected by libwebp.
+uint8_t* webpEncodeYUV420WithConfig(
+	const WebPConfig* config,
+	const uint8_t* y, int y_stride,
+	const uint8_t* u, const uint8_t* v, int uv_stride,
+	int width, int height,
+	size_t max_size, size_t* output_size, WebPEncodingError* error_code
+) {
+	WebPPicture pic;
+	webpLimitedWriter wrt;
+	int uv_width = (width + 1) / 2;
+	int uv_height = (height + 1) / 2;
+	int i, j;
+	int ok;
+
+	*error_code = VP8_ENC_ERROR_NULL_PARAMETER;
+	if (!WebPPictureInit(&pic)) {
+		return NULL;
+	}
+
+	pic.use_argb = 0;
+	pic.colorspace = WEBP_YUV420;
+	pic.width = width;
+	pic.height = height;
+	if (!WebPPictureAlloc(&pic)) {
+		*error_code = pic.error_code;
+		return NULL;
+	}
+
+	for(j = 0; j < height; ++j) {
+		const uint8_t* src = y + j*y_stride;
+		uint8_t* dst = pic.y + j*pic.y_stride;
+		for(i = 0; i < width; ++i) {
+			dst[i] = (uint8_t)((src[i]*219 + 16*255 + 127) / 255);
+		}
+	}
+	for(j = 0; j < uv_height; ++j) {
+		const uint8_t* src_u = u + j*uv_stride;
+		const uint8_t* src_v = v + j*uv_stride;
+		uint8_t* dst_u = pic.u + j*pic.uv_stride;
+		uint8_t* dst_v = pic.v + j*pic.uv_stride;
+		for(i = 0; i < uv_width; ++i) {
+			dst_u[i] = (uint8_t)((src_u[i]*224 + 128*31 + 127) / 255);
+			dst_v[i] = (uint8_t)((src_v[i]*224 + 128*31 + 127) / 255);
+		}
+	}
+
+	pic.writer = webpLimitedWrite;
+	pic.custom_ptr = &wrt;
+	WebPMemoryWriterInit(&wrt.wrt);
+	wrt.max_size = max_size;
+
+	ok = WebPEncode(config, &pic);
+
+	*error_code = pic.error_code;
+	WebPPictureFree(&pic);
+	if (!ok) {
+		WebPMemoryWriterClear(&wrt.wrt);
+		return NULL;
+	}
+	*output_size = wrt.wrt.size;
+
+	return wrt.wrt.mem;
+}
+
+static int webpEncoderWrite(const uint8_t* data, size_t data_size, const WebPPicture* pic) {
+	webpEncoder* enc = (webpEncoder*)pic->custom_ptr;
+	if(data_size > enc->dst_size - enc->size) {
+		return 0;
+	}
+	memcpy(enc->dst + enc->size, data, data_size);
+	enc->size += data_size;
+	return 1;
+}
+
+webpEncoder* webpEncoderNew() {
+	webpEncoder* enc = (webpEncoder*)calloc(1, sizeof(webpEncoder));
+	if(enc == NULL) {
+		return NULL;
+	}
+	if(!WebPPictureInit(&enc->pic)) {
+		free(enc);
+		return NULL;
+	}
+	enc->pic.writer = webpEncoderWrite;
+	enc->pic.custom_ptr = enc;
+	return enc;
+}
+
+WebPEncodingError webpEncoderEncodeRGBA(
+	webpEncoder* enc, const WebPConfig* config,
+	const uint8_t* rgba, int width, int height, int stride,
+	uint8_t* dst, size_t dst_size,
+	size_t* output_size
+) {
+	WebPPicture* pic = &enc->pic;
+	int x, y;
+	int ok;
+
+	// The ARGB buffer is only reallocated when the size changes. The lossy
+	// encoder converts it to YUV and clears use_argb, so it is set every time.
+	pic->use_argb = 1;
+	if(pic->argb == NULL || pic->width != width || pic->height != height) {
+		pic->width = width;
+		pic->height = height;
+		if(!WebPPictureAlloc(pic)) {
+			return pic->error_code;
+		}
+	}
+	for(y = 0; y < height; ++y) {
+		const uint8_t* src = rgba + y*stride;
+		uint32_t* argb = pic->argb + y*pic->argb_stride;
+		for(x = 0; x < width; ++x) {
+			argb[x] = ((uint32_t)src[3] << 24) | ((uint32_t)src[0] << 16) | ((uint32_t)src[1] << 8) | src[2];
+			src += 4;
+		}
+	}
+
+	enc->dst = dst;
+	enc->dst_size = dst_size;
+	enc->size = 0;
+	ok = WebPEncode(config, pic);
+	enc->dst = NULL;
+	enc->dst_size = 0;
+	if(!ok) {
+		return pic->error_code;
+	}
+	*output_size = enc->size;
+	return VP8_ENC_OK;
+}
+
+void webpEncoderDelete(webpEncoder* enc) {
+	if(enc != NULL) {
+		WebPPictureFree(&enc->pic);
+		free(enc);
+	}
+}
+
+// webpPictureDistortion computes the distortion of the RGBA pixels of ref
+// against those of src, in dB for the B, G, R, A channels and all of them.
+int webpPictureDistortion(
+	const uint8_t* src, int src_stride,
+	const uint8_t* ref, int ref_stride,
+	int width, int height, int metric_type,
+	float result[5]
+) {
+	WebPPicture a, b;
+	int ok;
+
+	if(!WebPPictureInit(&a) || !WebPPictureInit(&b)) {
+		return 0;
+	}
+	a.use_argb = b.use_argb = 1;
+	a.width = b.width = width;
+	a.height = b.height = height;
+	ok = WebPPictureImportRGBA(&a, src, src_stride) &&
+		WebPPictureImportRGBA(&b, ref, ref_stride) &&
+		WebPPictureDistortion(&a, &b, metric_type, result);
+	WebPPictureFree(&a);
+	WebPPictureFree(&b);
+	return ok;
+}
+
+static int64_t webpChunkSize(WebPDemuxer* demux, const char* fourcc) {
+	WebPChunkIterator it;
+	int64_t size = -1;
+	memset(&it, 0, sizeof(it));
+	if(WebPDemuxGetChunk(demux, fourcc, 1, &it)) {
+		size = (int64_t)it.chunk.size;
+	}
+	WebPDemuxReleaseChunkIterator(&it);
+	return size;
+}
+
+static void webpAddChunkSize(int64_t* total, uint32_t size) {
+	if(*total < 0) {
+		*total = 0;
+	}
+	*total += size;
+}
+
+int webpInspect(const uint8_t* data, size_t data_size, webpFileInfo* info) {
+	WebPData webp_data = {data, data_size};
+	WebPDemuxer* demux = WebPDemux(&webp_data);
+	WebPIterator iter;
+	if(demux == NULL) {
+		return 0;
+	}
+
+	info->width = (int)WebPDemuxGetI(demux, WEBP_FF_CANVAS_WIDTH);
+	info->height = (int)WebPDemuxGetI(demux, WEBP_FF_CANVAS_HEIGHT);
+	info->flags = WebPDemuxGetI(demux, WEBP_FF_FORMAT_FLAGS);
+	info->frame_count = (int)WebPDemuxGetI(demux, WEBP_FF_FRAME_COUNT);
+	info->loop_count = (int)WebPDemuxGetI(demux, WEBP_FF_LOOP_COUNT);
+	info->bgcolor = WebPDemuxGetI(demux, WEBP_FF_BACKGROUND_COLOR);
+	info->iccp_size = webpChunkSize(demux, "ICCP");
+	info->exif_size = webpChunkSize(demux, "EXIF");
+	info->xmp_size = webpChunkSize(demux, "XMP ");
+	info->alph_size = -1;
+	info->vp8_size = -1;
+	info->vp8l_size = -1;
+
+	// The payload of a frame is its optional ALPH chunk, any unknown chunks
+	// and its VP8 or VP8L chunk, with the chunk headers.
+	if(WebPDemuxGetFrame(demux, 1, &iter)) {
+		do {
+			const uint8_t* p = iter.fragment.bytes;
+			size_t size = iter.fragment.size;
+			while(size >= 8) {
+				uint32_t chunk_size = p[4] | (p[5] << 8) | (p[6] << 16) | ((uint32_t)p[7] << 24);
+				size_t padded_size = 8 + (size_t)chunk_size + (chunk_size & 1);
+				if(!memcmp(p, "ALPH", 4)) {
+					webpAddChunkSize(&info->alph_size, chunk_size);
+				} else if(!memcmp(p, "VP8 ", 4)) {
+					webpAddChunkSize(&info->vp8_size, chunk_size);
+				} else if(!memcmp(p, "VP8L", 4)) {
+					webpAddChunkSize(&info->vp8l_size, chunk_size);
+				}
+				if(padded_size >= size) {
+					break;
+				}
+				p += padded_size;
+				size -= padded_size;
+			}
+		} while(WebPDemuxNextFrame(&iter));
+		WebPDemuxReleaseIterator(&iter);
+	}
+
+	WebPDemuxDelete(demux);
+	return 1;
+}
+
 char* webpGetEXIF(const uint8_t* data, size_t data_size, size_t* metadata_size) {
 	char* metadata = NULL;
 	WebPData webp_data = {data, data_size};
@@ -454,10 +858,65 @@ WebPMuxError webpAnimSetAnimationParams(WebPMux* mux, const WebPMuxAnimParams* p
 	return WebPMuxSetAnimationParams(mux, params);
 }
 
+WebPMuxError webpAnimSetCanvasSize(WebPMux* mux, int width, int height) {
+	return WebPMuxSetCanvasSize(mux, width, height);
+}
+
 WebPMuxError webpAnimAssemble(WebPMux* mux, WebPData* assembled_data) {
 	return WebPMuxAssemble(mux, assembled_data);
 }
 
+WebPMuxError webpAnimSetChunk(WebPMux* mux, const char* fourcc, const uint8_t* data, size_t data_size) {
+	WebPData chunk = {data, data_size};
+	return WebPMuxSetChunk(mux, fourcc, &chunk, 1);
+}
+
+WebPMuxError webpAnimDeleteChunk(WebPMux* mux, const char* fourcc) {
+	return WebPMuxDeleteChunk(mux, fourcc);
+}
+
 void webpAnimDelete(WebPMux* mux) {
 	WebPMuxDelete(mux);
 }
+
+WebPAnimDecoder* webpAnimDecoderNew(const uint8_t* data, size_t data_size) {
+	WebPData webp_data = {data, data_size};
+	WebPAnimDecoderOptions options;
+	if(!WebPAnimDecoderOptionsInit(&options)) {
+		return NULL;
+	}
+	options.color_mode = MODE_rgbA;
+	options.use_threads = 0;
+	return WebPAnimDecoderNew(&webp_data, &options);
+}
+
+int webpAnimDecoderGetInfo(const WebPAnimDecoder* dec, WebPAnimInfo* info) {
+	return WebPAnimDecoderGetInfo(dec, info);
+}
+
+int webpAnimDecoderHasMoreFrames(const WebPAnimDecoder* dec) {
+	return WebPAnimDecoderHasMoreFrames(dec);
+}
+
+int webpAnimDecoderGetNext(WebPAnimDecoder* dec, uint8_t** buf, int* timestamp) {
+	return WebPAnimDecoderGetNext(dec, buf, timestamp);
+}
+
+// webpAnimDecoderGetFrame fills iter with the frame_num-th frame (1-based) of
+// the demuxed data, without decoding it. The fragment points into the input
+// of the decoder, and releasing the iterator is a no-op.
+int webpAnimDecoderGetFrame(const WebPAnimDecoder* dec, int frame_num, WebPIterator* iter) {
+	const WebPDemuxer* demux = WebPAnimDecoderGetDemuxer(dec);
+	if(demux == NULL) {
+		return 0;
+	}
+	return WebPDemuxGetFrame(demux, frame_num, iter);
+}
+
+void webpAnimDecoderReset(WebPAnimDecoder* dec) {
+	WebPAnimDecoderReset(dec);
+}
+
+void webpAnimDecoderDelete(WebPAnimDecoder* dec) {
+	WebPAnimDecoderDelete(dec);
+}
@@ -0,0 +1,94 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// exifOrientation returns the orientation tag, from 1 to 8, stored in the
+// first IFD of the EXIF data, or 1 if there is none.
+func exifOrientation(exif []byte) int {
+	// The EXIF chunk holds the TIFF structure, sometimes after the
+	// "Exif\0\0" header of the JPEG APP1 segment.
+	exif = bytes.TrimPrefix(exif, []byte("Exif\x00\x00"))
+	if len(exif) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(exif[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(exif[4:]))
+	if ifd < 8 || ifd+2 > len(exif) {
+		return 1
+	}
+	entries := int(order.Uint16(exif[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := exif[ifd+2+12*i:]
+		if len(entry) < 12 {
+			break
+		}
+		// The orientation is a single SHORT, stored in the value field.
+		if order.Uint16(entry) == 0x0112 && order.Uint16(entry[2:]) == 3 {
+			if v := int(order.Uint16(entry[8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orientRGBA returns m transformed as described by the EXIF orientation, so
+// that it displays upright. Orientations 5 to 8 swap the width and height.
+func orientRGBA(m *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return m
+	}
+
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	// source returns the position in m of the pixel at (x, y) in dst.
+	source := func(x, y int) (int, int) {
+		switch orientation {
+		case 2: // flipped horizontally
+			return w - 1 - x, y
+		case 3: // rotated 180°
+			return w - 1 - x, h - 1 - y
+		case 4: // flipped vertically
+			return x, h - 1 - y
+		case 5: // transposed
+			return y, x
+		case 6: // rotated 90° counterclockwise, displayed rotated clockwise
+			return y, h - 1 - x
+		case 7: // transversed
+			return w - 1 - y, h - 1 - x
+		default: // 8, rotated 90° clockwise, displayed rotated counterclockwise
+			return w - 1 - y, x
+		}
+	}
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := source(x, y)
+			i, j := dst.PixOffset(x, y), m.PixOffset(m.Rect.Min.X+sx, m.Rect.Min.Y+sy)
+			copy(dst.Pix[i:i+4], m.Pix[j:j+4])
+		}
+	}
+	return dst
+}
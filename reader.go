@@ -9,6 +9,7 @@ package webp
 
 import (
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"io"
@@ -23,21 +24,7 @@ func LoadConfig(name string) (config image.Config, err error) {
 	}
 	defer f.Close()
 
-	header := make([]byte, maxWebpHeaderSize)
-	n, err := f.Read(header)
-	if err != nil && err != io.EOF {
-		return
-	}
-	header = header[:n]
-	width, height, _, err := GetInfo(header)
-	if err != nil {
-		return
-	}
-
-	config.Width = width
-	config.Height = height
-	config.ColorModel = color.RGBAModel
-	return
+	return DecodeConfig(f)
 }
 
 func Load(name string) (m image.Image, err error) {
@@ -67,13 +54,16 @@ func Load(name string) (m image.Image, err error) {
 
 // DecodeConfig returns the color model and dimensions of a WEBP image without
 // decoding the entire image.
+//
+// Only the first maxWebpHeaderSize bytes of r are read, which hold the
+// VP8X, VP8 or VP8L headers. The color model is color.RGBAModel, that of the
+// image returned by Decode; use GetInfo or GetFeatures to know whether the
+// image has alpha.
 func DecodeConfig(r io.Reader) (config image.Config, err error) {
-	header := make([]byte, maxWebpHeaderSize)
-	n, err := r.Read(header)
-	if err != nil && err != io.EOF {
+	header, err := readHeader(r)
+	if err != nil {
 		return
 	}
-	header, err = header[:n], nil
 	width, height, _, err := GetInfo(header)
 	if err != nil {
 		return
@@ -96,6 +86,241 @@ func Decode(r io.Reader) (m image.Image, err error) {
 	return
 }
 
+// DecodeNRGBA reads a WEBP image from r and returns it with straight,
+// unpremultiplied alpha, as libwebp produces it.
+//
+// Unlike premultiplied RGBA, such as the frames returned by
+// AnimationDecoder.Next, where the color of fully transparent pixels is lost,
+// the original RGB values under transparent regions are preserved when the
+// image was encoded with them (see Options.Exact).
+func DecodeNRGBA(r io.Reader) (m *image.NRGBA, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	pix, w, h, err := webpDecodeRGBA(data)
+	if err != nil {
+		return
+	}
+	m = &image.NRGBA{
+		Pix:    pix,
+		Stride: 4 * w,
+		Rect:   image.Rect(0, 0, w, h),
+	}
+	return
+}
+
+// DecoderOptions are the options for decoding WebP images.
+type DecoderOptions struct {
+	// FlattenToBackground composites the decoded frames over the background
+	// color of the animation, see AnimationParams.BackgroundColor, so they
+	// are fully opaque. The alpha of the background color is ignored.
+	// Without it, the frames keep their transparency. Only applies to
+	// NewAnimationDecoderWithOptions.
+	FlattenToBackground bool
+
+	// AutoOrient applies the orientation recorded in the EXIF metadata, if
+	// any, rotating and flipping the image so it displays upright. Only
+	// applies to DecodeWithOptions.
+	AutoOrient bool
+
+	// DitheringStrength is the strength of the dithering applied to the
+	// colors of lossy images, from 0 (off, the default) to 100, which
+	// smooths the banding of gradients. libwebp only dithers the chroma of
+	// images compressed with fine quantizers, typically at high qualities;
+	// other images are decoded unchanged. Only applies to DecodeWithOptions.
+	DitheringStrength int
+
+	// AlphaDitheringStrength is the strength of the smoothing applied to the
+	// alpha plane of lossy images, from 0 (off, the default) to 100, which
+	// reduces the banding of soft shadows and gradients in the alpha. It only
+	// applies to alpha planes quantized by the encoder, see
+	// Options.AlphaQuality; other images are decoded unchanged. Only applies
+	// to DecodeWithOptions.
+	AlphaDitheringStrength int
+}
+
+// DecodeWithOptions reads a WEBP image from r and decodes it with the given
+// options. A nil opt decodes the image like Decode.
+func DecodeWithOptions(r io.Reader, opt *DecoderOptions) (m image.Image, err error) {
+	if opt == nil {
+		opt = &DecoderOptions{}
+	}
+	if opt.DitheringStrength < 0 || opt.DitheringStrength > 100 {
+		return nil, fmt.Errorf("webp: invalid dithering strength %d, must be in range 0 ~ 100", opt.DitheringStrength)
+	}
+	if opt.AlphaDitheringStrength < 0 || opt.AlphaDitheringStrength > 100 {
+		return nil, fmt.Errorf("webp: invalid alpha dithering strength %d, must be in range 0 ~ 100", opt.AlphaDitheringStrength)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	var p *image.RGBA
+	if opt.DitheringStrength > 0 || opt.AlphaDitheringStrength > 0 {
+		pix, w, h, err := webpDecodeRGBAWithOptions(data, opt)
+		if err != nil {
+			return nil, errors.New("webp: DecodeWithOptions, invalid or corrupt data")
+		}
+		p = &image.RGBA{Pix: pix, Stride: 4 * w, Rect: image.Rect(0, 0, w, h)}
+	} else if p, err = DecodeRGBA(data); err != nil {
+		return
+	}
+	if opt.AutoOrient {
+		if exif, err := webpGetEXIF(data); err == nil {
+			p = orientRGBA(p, exifOrientation(exif))
+		}
+	}
+	return p, nil
+}
+
+// DecodeScaled reads a WEBP image from r and decodes it scaled to width x height.
+// If one of the dimensions is zero, it is derived from the other one, preserving
+// the aspect ratio.
+//
+// The image is scaled while it is decoded, so the full-size image is never
+// allocated. This is much faster than decoding then resizing when making
+// thumbnails of large images.
+func DecodeScaled(r io.Reader, width, height int) (m image.Image, err error) {
+	if width < 0 || height < 0 || width == 0 && height == 0 {
+		return nil, fmt.Errorf("webp: DecodeScaled, invalid size %dx%d", width, height)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	w, h, _, err := webpGetInfo(data)
+	if err != nil {
+		return nil, errors.New("webp: DecodeScaled, invalid or corrupt data")
+	}
+
+	switch {
+	case width == 0:
+		width = (h/2 + height*w) / h
+	case height == 0:
+		height = (w/2 + width*h) / w
+	}
+	if width == 0 {
+		width = 1
+	}
+	if height == 0 {
+		height = 1
+	}
+	return DecodeRGBAToSize(data, width, height)
+}
+
+// DecodeCropped reads a WEBP image from r and decodes only the rect region of
+// it. The returned image has the bounds rect, like a SubImage of the full
+// image, but the full-size image is never allocated.
+//
+// Returns an error if rect is empty or not within the image bounds.
+func DecodeCropped(r io.Reader, rect image.Rectangle) (m image.Image, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	w, h, _, err := webpGetInfo(data)
+	if err != nil {
+		return nil, errors.New("webp: DecodeCropped, invalid or corrupt data")
+	}
+	if rect.Empty() || !rect.In(image.Rect(0, 0, w, h)) {
+		return nil, fmt.Errorf("webp: DecodeCropped, invalid rectangle %v for the %dx%d image", rect, w, h)
+	}
+
+	pix, err := webpDecodeRGBACropped(data, rect)
+	if err != nil {
+		return
+	}
+	m = &image.RGBA{
+		Pix:    pix,
+		Stride: 4 * rect.Dx(),
+		Rect:   rect,
+	}
+	return
+}
+
+// DecodeAlpha reads a WEBP image from r and returns only its alpha channel,
+// such as for masking, without allocating a full RGBA image. Images without
+// an alpha channel return a fully opaque mask; their color planes are not
+// decoded, so only their headers are checked.
+func DecodeAlpha(r io.Reader) (m *image.Alpha, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	w, h, hasAlpha, err := webpGetInfo(data)
+	if err != nil {
+		return nil, errors.New("webp: DecodeAlpha, invalid or corrupt data")
+	}
+	if !hasAlpha {
+		m = image.NewAlpha(image.Rect(0, 0, w, h))
+		for i := range m.Pix {
+			m.Pix[i] = 0xff
+		}
+		return
+	}
+
+	pix, w, h, err := webpDecodeAlpha(data)
+	if err != nil {
+		return nil, errors.New("webp: DecodeAlpha, invalid or corrupt data")
+	}
+	m = &image.Alpha{
+		Pix:    pix,
+		Stride: w,
+		Rect:   image.Rect(0, 0, w, h),
+	}
+	return
+}
+
+// DecodePlanarFloat reads a WEBP image from r and returns its RGB channels as
+// a planar (CHW) float32 tensor normalized to [0, 1], as taken by inference
+// pipelines: all the R values, then all the G values, then all the B values,
+// each plane in row-major order. The alpha channel is dropped.
+func DecodePlanarFloat(r io.Reader) (data []float32, width, height int, err error) {
+	return DecodePlanarFloatNormalized(r, [3]float32{0, 0, 0}, [3]float32{1, 1, 1})
+}
+
+// DecodePlanarFloatNormalized is like DecodePlanarFloat, and also normalizes
+// each channel c of the [0, 1] values v to (v - mean[c]) / std[c], such as
+// with the ImageNet mean and standard deviation.
+//
+// Returns an error if a standard deviation is not positive.
+func DecodePlanarFloatNormalized(r io.Reader, mean, std [3]float32) (data []float32, width, height int, err error) {
+	for c := range std {
+		if !(std[c] > 0) {
+			return nil, 0, 0, fmt.Errorf("webp: invalid standard deviation %v, must be positive", std[c])
+		}
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return
+	}
+	pix, width, height, err := webpDecodeRGB(b)
+	if err != nil {
+		return nil, 0, 0, errors.New("webp: DecodePlanarFloat, invalid or corrupt data")
+	}
+
+	// The 8-bit samples only take 256 values per channel, so they are
+	// converted through tables.
+	var table [3][256]float32
+	for c := range table {
+		for v := range table[c] {
+			table[c][v] = (float32(v)/255 - mean[c]) / std[c]
+		}
+	}
+	n := width * height
+	data = make([]float32, 3*n)
+	r0, g0, b0 := data[:n], data[n:2*n], data[2*n:]
+	for i := 0; i < n; i++ {
+		s := pix[3*i : 3*i+3 : 3*i+3]
+		r0[i], g0[i], b0[i] = table[0][s[0]], table[1][s[1]], table[2][s[2]]
+	}
+	return
+}
+
+// The magic matches "RIFF", the 4-byte little-endian file size and "WEBP",
+// so image.Decode and image.DecodeConfig handle WEBP once this package is
+// imported.
 func init() {
-	image.RegisterFormat("webp", "RIFF????WEBPVP8", Decode, DecodeConfig)
+	image.RegisterFormat("webp", "RIFF????WEBP", Decode, DecodeConfig)
 }
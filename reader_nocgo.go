@@ -0,0 +1,45 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !cgo
+// +build !cgo
+
+package webp
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// DecodeConfig returns the color model and dimensions of a WEBP image without
+// decoding the entire image.
+//
+// Without cgo, the RIFF, VP8X, VP8 and VP8L headers are parsed in Go from the
+// first maxWebpHeaderSize bytes of r. The color model is color.RGBAModel, as
+// with cgo.
+func DecodeConfig(r io.Reader) (config image.Config, err error) {
+	header, err := readHeader(r)
+	if err != nil {
+		return
+	}
+	width, height, _, _, err := parseHeader(header)
+	if err != nil {
+		return
+	}
+	config.Width = width
+	config.Height = height
+	config.ColorModel = color.RGBAModel
+	return
+}
+
+// Decoding requires libwebp, only DecodeConfig is available without cgo.
+func decodeWithoutCgo(r io.Reader) (image.Image, error) {
+	return nil, errors.New("webp: decoding requires cgo")
+}
+
+func init() {
+	image.RegisterFormat("webp", "RIFF????WEBP", decodeWithoutCgo, DecodeConfig)
+}
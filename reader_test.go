@@ -5,8 +5,11 @@
 package webp
 
 import (
+	"bytes"
 	"image"
+	"image/color"
 	_ "image/png"
+	"io"
 	"os"
 	"testing"
 )
@@ -69,3 +72,343 @@ func delta(u0, u1 uint32) int64 {
 	}
 	return d
 }
+
+func TestRegisterFormat(t *testing.T) {
+	for _, filename := range []string{"1_webp_ll.webp", "1_webp_a.webp", "video-001.webp", "yellow_rose.lossy-with-alpha.webp"} {
+		data, err := os.ReadFile(testdataDir + filename)
+		tAssertNil(t, err, filename)
+
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		tAssertNil(t, err, filename)
+		tAssertEQ(t, "webp", format, filename)
+
+		m, format, err := image.Decode(bytes.NewReader(data))
+		tAssertNil(t, err, filename)
+		tAssertEQ(t, "webp", format, filename)
+		tAssertEQ(t, image.Rect(0, 0, cfg.Width, cfg.Height), m.Bounds(), filename)
+		tAssert(t, cfg.ColorModel == m.ColorModel(), filename, ": DecodeConfig and Decode disagree on the color model")
+	}
+}
+
+// tOneByteReader returns the data one byte per Read call.
+type tOneByteReader struct {
+	data []byte
+	n    int
+}
+
+func (r *tOneByteReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	p[0], r.data = r.data[0], r.data[1:]
+	r.n++
+	return 1, nil
+}
+
+func TestDecodeConfig(t *testing.T) {
+	for _, v := range []struct {
+		Filename   string
+		Width      int
+		Height     int
+		ColorModel color.Model
+	}{
+		{"1_webp_ll.webp", 400, 301, color.RGBAModel},
+		{"1_webp_a.webp", 400, 301, color.RGBAModel},
+		{"video-001.webp", 150, 103, color.RGBAModel},
+		{"yellow_rose.lossy-with-alpha.webp", 400, 301, color.RGBAModel},
+	} {
+		data, err := os.ReadFile(testdataDir + v.Filename)
+		tAssertNil(t, err, v.Filename)
+
+		r := &tOneByteReader{data: data}
+		cfg, err := DecodeConfig(r)
+		tAssertNil(t, err, v.Filename)
+		tAssertEQ(t, v.Width, cfg.Width, v.Filename)
+		tAssertEQ(t, v.Height, cfg.Height, v.Filename)
+		tAssert(t, cfg.ColorModel == v.ColorModel, v.Filename)
+		tAssert(t, r.n <= maxWebpHeaderSize, v.Filename, ": read too much: ", r.n)
+	}
+}
+
+func TestDecodeNRGBA(t *testing.T) {
+	// Straight alpha pixels, including colored fully transparent ones.
+	src := &image.RGBA{
+		Pix: []byte{
+			255, 0, 0, 0, 0, 255, 0, 128,
+			0, 0, 255, 255, 10, 20, 30, 0,
+		},
+		Stride: 8,
+		Rect:   image.Rect(0, 0, 2, 2),
+	}
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, src, &Options{Lossless: true, Exact: true}))
+
+	m, err := DecodeNRGBA(&buf)
+	tAssertNil(t, err)
+	tAssertEQ(t, src.Rect, m.Rect)
+	tAssertEQ(t, src.Pix, m.Pix)
+	tAssertEQ(t, color.NRGBA{10, 20, 30, 0}, m.NRGBAAt(1, 1))
+
+	_, err = DecodeNRGBA(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WEBP")))
+	tAssert(t, err != nil)
+}
+
+func TestDecodeScaled(t *testing.T) {
+	data := xLoadData("1_webp_ll.webp") // 400x301
+
+	for _, v := range []struct {
+		width, height int
+		want          image.Point
+	}{
+		{100, 50, image.Pt(100, 50)},
+		{200, 0, image.Pt(200, 151)},
+		{0, 30, image.Pt(40, 30)},
+	} {
+		m, err := DecodeScaled(bytes.NewReader(data), v.width, v.height)
+		tAssertNil(t, err, v.width, "x", v.height)
+		tAssertEQ(t, v.want, m.Bounds().Size(), v.width, "x", v.height)
+	}
+
+	for _, size := range [][2]int{{0, 0}, {-1, 10}, {10, -1}} {
+		_, err := DecodeScaled(bytes.NewReader(data), size[0], size[1])
+		tAssert(t, err != nil, "size ", size, " accepted")
+	}
+	_, err := DecodeScaled(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WEBP")), 10, 10)
+	tAssert(t, err != nil)
+}
+
+func TestDecodeCropped(t *testing.T) {
+	data := xLoadData("1_webp_ll.webp") // 400x301
+	full, err := DecodeRGBA(data)
+	tAssertNil(t, err)
+
+	for _, rect := range []image.Rectangle{
+		image.Rect(0, 0, 400, 301),
+		image.Rect(100, 50, 164, 114),
+		image.Rect(399, 300, 400, 301),
+	} {
+		m, err := DecodeCropped(bytes.NewReader(data), rect)
+		tAssertNil(t, err, rect)
+		tAssertEQ(t, rect, m.Bounds())
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if m.At(x, y) != full.At(x, y) {
+					t.Fatalf("%v: pixel (%d, %d) = %v, want %v", rect, x, y, m.At(x, y), full.At(x, y))
+				}
+			}
+		}
+	}
+
+	for _, rect := range []image.Rectangle{
+		image.Rect(10, 10, 10, 20),
+		image.Rect(-1, 0, 10, 10),
+		image.Rect(300, 200, 401, 250),
+	} {
+		_, err := DecodeCropped(bytes.NewReader(data), rect)
+		tAssert(t, err != nil, "rectangle ", rect, " accepted")
+	}
+}
+
+// tExifOrientation returns EXIF data holding the given orientation.
+func tExifOrientation(orientation int, bigEndian bool) []byte {
+	if bigEndian {
+		return []byte{
+			'M', 'M', 0, '*', 0, 0, 0, 8,
+			0, 1, // one entry
+			0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0,
+			0, 0, 0, 0,
+		}
+	}
+	return []byte{
+		'I', 'I', '*', 0, 8, 0, 0, 0,
+		1, 0, // one entry
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, byte(orientation), 0, 0, 0,
+		0, 0, 0, 0,
+	}
+}
+
+func TestDecodeWithOptions_AutoOrient(t *testing.T) {
+	// A 3x2 image, red at the top left and blue at the top right.
+	src := createImage(3, 2, color.RGBA{0, 255, 0, 255})
+	src.Set(0, 0, color.RGBA{255, 0, 0, 255})
+	src.Set(2, 0, color.RGBA{0, 0, 255, 255})
+
+	// Where the top left and top right pixels end up.
+	want := []struct {
+		size              image.Point
+		topLeft, topRight image.Point
+	}{
+		1: {image.Pt(3, 2), image.Pt(0, 0), image.Pt(2, 0)},
+		2: {image.Pt(3, 2), image.Pt(2, 0), image.Pt(0, 0)},
+		3: {image.Pt(3, 2), image.Pt(2, 1), image.Pt(0, 1)},
+		4: {image.Pt(3, 2), image.Pt(0, 1), image.Pt(2, 1)},
+		5: {image.Pt(2, 3), image.Pt(0, 0), image.Pt(0, 2)},
+		6: {image.Pt(2, 3), image.Pt(1, 0), image.Pt(1, 2)},
+		7: {image.Pt(2, 3), image.Pt(1, 2), image.Pt(1, 0)},
+		8: {image.Pt(2, 3), image.Pt(0, 2), image.Pt(0, 0)},
+	}
+	for orientation := 1; orientation <= 8; orientation++ {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, src, &Options{Lossless: true, EXIF: tExifOrientation(orientation, orientation%2 == 0)}))
+		data := buf.Bytes()
+
+		m, err := DecodeWithOptions(bytes.NewReader(data), &DecoderOptions{AutoOrient: true})
+		tAssertNil(t, err, "orientation ", orientation)
+		v := want[orientation]
+		tAssertEQ(t, v.size, m.Bounds().Size(), "orientation ", orientation)
+		tAssertEQ(t, color.RGBA{255, 0, 0, 255}, m.At(v.topLeft.X, v.topLeft.Y), "orientation ", orientation)
+		tAssertEQ(t, color.RGBA{0, 0, 255, 255}, m.At(v.topRight.X, v.topRight.Y), "orientation ", orientation)
+
+		// Without AutoOrient, the image is left as is.
+		m, err = DecodeWithOptions(bytes.NewReader(data), nil)
+		tAssertNil(t, err)
+		tAssertEQ(t, image.Pt(3, 2), m.Bounds().Size())
+		tAssertEQ(t, color.RGBA{255, 0, 0, 255}, m.At(0, 0))
+	}
+
+	// Images without EXIF metadata are left as is.
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, src, &Options{Lossless: true}))
+	m, err := DecodeWithOptions(&buf, &DecoderOptions{AutoOrient: true})
+	tAssertNil(t, err)
+	tAssertEQ(t, image.Pt(3, 2), m.Bounds().Size())
+}
+
+func TestDecodePlanarFloat(t *testing.T) {
+	img := tNoiseImage(5, 3)
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, img, &Options{Lossless: true}))
+
+	data, w, h, err := DecodePlanarFloat(bytes.NewReader(buf.Bytes()))
+	tAssertNil(t, err)
+	tAssert(t, w == 5 && h == 3, "size: ", w, h)
+	tAssertEQ(t, 3*5*3, len(data))
+	for c := 0; c < 3; c++ {
+		for i := 0; i < w*h; i++ {
+			want := float32(img.Pix[4*i+c]) / 255
+			tAssert(t, data[c*w*h+i] == want, "channel ", c, " pixel ", i, ": ", data[c*w*h+i], " != ", want)
+		}
+	}
+
+	mean, std := [3]float32{0.485, 0.456, 0.406}, [3]float32{0.229, 0.224, 0.225}
+	norm, _, _, err := DecodePlanarFloatNormalized(bytes.NewReader(buf.Bytes()), mean, std)
+	tAssertNil(t, err)
+	for i := range norm {
+		c := i / (w * h)
+		want := (data[i] - mean[c]) / std[c]
+		tAssert(t, norm[i] == want, "value ", i, ": ", norm[i], " != ", want)
+	}
+
+	_, _, _, err = DecodePlanarFloatNormalized(bytes.NewReader(buf.Bytes()), mean, [3]float32{1, 0, 1})
+	tAssert(t, err != nil, "zero standard deviation accepted")
+	_, _, _, err = DecodePlanarFloat(bytes.NewReader([]byte("RIFF")))
+	tAssert(t, err != nil, "invalid data accepted")
+}
+
+func TestDecodeAlpha(t *testing.T) {
+	img := tNoiseImage(6, 4)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = uint8(i * 7)
+	}
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, img, &Options{Lossless: true, Exact: true}))
+
+	m, err := DecodeAlpha(bytes.NewReader(buf.Bytes()))
+	tAssertNil(t, err)
+	tAssertEQ(t, image.Rect(0, 0, 6, 4), m.Rect)
+	for i := range m.Pix {
+		tAssertEQ(t, img.Pix[4*i+3], m.Pix[i])
+	}
+
+	// An image without alpha is fully opaque.
+	buf.Reset()
+	tAssertNil(t, Encode(&buf, tNoiseImage(6, 4), nil))
+	m, err = DecodeAlpha(bytes.NewReader(buf.Bytes()))
+	tAssertNil(t, err)
+	tAssertEQ(t, image.Rect(0, 0, 6, 4), m.Rect)
+	for i := range m.Pix {
+		tAssertEQ(t, uint8(0xff), m.Pix[i])
+	}
+
+	_, err = DecodeAlpha(bytes.NewReader([]byte("RIFF")))
+	tAssert(t, err != nil, "invalid data accepted")
+}
+
+// tGradient returns a smooth horizontal gradient from blue to orange, whose
+// chroma bands when compressed.
+func tGradient(width, height int) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x * 255 / (width - 1))
+			m.SetRGBA(x, y, color.RGBA{v, 0x80, 0xff - v, 0xff})
+		}
+	}
+	return m
+}
+
+// tRoughness returns the sum of the squared differences of horizontally
+// adjacent samples of m, which dithering noise increases.
+func tRoughness(m *image.RGBA) (sum int) {
+	for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+		row := m.Pix[m.PixOffset(m.Rect.Min.X, y):m.PixOffset(m.Rect.Max.X, y)]
+		for i := 4; i < len(row); i++ {
+			if i%4 != 3 {
+				d := int(row[i]) - int(row[i-4])
+				sum += d * d
+			}
+		}
+	}
+	return sum
+}
+
+func TestDecodeWithOptions_DitheringStrength(t *testing.T) {
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, tGradient(128, 64), &Options{Quality: 95}))
+	data := buf.Bytes()
+
+	decode := func(strength int) *image.RGBA {
+		m, err := DecodeWithOptions(bytes.NewReader(data), &DecoderOptions{DitheringStrength: strength})
+		tAssertNil(t, err, "strength ", strength)
+		return m.(*image.RGBA)
+	}
+	plain, dithered := decode(0), decode(50)
+	want, err := DecodeRGBA(data)
+	tAssertNil(t, err)
+	tAssertEQ(t, want.Pix, plain.Pix)
+	tAssert(t, tRoughness(dithered) > tRoughness(plain), "dithering has no effect: ", tRoughness(dithered), " <= ", tRoughness(plain))
+
+	_, err = DecodeWithOptions(bytes.NewReader(data), &DecoderOptions{DitheringStrength: 101})
+	tAssert(t, err != nil, "invalid dithering strength accepted")
+}
+
+func TestDecodeWithOptions_AlphaDitheringStrength(t *testing.T) {
+	// A soft shadow, with an alpha gradient quantized to few levels.
+	src := image.NewRGBA(image.Rect(0, 0, 128, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 128; x++ {
+			src.Pix[src.PixOffset(x, y)+3] = uint8(x * 2)
+		}
+	}
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, src, &Options{Quality: 75, AlphaQuality: 10}))
+	data := buf.Bytes()
+
+	decode := func(strength int) *image.RGBA {
+		m, err := DecodeWithOptions(bytes.NewReader(data), &DecoderOptions{AlphaDitheringStrength: strength})
+		tAssertNil(t, err, "strength ", strength)
+		return m.(*image.RGBA)
+	}
+	plain, smoothed := decode(0), decode(100)
+	changed := false
+	for i := 3; i < len(plain.Pix); i += 4 {
+		changed = changed || plain.Pix[i] != smoothed.Pix[i]
+	}
+	tAssert(t, changed, "alpha dithering has no effect")
+
+	_, err := DecodeWithOptions(bytes.NewReader(data), &DecoderOptions{AlphaDitheringStrength: -1})
+	tAssert(t, err != nil, "invalid alpha dithering strength accepted")
+}
@@ -0,0 +1,178 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package webp
+
+import (
+	"errors"
+	"image"
+	"io"
+	"sync"
+)
+
+// streamChunkSize is the size of the chunks read from the input by the
+// incremental decoder.
+const streamChunkSize = 32 << 10
+
+// Decoder decodes a WEBP image incrementally, as its data is read from an
+// io.Reader, with libwebp's incremental decoder.
+//
+// Usage:
+//
+//	dec, err := webp.NewDecoder(conn)
+//	if err != nil {
+//		return err
+//	}
+//	defer dec.Close()
+//
+//	m, err := dec.Decode()
+//
+// While Decode waits for data, Partial can be called from another goroutine
+// to show the rows received so far.
+type Decoder struct {
+	r   io.Reader
+	buf []byte
+
+	mu  sync.Mutex // guards dec, m and err
+	dec *WebPIDecoder
+	m   image.Image
+	err error
+}
+
+// NewDecoder creates a decoder reading the image from r.
+//
+// The returned decoder must be closed with Close() when no longer needed
+// to avoid memory leaks.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	dec, err := webpIDecoderNew()
+	if err != nil {
+		return nil, err
+	}
+	return &Decoder{
+		r:   r,
+		buf: make([]byte, streamChunkSize),
+		dec: dec,
+	}, nil
+}
+
+// Decode reads from r in chunks until the image is complete, decoding the
+// rows as soon as their data is available, and returns the image like
+// Decode, with straight alpha in an *image.RGBA.
+//
+// Decode blocks while r does, without holding the decoder, so Partial stays
+// available. Once Decode has returned, later calls return the same result.
+// Returns io.ErrUnexpectedEOF if r ends before the image is complete.
+func (d *Decoder) Decode() (image.Image, error) {
+	d.mu.Lock()
+	m, err := d.m, d.err
+	d.mu.Unlock()
+	if m != nil || err != nil {
+		return m, err
+	}
+
+	for done := false; !done; {
+		n, rerr := d.r.Read(d.buf)
+		if done, err = d.append(d.buf[:n]); err != nil {
+			return nil, d.fail(err)
+		}
+		switch {
+		case done:
+		case rerr == io.EOF:
+			return nil, d.fail(io.ErrUnexpectedEOF)
+		case rerr != nil:
+			return nil, d.fail(rerr)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dec == nil {
+		return nil, ErrDecoderClosed
+	}
+	pix, _, width, height := webpIDecoderGetRGBA(d.dec)
+	d.m = &image.RGBA{
+		Pix:    pix,
+		Stride: 4 * width,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+	return d.m, nil
+}
+
+// append feeds data to the decoder, and reports whether the image is complete.
+func (d *Decoder) append(data []byte) (done bool, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dec == nil {
+		return false, ErrDecoderClosed
+	}
+	if done, err = webpIDecoderAppend(d.dec, data); err != nil {
+		return false, errors.New("webp: Decoder, invalid or corrupt data")
+	}
+	return done, nil
+}
+
+// fail records err as the result of Decode, and returns it.
+func (d *Decoder) fail(err error) error {
+	d.mu.Lock()
+	d.err = err
+	d.mu.Unlock()
+	return err
+}
+
+// Partial returns the image as decoded so far: the first rows hold the
+// decoded pixels, and the rest are transparent. It returns a nil image and
+// 0 rows until the headers have been read.
+//
+// Partial copies the decoded pixels, so it is meant for progress reporting
+// and progressive display, not to be called after each chunk.
+func (d *Decoder) Partial() (m image.Image, rows int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dec == nil {
+		return nil, 0
+	}
+	pix, rows, width, height := webpIDecoderGetRGBA(d.dec)
+	if pix == nil {
+		return nil, 0
+	}
+	return &image.RGBA{
+		Pix:    pix,
+		Stride: 4 * width,
+		Rect:   image.Rect(0, 0, width, height),
+	}, rows
+}
+
+// Close releases resources used by the Decoder. The image returned by Decode
+// stays valid.
+func (d *Decoder) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dec != nil {
+		webpIDecoderDelete(d.dec)
+		d.dec = nil
+	}
+}
+
+// DecodeStream reads a WEBP image from r and decodes it incrementally, chunk
+// by chunk as the data is read, with libwebp's incremental decoder.
+//
+// Unlike Decode, the file is not first read into a growing Go slice: each
+// chunk is handed to the decoder, which buffers the compressed data it still
+// needs in a single allocation, and decodes the rows as soon as their data
+// is available. This trades some speed for a lower and more predictable peak
+// memory use with large images, and overlaps the decoding with slow reads.
+//
+// The image is returned like Decode, with straight alpha in an *image.RGBA.
+// Returns io.ErrUnexpectedEOF if r ends before the image is complete.
+func DecodeStream(r io.Reader) (m image.Image, err error) {
+	dec, err := NewDecoder(r)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	return dec.Decode()
+}
@@ -0,0 +1,97 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestDecodeStream(t *testing.T) {
+	for _, filename := range []string{
+		"1_webp_a.webp",
+		"1_webp_ll.webp",
+		"blue-purple-pink-large.normal-filter.lossy.webp",
+	} {
+		data := xLoadData(filename)
+		want, err := DecodeRGBA(data)
+		tAssertNil(t, err)
+
+		// Small reads split the chunks at arbitrary places.
+		m, err := DecodeStream(iotest.HalfReader(bytes.NewReader(data)))
+		tAssertNil(t, err)
+		tAssertEQ(t, want.Bounds(), m.Bounds())
+		tAssert(t, bytes.Equal(want.Pix, m.(*image.RGBA).Pix), filename, ": pixels differ")
+
+		_, err = DecodeStream(bytes.NewReader(data[:len(data)/2]))
+		tAssertEQ(t, io.ErrUnexpectedEOF, err)
+	}
+
+	_, err := DecodeStream(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WEBPVP8 garbage")))
+	tAssert(t, err != nil && err != io.ErrUnexpectedEOF, "corrupt data accepted: ", err)
+}
+
+func TestDecoder_closed(t *testing.T) {
+	dec, err := NewDecoder(bytes.NewReader(xLoadData("1_webp_ll.webp")))
+	tAssertNil(t, err)
+	dec.Close()
+	dec.Close()
+	_, err = dec.Decode()
+	tAssert(t, errors.Is(err, ErrDecoderClosed), err)
+}
+
+func TestDecoder_partial(t *testing.T) {
+	data := xLoadData("blue-purple-pink-large.normal-filter.lossy.webp")
+	want, err := DecodeRGBA(data)
+	tAssertNil(t, err)
+
+	// The pipe blocks Decode until the rest of the data is written.
+	pr, pw := io.Pipe()
+	dec, err := NewDecoder(pr)
+	tAssertNil(t, err)
+	defer dec.Close()
+
+	m, rows := dec.Partial()
+	tAssert(t, m == nil && rows == 0, "partial image before any data")
+
+	type result struct {
+		m   image.Image
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		m, err := dec.Decode()
+		done <- result{m, err}
+	}()
+
+	_, err = pw.Write(data[:len(data)/2])
+	tAssertNil(t, err)
+	deadline := time.Now().Add(5 * time.Second)
+	for rows == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		m, rows = dec.Partial()
+	}
+	tAssert(t, rows > 0 && rows < want.Rect.Dy(), "rows decoded from half the data: ", rows)
+	tAssertEQ(t, want.Bounds(), m.Bounds())
+	n := rows * want.Stride
+	tAssert(t, bytes.Equal(want.Pix[:n], m.(*image.RGBA).Pix[:n]), "partial rows differ")
+
+	_, err = pw.Write(data[len(data)/2:])
+	tAssertNil(t, err)
+	tAssertNil(t, pw.Close())
+	res := <-done
+	tAssertNil(t, res.err)
+	tAssert(t, bytes.Equal(want.Pix, res.m.(*image.RGBA).Pix), "pixels differ")
+
+	// The result is kept.
+	m, err = dec.Decode()
+	tAssertNil(t, err)
+	tAssert(t, m == res.m, "second Decode returned a different image")
+}
@@ -0,0 +1,77 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build cgo
+// +build cgo
+
+package webp
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+)
+
+// ToPNG decodes the WebP image data and re-encodes it as PNG, as a fallback
+// for clients without WebP support. The alpha channel is kept, with the
+// colors of transparent pixels preserved for a still image. An animation is
+// converted to its first frame, composited onto the canvas.
+func ToPNG(webpData []byte) ([]byte, error) {
+	m, err := transcodeImage(webpData, true)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ToJPEG decodes the WebP image data and re-encodes it as JPEG with the
+// quality, from 1 to 100, as a fallback for clients without WebP support.
+// JPEG has no alpha channel, so transparent pixels are flattened over white.
+// An animation is converted to its first frame, composited onto the canvas.
+//
+// Returns an error if the quality is out of range or if the data is invalid.
+func ToJPEG(webpData []byte, quality int) ([]byte, error) {
+	if quality < 1 || quality > 100 {
+		return nil, fmt.Errorf("webp: invalid JPEG quality %d, must be in range 1 ~ 100", quality)
+	}
+	m, err := transcodeImage(webpData, false)
+	if err != nil {
+		return nil, err
+	}
+	flattenRGBA(m.(*image.RGBA).Pix, 0xffffffff)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, m, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// transcodeImage decodes the image to transcode from data, a still image or
+// the first frame of an animation, as an *image.RGBA with premultiplied
+// alpha, or an *image.NRGBA with straight alpha for a still image if
+// requested.
+func transcodeImage(data []byte, straight bool) (image.Image, error) {
+	_, _, _, animated, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if animated {
+		return DecodeFirstFrame(bytes.NewReader(data))
+	}
+	m, err := DecodeNRGBA(bytes.NewReader(data))
+	if err != nil || straight {
+		return m, err
+	}
+	rgba := image.NewRGBA(m.Rect)
+	draw.Draw(rgba, rgba.Rect, m, m.Rect.Min, draw.Src)
+	return rgba, nil
+}
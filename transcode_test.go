@@ -0,0 +1,84 @@
+// Copyright 2025 <git@adamkonrad.com>. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestToPNG(t *testing.T) {
+	// Half transparent, with colors under the transparent pixels.
+	m := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for i := 0; i < len(m.Pix); i += 4 {
+		m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3] = 200, 100, 50, uint8(i/4%16*16)
+	}
+	data, err := EncodeRaw(m.Pix, 16, 8, m.Stride, &Options{Lossless: true, Exact: true})
+	tAssertNil(t, err)
+
+	out, err := ToPNG(data)
+	tAssertNil(t, err)
+	p, err := png.Decode(bytes.NewReader(out))
+	tAssertNil(t, err)
+	tAssertEQ(t, m.Pix, p.(*image.NRGBA).Pix)
+
+	// The first frame of an animation.
+	out, err = ToPNG(tEncodeTestAnimation(t))
+	tAssertNil(t, err)
+	p, err = png.Decode(bytes.NewReader(out))
+	tAssertNil(t, err)
+	tAssertEQ(t, image.Rect(0, 0, 32, 24), p.Bounds())
+	r, g, b, _ := p.At(16, 12).RGBA()
+	tAssertEQ(t, [3]uint32{0xffff, 0, 0}, [3]uint32{r, g, b})
+
+	_, err = ToPNG([]byte("garbage"))
+	tAssert(t, err != nil, "invalid data transcoded")
+}
+
+func TestToJPEG(t *testing.T) {
+	transparent := createImage(16, 16, color.RGBA{0, 0, 0, 0})
+	data, err := EncodeLosslessRGBA(transparent)
+	tAssertNil(t, err)
+
+	out, err := ToJPEG(data, 90)
+	tAssertNil(t, err)
+	j, err := jpeg.Decode(bytes.NewReader(out))
+	tAssertNil(t, err)
+	tAssertEQ(t, image.Rect(0, 0, 16, 16), j.Bounds())
+	r, g, b, _ := j.At(8, 8).RGBA()
+	tAssert(t, r>>8 > 250 && g>>8 > 250 && b>>8 > 250, "transparent pixels not flattened over white:", r>>8, g>>8, b>>8)
+
+	// A half transparent color over white, about (227, 177, 152).
+	half := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := 0; i < len(half.Pix); i += 4 {
+		half.Pix[i], half.Pix[i+1], half.Pix[i+2], half.Pix[i+3] = 200, 100, 50, 128
+	}
+	colored, err := EncodeRaw(half.Pix, 16, 16, half.Stride, &Options{Lossless: true, Exact: true})
+	tAssertNil(t, err)
+	out, err = ToJPEG(colored, 100)
+	tAssertNil(t, err)
+	j, err = jpeg.Decode(bytes.NewReader(out))
+	tAssertNil(t, err)
+	r, g, b, _ = j.At(8, 8).RGBA()
+	for i, v := range [][2]uint32{{r >> 8, 227}, {g >> 8, 177}, {b >> 8, 152}} {
+		tAssert(t, v[0]+4 >= v[1] && v[0] <= v[1]+4, "channel", i, "flattened to", v[0], "want about", v[1])
+	}
+
+	out, err = ToJPEG(tEncodeTestAnimation(t), 90)
+	tAssertNil(t, err)
+	j, err = jpeg.Decode(bytes.NewReader(out))
+	tAssertNil(t, err)
+	r, g, b, _ = j.At(16, 12).RGBA()
+	tAssert(t, r>>8 > 240 && g>>8 < 16 && b>>8 < 16, "first frame not red:", r>>8, g>>8, b>>8)
+
+	for _, quality := range []int{0, 101} {
+		_, err = ToJPEG(data, quality)
+		tAssert(t, err != nil, "quality", quality, "accepted")
+	}
+}
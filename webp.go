@@ -2,9 +2,15 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+//go:build cgo
+// +build cgo
+
 package webp
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
 	"strings"
 
@@ -14,12 +20,45 @@ import (
 //go:embed internal
 var _ embed.FS
 
+func GetInfo(data []byte) (width, height int, hasAlpha bool, err error) {
+	return webpGetInfo(data)
+}
+
+// Bitstream formats reported in Features.Format.
 const (
-	maxWebpHeaderSize = 32
+	FormatUndefined = 0 // mixed, as in animations, or not known from the data
+	FormatLossy     = 1 // VP8
+	FormatLossless  = 2 // VP8L
 )
 
-func GetInfo(data []byte) (width, height int, hasAlpha bool, err error) {
-	return webpGetInfo(data)
+// Features are the features of a WebP image, as reported by libwebp from its
+// headers.
+type Features struct {
+	// Width and Height are the dimensions of the image, the canvas size for
+	// an animation.
+	Width, Height int
+
+	// HasAlpha reports whether the image declares an alpha channel.
+	HasAlpha bool
+
+	// HasAnimation reports whether the image is an animation.
+	HasAnimation bool
+
+	// Format is the bitstream format, FormatLossy or FormatLossless, or
+	// FormatUndefined for an animation, whose frames may mix both.
+	Format int
+}
+
+// GetFeatures returns the features of the WebP image data, the cheap probe of
+// WebPGetFeatures for routing images without decoding them, such as telling
+// animations apart.
+//
+// The headers are enough, and the first maxWebpHeaderSize (32) bytes of a
+// file hold them, except for the format of an extended still image with
+// chunks, such as an ICC profile, ahead of its bitstream: it is
+// FormatUndefined until the data reaches the bitstream.
+func GetFeatures(data []byte) (Features, error) {
+	return webpGetFeatures(data)
 }
 
 func DecodeGray(data []byte) (m *image.Gray, err error) {
@@ -48,10 +87,16 @@ func DecodeRGB(data []byte) (m *RGBImage, err error) {
 	return
 }
 
+// DecodeRGBA decodes a WEBP image into an *image.RGBA with a tightly packed
+// stride of 4*width, the counterpart of EncodeRGBA. The pixels are RGBA as
+// libwebp produces them, with straight alpha; use DecodeNRGBA to get them
+// with the matching color model.
+//
+// Returns an error if the data is not a valid WEBP image.
 func DecodeRGBA(data []byte) (m *image.RGBA, err error) {
 	pix, w, h, err := webpDecodeRGBA(data)
 	if err != nil {
-		return
+		return nil, errors.New("webp: DecodeRGBA, invalid or corrupt data")
 	}
 	m = &image.RGBA{
 		Pix:    pix,
@@ -61,6 +106,36 @@ func DecodeRGBA(data []byte) (m *image.RGBA, err error) {
 	return
 }
 
+// DecodeBGRA decodes a WEBP image into pixels in B, G, R, A order with
+// straight alpha and a tightly packed stride of 4*width, the counterpart of
+// EncodeBGRA. libwebp writes them in that order, without a swap in Go.
+//
+// Returns an error if the data is not a valid WEBP image.
+func DecodeBGRA(data []byte) (pix []byte, width, height int, err error) {
+	pix, width, height, err = webpDecodeBGRA(data)
+	if err != nil {
+		return nil, 0, 0, errors.New("webp: DecodeBGRA, invalid or corrupt data")
+	}
+	return
+}
+
+// DecodeInto decodes a WEBP image into dst, without allocating the pixels.
+// The pixels are written with dst's stride, as straight RGBA like DecodeRGBA.
+//
+// Returns an error if the data is not a valid WEBP image, or if the size of
+// the image does not match the bounds of dst, in which case dst is untouched.
+func DecodeInto(dst *image.RGBA, data []byte) (err error) {
+	width, height, _, err := GetInfo(data)
+	if err != nil {
+		return
+	}
+	if width != dst.Rect.Dx() || height != dst.Rect.Dy() {
+		return fmt.Errorf("webp: DecodeInto, image size %dx%d does not match destination %dx%d",
+			width, height, dst.Rect.Dx(), dst.Rect.Dy())
+	}
+	return webpDecodeRGBAInto(data, dst.Pix, dst.Stride)
+}
+
 // DecodeGrayToSize decodes a Gray image scaled to the given dimensions. For
 // large images, the DecodeXXXToSize methods are significantly faster and
 // require less memory compared to decoding a full-size image and then resizing it.
@@ -106,6 +181,9 @@ func DecodeRGBAToSize(data []byte, width, height int) (m *image.RGBA, err error)
 }
 
 func EncodeGray(m image.Image, quality float32) (data []byte, err error) {
+	if err = checkDimensions(m.Bounds()); err != nil {
+		return
+	}
 	p := toGrayImage(m)
 	data, err = webpEncodeGray(p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride, quality)
 	if err != nil {
@@ -115,30 +193,52 @@ func EncodeGray(m image.Image, quality float32) (data []byte, err error) {
 }
 
 func EncodeRGB(m image.Image, quality float32) (data []byte, err error) {
+	if err = checkDimensions(m.Bounds()); err != nil {
+		return
+	}
 	p := NewRGBImageFrom(m)
 	data, err = webpEncodeRGB(p.XPix, p.XRect.Dx(), p.XRect.Dy(), p.XStride, quality)
 	return
 }
 
 func EncodeRGBA(m image.Image, quality float32) (data []byte, err error) {
+	if err = checkDimensions(m.Bounds()); err != nil {
+		return
+	}
 	p := toRGBAImage(m)
 	data, err = webpEncodeRGBA(p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride, quality)
 	return
 }
 
+// EncodeRGBAWithOptions encodes m as RGBA with the given options, which give
+// access to the encoder tuning parameters. A nil opt encodes a lossy image
+// with DefaulQuality.
+func EncodeRGBAWithOptions(m image.Image, opt *Options) (data []byte, err error) {
+	return encodeImage(toRGBAImage(m), opt)
+}
+
 func EncodeLosslessGray(m image.Image) (data []byte, err error) {
+	if err = checkDimensions(m.Bounds()); err != nil {
+		return
+	}
 	p := toGrayImage(m)
 	data, err = webpEncodeLosslessGray(p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride)
 	return
 }
 
 func EncodeLosslessRGB(m image.Image) (data []byte, err error) {
+	if err = checkDimensions(m.Bounds()); err != nil {
+		return
+	}
 	p := NewRGBImageFrom(m)
 	data, err = webpEncodeLosslessRGB(p.XPix, p.XRect.Dx(), p.XRect.Dy(), p.XStride)
 	return
 }
 
 func EncodeLosslessRGBA(m image.Image) (data []byte, err error) {
+	if err = checkDimensions(m.Bounds()); err != nil {
+		return
+	}
 	p := toRGBAImage(m)
 	data, err = webpEncodeLosslessRGBA(0, p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride)
 	return
@@ -147,11 +247,48 @@ func EncodeLosslessRGBA(m image.Image) (data []byte, err error) {
 // EncodeExactLosslessRGBA Encode lossless RGB mode with exact.
 // exact: preserve RGB values in transparent area.
 func EncodeExactLosslessRGBA(m image.Image) (data []byte, err error) {
+	if err = checkDimensions(m.Bounds()); err != nil {
+		return
+	}
 	p := toRGBAImage(m)
 	data, err = webpEncodeLosslessRGBA(1, p.Pix, p.Rect.Dx(), p.Rect.Dy(), p.Stride)
 	return
 }
 
+// RoundTripEqual encodes img losslessly with EncodeExactLosslessRGBA, decodes
+// the output and reports whether the pixels, alpha included, came back
+// identical, to check that lossless really is lossless on some data.
+//
+// When a pixel differs, ok is false and err names the first one, scanning
+// the rows from the top, with its coordinates in img. Otherwise err reports
+// a failure to encode or decode.
+func RoundTripEqual(img *image.RGBA) (ok bool, err error) {
+	data, err := EncodeExactLosslessRGBA(img)
+	if err != nil {
+		return false, err
+	}
+	m, err := DecodeRGBA(data)
+	if err != nil {
+		return false, err
+	}
+
+	b := img.Rect
+	for y := 0; y < b.Dy(); y++ {
+		want := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):][:4*b.Dx()]
+		got := m.Pix[y*m.Stride:][:4*b.Dx()]
+		if bytes.Equal(got, want) {
+			continue
+		}
+		for x := 0; x < b.Dx(); x++ {
+			if !bytes.Equal(got[4*x:4*x+4], want[4*x:4*x+4]) {
+				return false, fmt.Errorf("webp: RoundTripEqual, pixel (%d, %d) is %v after the round trip instead of %v",
+					b.Min.X+x, b.Min.Y+y, got[4*x:4*x+4], want[4*x:4*x+4])
+			}
+		}
+	}
+	return true, nil
+}
+
 // GetMetadata return EXIF/ICCP/XMP format metadata.
 func GetMetadata(data []byte, format string) (metadata []byte, err error) {
 	return webpGetMetadata(data, strings.ToUpper(format))
@@ -5,6 +5,8 @@
 package webp
 
 import (
+	"image"
+	"image/color"
 	"os"
 	"testing"
 )
@@ -47,3 +49,112 @@ var tGetInfoTesterList = []tGetInfoTester{
 		HasAlpha: true,
 	},
 }
+
+func TestDecodeRGBA(t *testing.T) {
+	data, err := os.ReadFile(testdataDir + "1_webp_ll.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := DecodeRGBA(data)
+	tAssertNil(t, err)
+	tAssertEQ(t, 400, m.Rect.Dx())
+	tAssertEQ(t, 301, m.Rect.Dy())
+	tAssertEQ(t, 4*400, m.Stride)
+	tAssertEQ(t, 4*400*301, len(m.Pix))
+
+	for _, data := range [][]byte{nil, []byte("RIFF\x00\x00\x00\x00WEBP"), data[:len(data)/2]} {
+		m, err := DecodeRGBA(data)
+		tAssert(t, err != nil && m == nil, "corrupt data decoded")
+	}
+}
+
+func TestDecodeInto(t *testing.T) {
+	data, err := os.ReadFile(testdataDir + "1_webp_ll.webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := DecodeRGBA(data)
+	tAssertNil(t, err)
+
+	dst := image.NewRGBA(image.Rect(0, 0, 400, 301))
+	tAssertNil(t, DecodeInto(dst, data))
+	tAssertEQ(t, want.Pix, dst.Pix)
+
+	// A sub-image of a larger buffer is decoded with its stride.
+	big := image.NewRGBA(image.Rect(0, 0, 410, 310))
+	sub := big.SubImage(image.Rect(5, 6, 405, 307)).(*image.RGBA)
+	tAssertNil(t, DecodeInto(sub, data))
+	for _, p := range []image.Point{{0, 0}, {399, 300}, {123, 45}} {
+		tAssertEQ(t, want.At(p.X, p.Y), sub.At(p.X+5, p.Y+6))
+	}
+	tAssertEQ(t, color.RGBA{}, big.RGBAAt(0, 0))
+
+	tAssert(t, DecodeInto(image.NewRGBA(image.Rect(0, 0, 400, 300)), data) != nil, "size mismatch accepted")
+	tAssert(t, DecodeInto(dst, data[:40]) != nil, "corrupt data accepted")
+}
+
+func TestRoundTripEqual(t *testing.T) {
+	img := tNoiseImage(40, 30)
+	// Transparent pixels keep their colors, and partial alpha is exact.
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = uint8(i % 7 * 40)
+	}
+	ok, err := RoundTripEqual(img)
+	tAssertNil(t, err)
+	tAssert(t, ok, "lossless round trip differs")
+
+	sub := img.SubImage(image.Rect(5, 3, 25, 21)).(*image.RGBA)
+	ok, err = RoundTripEqual(sub)
+	tAssertNil(t, err)
+	tAssert(t, ok, "lossless round trip of a sub-image differs")
+
+	ok, err = RoundTripEqual(image.NewRGBA(image.Rect(0, 0, 0, 0)))
+	tAssert(t, !ok && err != nil, "empty image accepted")
+}
+
+func TestGetFeatures(t *testing.T) {
+	opaque := tNoiseImage(24, 16)
+	alpha := createImage(24, 16, color.RGBA{0, 0, 128, 128})
+	for _, tt := range []struct {
+		m        image.Image
+		opt      Options
+		hasAlpha bool
+		format   int
+	}{
+		{opaque, Options{Quality: 75}, false, FormatLossy},
+		{opaque, Options{Lossless: true}, false, FormatLossless},
+		{alpha, Options{Quality: 75}, true, FormatLossy},
+		{alpha, Options{Lossless: true}, true, FormatLossless},
+	} {
+		data, err := EncodeStill(tt.m, &tt.opt)
+		tAssertNil(t, err)
+		f, err := GetFeatures(data)
+		tAssertNil(t, err)
+		tAssertEQ(t, Features{Width: 24, Height: 16, HasAlpha: tt.hasAlpha, Format: tt.format}, f)
+
+		// The headers are enough, but the bitstream of a lossy image with
+		// alpha follows the ALPH chunk.
+		f, err = GetFeatures(data[:maxWebpHeaderSize])
+		tAssertNil(t, err)
+		tAssertEQ(t, tt.hasAlpha, f.HasAlpha)
+		if tt.hasAlpha && !tt.opt.Lossless {
+			tAssertEQ(t, FormatUndefined, f.Format)
+		} else {
+			tAssertEQ(t, tt.format, f.Format)
+		}
+	}
+
+	data, err := EncodeAnimationToBytes([]Frame{
+		{Image: opaque, Duration: 100},
+		{Image: opaque, Duration: 100, Lossless: true},
+	}, AnimationParams{})
+	tAssertNil(t, err)
+	f, err := GetFeatures(data[:maxWebpHeaderSize])
+	tAssertNil(t, err)
+	tAssertEQ(t, Features{Width: 24, Height: 16, HasAnimation: true, Format: FormatUndefined}, f)
+
+	_, err = GetFeatures([]byte("garbage"))
+	tAssert(t, err != nil, "invalid data probed")
+	_, err = GetFeatures(nil)
+	tAssert(t, err != nil, "empty data probed")
+}
@@ -8,20 +8,214 @@
 package webp
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"io"
 	"os"
 	"reflect"
+	"runtime"
 )
 
 const DefaulQuality = 90
 
+const (
+	// DefaultMethod is the quality/speed trade-off used when Options.Method
+	// is zero.
+	DefaultMethod = 4
+
+	// MethodFastest selects the fastest method 0 for Options.Method, which
+	// cannot be expressed as zero since zero selects DefaultMethod.
+	MethodFastest = -1
+)
+
+// LosslessLevelFastest selects the fastest lossless level 0 for
+// Options.LosslessLevel, which cannot be expressed as zero since zero keeps
+// the default lossless settings.
+const LosslessLevelFastest = -1
+
+// Alpha plane filters for Options.AlphaFiltering.
+const (
+	AlphaFilterNone = -1
+	AlphaFilterFast = 1 // the default
+	AlphaFilterBest = 2
+)
+
+// Preprocessing filters for Options.Preprocessing, which can be combined.
+const (
+	PreprocessNone          = -1
+	PreprocessSegmentSmooth = 1 // smooth the segment map
+	PreprocessDithering     = 2 // pseudo-random dithering of the RGB to YUV conversion
+)
+
+// Image content hints for Options.ImageHint.
+const (
+	ImageHintDefault = 0
+	ImageHintPicture = 1 // digital picture, like portrait, inner shot
+	ImageHintPhoto   = 2 // outdoor photograph, with natural lighting
+	ImageHintGraph   = 3 // discrete tone image (graph, map-tile etc)
+)
+
+// Encoder presets for Options.Preset and EncodeWithPreset, the libwebp
+// presets tuning the noise shaping, the deblocking filter, the segments and
+// the preprocessing for a kind of content.
+const (
+	PresetDefault = 0 // the default tuning
+	PresetPicture = 1 // digital picture, like portrait, inner shot
+	PresetPhoto   = 2 // outdoor photograph, with natural lighting
+	PresetDrawing = 3 // hand or line drawing, with high-contrast details
+	PresetIcon    = 4 // small-sized colorful images, like icons
+	PresetText    = 5 // text-like images, like screenshots of documents
+)
+
+// Distortion metrics for EncodeWithMetrics, as computed by libwebp.
+const (
+	MetricPSNR = 0 // peak signal-to-noise ratio
+	MetricSSIM = 1 // structural similarity
+	MetricLSIM = 2 // local similarity, SSIM over small windows
+)
+
 // Options are the encoding parameters.
 type Options struct {
-	Lossless bool
-	Quality  float32 // 0 ~ 100
-	Exact    bool    // Preserve RGB values in transparent area.
+	Lossless   bool
+	Quality    float32 // 0 ~ 100
+	Exact      bool    // Preserve RGB values in transparent area, lossless and lossy.
+	ICCProfile []byte  // Raw ICC profile, written as the ICCP chunk if not empty.
+	EXIF       []byte  // Raw EXIF data, written as the EXIF chunk if not empty.
+	XMP        []byte  // Raw XMP packet, written as the XMP chunk if not empty.
+
+	// Method is the quality/speed trade-off, from 1 (fast) to 6 (slower but
+	// smaller), and MethodFastest for the fastest method 0. Zero selects
+	// DefaultMethod. Lower methods suit real-time thumbnailing, while 6
+	// typically shaves a few percent off the size at several times the CPU
+	// cost of the default, which pays off in offline batch encoding.
+	Method int
+
+	// SNSStrength is the spatial noise shaping strength, from 1 to 100, which
+	// moves bits from flat areas to detailed ones. Zero selects the default
+	// of 50, and a negative value turns noise shaping off. Lossy only.
+	SNSStrength int
+
+	// FilterStrength is the deblocking filter strength, from 1 (weakest) to
+	// 100 (strongest). Zero selects the default of 60, and a negative value
+	// turns the filter off. Lossy only.
+	FilterStrength int
+
+	// FilterSharpness is the deblocking filter sharpness, from 0 (sharpest,
+	// the default) to 7 (least sharp). Zero keeps the sharpness of the
+	// Preset tuning. Lossy only.
+	FilterSharpness int
+
+	// AlphaQuality is the quality of the alpha plane, from 1 (smallest) to
+	// 100 (lossless alpha). Zero selects the default of 100. Lossy only.
+	AlphaQuality int
+
+	// AlphaUncompressed stores the alpha plane without compression. Lossy only.
+	AlphaUncompressed bool
+
+	// AlphaFiltering is the predictive filter applied to the alpha plane
+	// before compression: AlphaFilterNone, AlphaFilterFast or AlphaFilterBest.
+	// Zero selects AlphaFilterFast. Lossy only.
+	AlphaFiltering int
+
+	// LosslessLevel is the lossless compression level, from 1 (fast) to 9
+	// (slower but denser), and LosslessLevelFastest for the fastest level 0.
+	// It sets both the method and the compression effort, taking precedence
+	// over Method. Zero keeps the default effort of 100 with Method.
+	// Lossless only.
+	LosslessLevel int
+
+	// NearLossless enables near-lossless encoding, which adjusts the pixel
+	// values slightly to compress better while staying visually lossless.
+	// It ranges from 1 (strongest, levels below 20 are all the same) to 100,
+	// which is identical to pure lossless. Zero disables it. Setting it
+	// implies Lossless.
+	NearLossless int
+
+	// SharpYUV uses the slower but more accurate RGB to YUV conversion, which
+	// reduces color bleeding around sharp edges, such as colored text.
+	// Lossy only, and 4:2:0 *image.YCbCr images, which are already YUV, are
+	// not affected.
+	SharpYUV bool
+
+	// ColorSpace tags the output with the color space its RGB values are in,
+	// ColorSpaceSRGB or ColorSpaceBT709, by embedding a matching ICC profile,
+	// so that color-managed decoders display it consistently. The pixels are
+	// stored as given, only their interpretation is recorded. Combined with
+	// SharpYUV, which keeps the colors of the lossy conversion to YUV closest
+	// to the source, it is the recommended way to encode BT.709 content.
+	// Zero leaves the output untagged. It cannot be combined with ICCProfile.
+	ColorSpace int
+
+	// Segments is the number of segments, from 1 to 4, that the macroblocks
+	// are grouped into to share quantization and filtering parameters. Zero
+	// selects the default of 4. Fewer segments save a few header bytes, at
+	// the cost of adapting less to the content. Lossy only.
+	Segments int
+
+	// Preprocessing is the preprocessing applied before the compression,
+	// PreprocessSegmentSmooth, PreprocessDithering or both combined with |.
+	// Dithering reduces the banding of smooth gradients in grainy photos,
+	// it only applies to images converted from RGB without SharpYUV. Zero
+	// keeps the default, which is none unless set by PresetPhoto, and
+	// PreprocessNone turns it off. Lossy only.
+	Preprocessing int
+
+	// ImageHint describes the content of the image: ImageHintPicture,
+	// ImageHintPhoto or ImageHintGraph. It is passed to libwebp as is and
+	// does not change the tuning of the other options; use Preset for that.
+	// ImageHintGraph suits flat UI graphics and screenshots. Zero gives no
+	// hint.
+	ImageHint int
+
+	// Preset is the libwebp preset the tuning starts from, PresetPicture,
+	// PresetPhoto, PresetDrawing, PresetIcon or PresetText, which the other
+	// options override when set. Zero selects the default preset. Lossy
+	// only.
+	Preset int
+
+	// TargetSize is the desired size of the output in bytes. When set, the
+	// encoder searches for the quality that reaches it over several analysis
+	// passes, and Quality only seeds the search. The search works from size
+	// estimates, so the output may end up slightly off the target. Lossy only.
+	TargetSize int
+
+	// TargetPSNR is the desired distortion in dB, searched for like
+	// TargetSize, which it takes precedence over. Lossy only.
+	TargetPSNR float32
+
+	// MultiThread lets libwebp use a second thread within the encoding of
+	// the image. In lossy mode, the analysis pass is split between the
+	// threads and the alpha plane is compressed alongside the colors; in
+	// lossless mode, the candidate compressions tried by the higher methods
+	// and lossless levels are split between the threads, so low levels gain
+	// nothing. The output is the same, and only large images gain enough to
+	// make up for starting the thread. To encode many images, encoding them
+	// in parallel scales better.
+	MultiThread bool
+
+	// VerifyOutput parses the headers of the encoded image and checks its size
+	// before returning it, so a malformed bitstream is reported as an error
+	// instead of being written out. The check does not decode the pixels, its
+	// cost is small compared to the encoding.
+	VerifyOutput bool
+
+	// MaxOutputBytes is the maximum size of the encoded image in bytes,
+	// metadata included. The encoding stops with ErrOutputTooLarge as soon
+	// as the output grows past it, before more memory is allocated for it,
+	// which bounds the memory of a service encoding untrusted images. Zero
+	// sets no limit beyond the 4GB of the RIFF container.
+	MaxOutputBytes int
+
+	// KeepAlpha keeps an alpha channel in the output of a fully opaque image.
+	// The encoder checks the alpha values and leaves out the alpha plane of
+	// opaque images, along with the VP8X chunk it requires in lossy mode,
+	// which saves bytes, but some pipelines expect the alpha channel of an
+	// RGBA source to be declared whatever its values. The plane added is
+	// compressed to a few bytes. It is not applied by Encoder.
+	KeepAlpha bool
 }
 
 type colorModeler interface {
@@ -39,59 +233,444 @@ func Save(name string, m image.Image, opt *Options) (err error) {
 }
 
 // Encode writes the image m to w in WEBP format.
+//
+// WebP has no grayscale mode, so an *image.Gray is stored in color. In lossy
+// mode, its pixels are taken as the luma plane as is, with neutral chroma
+// planes that cost next to nothing. In lossless mode, the three equal
+// channels are decorrelated by the subtract-green transform.
+//
+// A nil opt encodes a lossy image with DefaulQuality. Unless metadata has to
+// be attached, the encoded bytes are written to w straight from the encoder's
+// output buffer, without an intermediate copy.
 func Encode(w io.Writer, m image.Image, opt *Options) (err error) {
 	return encode(w, m, opt)
 }
 
 func encode(w io.Writer, m image.Image, opt *Options) (err error) {
-	var output []byte
-	if opt != nil && opt.Lossless {
+	return encodeImageFunc(m, opt, func(output []byte) error {
+		_, err := w.Write(output)
+		return err
+	})
+}
+
+// EncodeStill encodes the still image m with the given options and returns
+// the WEBP data. A nil opt encodes a lossy image with DefaulQuality.
+//
+// The image is stored in the simple format, a bare VP8 or VP8L chunk, unless
+// the extended (VP8X) format is required: for a lossy image with transparent
+// pixels, whose alpha is stored in a separate ALPH chunk, and for an image
+// with metadata. The ICC profile, EXIF and XMP metadata of opt are assembled
+// with the image into the extended container, like the metadata of an
+// animation. Fully opaque images are stored without alpha, whatever their
+// color model, and lossless images keep their alpha in the VP8L bitstream.
+func EncodeStill(m image.Image, opt *Options) (data []byte, err error) {
+	return encodeImage(m, opt)
+}
+
+// EncodeRaw encodes the RGBA pixels of a width x height image stored in pix
+// with the given stride, such as a GPU readback, without wrapping them in an
+// image.Image. The pixels are 4 bytes each, R, G, B and A with straight
+// alpha, and are passed to libwebp without being copied. A nil opt encodes a
+// lossy image with DefaulQuality.
+//
+// Returns an error if the size is invalid or if pix is too short for it.
+func EncodeRaw(pix []byte, width, height, stride int, opt *Options) (data []byte, err error) {
+	m, err := rawRGBA(pix, width, height, stride)
+	if err != nil {
+		return nil, err
+	}
+	return encodeImage(m, opt)
+}
+
+// EncodeBGRA is like EncodeRaw for pixels in B, G, R, A order, as captured
+// from Windows GDI and DXGI surfaces. They are passed to libwebp as is,
+// without swapping the channels in Go.
+func EncodeBGRA(pix []byte, width, height, stride int, opt *Options) (data []byte, err error) {
+	if err = checkRaw(pix, width, height, stride); err != nil {
+		return
+	}
+	if opt == nil {
+		opt = &Options{Quality: DefaulQuality}
+	}
+	b := image.Rect(0, 0, width, height)
+	if err = checkDimensions(b); err != nil {
+		return
+	}
+	config, err := webpConfigCreate(opt)
+	if err != nil {
+		return
+	}
+
+	buf, err := webpEncodeWithConfigBuffer(&config, channelsBGRA, pix, width, height, stride, opt.MaxOutputBytes)
+	if err != nil {
+		return
+	}
+	if buf, err = finishBuffer(buf, b, opt); err != nil {
+		return
+	}
+	defer buf.free()
+
+	data = make([]byte, len(buf.data))
+	copy(data, buf.data)
+	return
+}
+
+// checkRaw checks that pix holds a width x height image of 4 bytes per pixel
+// with the given stride. The last row may be shorter than the stride.
+func checkRaw(pix []byte, width, height, stride int) error {
+	if width <= 0 || height <= 0 || stride < 4*width {
+		return fmt.Errorf("webp: invalid size %dx%d with stride %d", width, height, stride)
+	}
+	if len(pix) < (height-1)*stride+4*width {
+		return fmt.Errorf("webp: %d bytes of pixels are too short for %dx%d with stride %d", len(pix), width, height, stride)
+	}
+	return nil
+}
+
+// rawRGBA returns an *image.RGBA sharing the pixels of pix, after checking
+// them with checkRaw.
+func rawRGBA(pix []byte, width, height, stride int) (*image.RGBA, error) {
+	if err := checkRaw(pix, width, height, stride); err != nil {
+		return nil, err
+	}
+	return &image.RGBA{
+		Pix:    pix,
+		Stride: stride,
+		Rect:   image.Rect(0, 0, width, height),
+	}, nil
+}
+
+// EncodeWithPreset encodes the image m as a lossy image with the given
+// quality, starting from the libwebp preset for its kind of content, see
+// Options.Preset. PresetIcon, for instance, suits small colorful icons better
+// than the default tuning.
+//
+// Returns an error if the preset or the quality is invalid.
+func EncodeWithPreset(m image.Image, preset int, quality float32) (data []byte, err error) {
+	return encodeImage(m, &Options{Quality: quality, Preset: preset})
+}
+
+// EncodeWithMetrics encodes the image m like Encode, then decodes the output
+// and returns the distortion introduced against m, measured with the metric
+// MetricPSNR, MetricSSIM or MetricLSIM over the RGBA channels. The distortion
+// is in dB for every metric, higher meaning closer to m, and is capped at 99
+// for identical images. It allows searching for the lowest quality that meets
+// a threshold, at the cost of a decode and a measurement per call.
+//
+// Returns an error if the metric is invalid or if the image cannot be encoded.
+func EncodeWithMetrics(m image.Image, opt *Options, metric int) (data []byte, distortion float64, err error) {
+	if metric < MetricPSNR || metric > MetricLSIM {
+		return nil, 0, fmt.Errorf("webp: invalid metric %d", metric)
+	}
+	if data, err = encodeImage(m, opt); err != nil {
+		return nil, 0, err
+	}
+	pix, width, height, err := webpDecodeRGBA(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Compare with the pixels given to the encoder, as converted by adjustImage.
+	src := toRGBAImage(adjustImage(m))
+	distortion, err = webpPictureDistortion(src.Pix, src.Stride, pix, 4*width, width, height, metric)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, distortion, nil
+}
+
+// EncodeTargetSize encodes the image m as a lossy image of about targetBytes
+// bytes, letting libwebp search for the quality that fits instead of encoding
+// repeatedly at different qualities. The size actually achieved is the
+// length of the returned data; see Options.TargetSize.
+func EncodeTargetSize(m image.Image, targetBytes int) (data []byte, err error) {
+	if targetBytes <= 0 {
+		return nil, fmt.Errorf("webp: invalid target size %d", targetBytes)
+	}
+	return encodeImage(m, &Options{Quality: DefaulQuality, TargetSize: targetBytes})
+}
+
+// EncodedSize encodes the image m with the given options, like Encode, and
+// returns the size of the output in bytes. The output is released as soon as
+// it is measured, without being copied to the Go heap. A nil opt encodes a
+// lossy image with DefaulQuality.
+func EncodedSize(m image.Image, opt *Options) (n int, err error) {
+	err = encodeImageFunc(m, opt, func(output []byte) error {
+		n = len(output)
+		return nil
+	})
+	return
+}
+
+// EncodedBuffer holds an encoded WEBP image in memory allocated by libwebp,
+// outside of the Go heap, so large outputs are not copied into a Go slice.
+//
+// The buffer owns that memory until Free is called. As a safety net, the memory
+// is also released when the EncodedBuffer is garbage collected, so keep it
+// reachable while the slice returned by Bytes is in use.
+type EncodedBuffer struct {
+	buf *webpBuffer
+}
+
+// EncodeBuffer encodes the image m with the given options, like Encode, and
+// returns the output without copying it out of the encoder's memory.
+// A nil opt encodes a lossy image with DefaulQuality.
+func EncodeBuffer(m image.Image, opt *Options) (*EncodedBuffer, error) {
+	buf, err := encodeImageBuffer(m, opt)
+	if err != nil {
+		return nil, err
+	}
+	b := &EncodedBuffer{buf: buf}
+	runtime.SetFinalizer(b, (*EncodedBuffer).Free)
+	return b, nil
+}
+
+// Bytes returns the encoded image. The slice refers to the memory owned by
+// the buffer: it must not be modified, and it is only valid until Free is
+// called. Copy it to keep the data longer.
+func (b *EncodedBuffer) Bytes() []byte {
+	return b.buf.data
+}
+
+// Len returns the size of the encoded image in bytes.
+func (b *EncodedBuffer) Len() int {
+	return len(b.buf.data)
+}
+
+// WriteTo writes the encoded image to w, straight from the buffer's memory.
+// It implements io.WriterTo.
+func (b *EncodedBuffer) WriteTo(w io.Writer) (n int64, err error) {
+	m, err := w.Write(b.buf.data)
+	runtime.KeepAlive(b)
+	return int64(m), err
+}
+
+// Free releases the memory of the buffer. Calling Free more than once is safe.
+func (b *EncodedBuffer) Free() {
+	b.buf.free()
+	runtime.SetFinalizer(b, nil)
+}
+
+// encodeImage encodes the image m with the given options. A nil opt encodes
+// a lossy image with DefaulQuality.
+func encodeImage(m image.Image, opt *Options) (output []byte, err error) {
+	err = encodeImageFunc(m, opt, func(data []byte) error {
+		output = make([]byte, len(data))
+		copy(output, data)
+		return nil
+	})
+	return
+}
+
+// encodeImageFunc encodes the image m like encodeImage, and calls fn with the
+// output. The output is only valid during the call to fn.
+func encodeImageFunc(m image.Image, opt *Options, fn func(output []byte) error) (err error) {
+	buf, err := encodeImageBuffer(m, opt)
+	if err != nil {
+		return
+	}
+	defer buf.free()
+
+	return fn(buf.data)
+}
+
+// encodeImageBuffer encodes the image m like encodeImage, into a buffer the
+// caller must free.
+func encodeImageBuffer(m image.Image, opt *Options) (buf *webpBuffer, err error) {
+	if opt == nil {
+		opt = &Options{Quality: DefaulQuality}
+	}
+	if err = checkDimensions(m.Bounds()); err != nil {
+		return
+	}
+	config, err := webpConfigCreate(opt)
+	if err != nil {
+		return
+	}
+
+	if p, ok := m.(*image.YCbCr); ok && !opt.Lossless && opt.NearLossless == 0 && canImportYCbCr(p) {
+		// Feed the planes to libwebp as is, instead of converting to RGB
+		// and letting libwebp convert back to YUV.
+		buf, err = webpEncodeYCbCrWithConfigBuffer(&config, p, opt.MaxOutputBytes)
+	} else if p, ok := m.(*image.Gray); ok && !opt.Lossless && opt.NearLossless == 0 && !p.Rect.Empty() {
+		// Gray is the luma plane as is, with neutral chroma planes that
+		// cost next to nothing, instead of three identical RGB channels.
+		buf, err = webpEncodeYCbCrWithConfigBuffer(&config, grayYCbCr(p), opt.MaxOutputBytes)
+	} else {
+		var channels, width, height, stride int
+		var pix []byte
 		switch m := adjustImage(m).(type) {
 		case *image.Gray:
-			if output, err = EncodeLosslessGray(m); err != nil {
-				return
-			}
+			channels, pix, stride = 1, m.Pix, m.Stride
+			width, height = m.Rect.Dx(), m.Rect.Dy()
 		case *RGBImage:
-			if output, err = EncodeLosslessRGB(m); err != nil {
-				return
-			}
+			channels, pix, stride = 3, m.XPix, m.XStride
+			width, height = m.XRect.Dx(), m.XRect.Dy()
 		case *image.RGBA:
-			if opt.Exact {
-				output, err = EncodeExactLosslessRGBA(m)
-			} else {
-				output, err = EncodeLosslessRGBA(m)
-			}
-			if err != nil {
-				return
-			}
+			channels, pix, stride = 4, m.Pix, m.Stride
+			width, height = m.Rect.Dx(), m.Rect.Dy()
 		default:
 			panic("image/webp: Encode, unreachable!")
 		}
-	} else {
-		quality := float32(DefaulQuality)
-		if opt != nil {
-			quality = opt.Quality
+		buf, err = webpEncodeWithConfigBuffer(&config, channels, pix, width, height, stride, opt.MaxOutputBytes)
+	}
+	if err != nil {
+		return
+	}
+	return finishBuffer(buf, m.Bounds(), opt)
+}
+
+// finishBuffer adds the metadata of opt to the image of bounds b encoded in
+// buf, and verifies it if requested. buf is freed on failure.
+func finishBuffer(buf *webpBuffer, b image.Rectangle, opt *Options) (_ *webpBuffer, err error) {
+	if opt.KeepAlpha {
+		output, err := opaqueAlpha(buf.data)
+		buf.free()
+		if err != nil {
+			return nil, err
+		}
+		buf = &webpBuffer{data: output}
+	}
+	if len(opt.ICCProfile) > 0 || opt.ColorSpace != ColorSpaceDefault || len(opt.EXIF) > 0 || len(opt.XMP) > 0 {
+		if buf, err = setMetadata(buf, opt); err != nil {
+			return nil, err
 		}
+	}
+	if opt.MaxOutputBytes > 0 && len(buf.data) > opt.MaxOutputBytes {
+		buf.free()
+		return nil, ErrOutputTooLarge
+	}
+	if opt.VerifyOutput {
+		if err = verifyOutput(buf.data, b.Dx(), b.Dy()); err != nil {
+			buf.free()
+			return nil, err
+		}
+	}
+	return buf, nil
+}
 
-		switch m := adjustImage(m).(type) {
-		case *image.Gray:
-			if output, err = EncodeGray(m, quality); err != nil {
-				return
-			}
-		case *RGBImage:
-			if output, err = EncodeRGB(m, quality); err != nil {
-				return
-			}
-		case *image.RGBA:
-			if output, err = EncodeRGBA(m, quality); err != nil {
-				return
-			}
-		default:
-			panic("image/webp: Encode, unreachable!")
+// opaqueAlpha returns a copy of the still image data, declaring an alpha
+// channel if it has none: fully opaque, the alpha is only a flag in the
+// header of a lossless image, while a lossy image gets an ALPH chunk, and
+// the VP8X chunk it requires.
+func opaqueAlpha(data []byte) ([]byte, error) {
+	width, height, _, _, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	switch string(data[12:16]) {
+	case "VP8L":
+		output := append([]byte(nil), data...)
+		output[20+4] |= 0x10 // the alpha_is_used bit, after the 28 bits of the size
+		return output, nil
+	case "VP8 ":
+	default:
+		return append([]byte(nil), data...), nil // an alpha plane would need a VP8X chunk
+	}
+
+	// The ALPH chunk holds the plane compressed as the image stream of a
+	// lossless image, in the green channel, without its 5-byte header.
+	plane := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range plane.Pix {
+		plane.Pix[i] = 0xff
+	}
+	lossless, err := encodeImage(plane, &Options{Lossless: true})
+	if err != nil {
+		return nil, err
+	}
+	if len(lossless) < 25 || string(lossless[12:16]) != "VP8L" {
+		return nil, errors.New("webp: failed to encode the alpha plane")
+	}
+	stream := lossless[25 : 20+binary.LittleEndian.Uint32(lossless[16:])]
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = FlagAlpha
+	putLE24(vp8x[4:], width-1)
+	putLE24(vp8x[7:], height-1)
+	output := []byte("RIFF\x00\x00\x00\x00WEBP")
+	output = appendChunk(output, "VP8X", vp8x)
+	output = appendChunk(output, "ALPH", append([]byte{1}, stream...)) // lossless compression, no filter
+	output = append(output, data[12:]...)
+	binary.LittleEndian.PutUint32(output[4:], uint32(len(output)-8))
+	return output, nil
+}
+
+// appendChunk appends a RIFF chunk with the payload to b, padded to an even
+// size.
+func appendChunk(b []byte, fourcc string, payload []byte) []byte {
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(payload)))
+	b = append(append(append(b, fourcc...), size[:]...), payload...)
+	if len(payload)%2 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// setMetadata returns the image encoded in buf with the metadata of opt
+// added, and frees buf.
+func setMetadata(buf *webpBuffer, opt *Options) (_ *webpBuffer, err error) {
+	defer buf.free()
+
+	output := buf.data
+	iccp := opt.ICCProfile
+	if len(iccp) == 0 {
+		iccp = iccProfile(opt.ColorSpace)
+	}
+	if len(iccp) > 0 {
+		if output, err = webpSetICCP(output, iccp); err != nil {
+			return nil, err
 		}
 	}
-	_, err = w.Write(output)
-	return
+	if len(opt.EXIF) > 0 {
+		if output, err = webpSetEXIF(output, opt.EXIF); err != nil {
+			return nil, err
+		}
+	}
+	if len(opt.XMP) > 0 {
+		if output, err = webpSetXMP(output, opt.XMP); err != nil {
+			return nil, err
+		}
+	}
+	return &webpBuffer{data: output}, nil
+}
+
+// verifyOutput checks that data holds a well-formed WebP image of the given
+// size.
+func verifyOutput(data []byte, width, height int) error {
+	w, h, _, err := webpGetInfo(data)
+	if err != nil || w != width || h != height {
+		return errors.New("webp: encoder produced an invalid bitstream")
+	}
+	return nil
+}
+
+// canImportYCbCr reports whether the lossy encoder can take the planes of m
+// directly. Only 4:2:0 subsampling is supported, with the chroma samples
+// aligned to the image origin.
+func canImportYCbCr(m *image.YCbCr) bool {
+	return m.SubsampleRatio == image.YCbCrSubsampleRatio420 &&
+		m.Rect.Min.X%2 == 0 && m.Rect.Min.Y%2 == 0 &&
+		!m.Rect.Empty()
+}
+
+// grayYCbCr returns a 4:2:0 *image.YCbCr sharing the pixels of m as its luma
+// plane, with neutral chroma. The chroma planes are a single row, repeated
+// with a zero stride.
+func grayYCbCr(m *image.Gray) *image.YCbCr {
+	width, height := m.Rect.Dx(), m.Rect.Dy()
+	chroma := make([]byte, (width+1)/2)
+	for i := range chroma {
+		chroma[i] = 0x80
+	}
+	return &image.YCbCr{
+		Y:              m.Pix[m.PixOffset(m.Rect.Min.X, m.Rect.Min.Y):],
+		Cb:             chroma,
+		Cr:             chroma,
+		YStride:        m.Stride,
+		CStride:        0,
+		SubsampleRatio: image.YCbCrSubsampleRatio420,
+		Rect:           image.Rect(0, 0, width, height),
+	}
 }
 
 func adjustImage(m image.Image) image.Image {
@@ -170,6 +749,35 @@ func toRGBAImage(m image.Image) *image.RGBA {
 	}
 	b := m.Bounds()
 	rgba := image.NewRGBA(b)
+	if m, ok := m.(*image.Paletted); ok {
+		// Convert the palette once, instead of every pixel.
+		var palette [256][4]uint8
+		for i, c := range m.Palette {
+			if i == len(palette) {
+				break
+			}
+			r, g, b, a := c.RGBA()
+			palette[i] = [4]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)}
+		}
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			src := m.Pix[m.PixOffset(b.Min.X, y):][:b.Dx()]
+			dst := rgba.Pix[rgba.PixOffset(b.Min.X, y):]
+			for i, v := range src {
+				copy(dst[4*i:4*i+4], palette[v][:])
+			}
+		}
+		return rgba
+	}
+	if m, ok := m.(*image.Gray); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			src := m.Pix[m.PixOffset(b.Min.X, y):][:b.Dx()]
+			dst := rgba.Pix[rgba.PixOffset(b.Min.X, y):]
+			for i, v := range src {
+				dst[4*i+0], dst[4*i+1], dst[4*i+2], dst[4*i+3] = v, v, v, 0xff
+			}
+		}
+		return rgba
+	}
 	dstColorRGBA64 := &color.RGBA64{}
 	dstColor := color.Color(dstColorRGBA64)
 	for y := b.Min.Y; y < b.Max.Y; y++ {
@@ -6,7 +6,14 @@ package webp
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
 	_ "image/png"
+	"io"
+	"reflect"
 	"testing"
 )
 
@@ -104,3 +111,895 @@ func TestEncode(t *testing.T) {
 		}
 	}
 }
+
+func TestEncode_iccProfile(t *testing.T) {
+	img, err := loadImage("video-001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := []byte("fake ICC profile data")
+
+	for _, lossless := range []bool{false, true} {
+		buf := new(bytes.Buffer)
+		err = Encode(buf, img, &Options{Lossless: lossless, Quality: 90, ICCProfile: profile})
+		tAssertNil(t, err)
+
+		metadata, err := GetMetadata(buf.Bytes(), "ICCP")
+		tAssertNil(t, err)
+		tAssertEQ(t, profile, metadata)
+
+		m, err := Decode(bytes.NewReader(buf.Bytes()))
+		tAssertNil(t, err)
+		tAssertEQ(t, img.Bounds(), m.Bounds())
+	}
+}
+
+func TestEncode_xmp(t *testing.T) {
+	img, err := loadImage("video-001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`)
+	profile := []byte("fake ICC profile data")
+
+	buf := new(bytes.Buffer)
+	err = Encode(buf, img, &Options{Quality: 90, ICCProfile: profile, XMP: xmp})
+	tAssertNil(t, err)
+
+	metadata, err := GetMetadata(buf.Bytes(), "XMP")
+	tAssertNil(t, err)
+	tAssertEQ(t, xmp, metadata)
+
+	metadata, err = GetMetadata(buf.Bytes(), "ICCP")
+	tAssertNil(t, err)
+	tAssertEQ(t, profile, metadata)
+}
+
+func TestEncode_method(t *testing.T) {
+	img := tNoiseImage(128, 128)
+
+	sizes := make(map[int]int)
+	for _, method := range []int{MethodFastest, 0, DefaultMethod, 6} {
+		buf := new(bytes.Buffer)
+		tAssertNil(t, Encode(buf, img, &Options{Quality: 75, Method: method}), "method: ", method)
+		sizes[method] = buf.Len()
+
+		m, err := Decode(buf)
+		tAssertNil(t, err, "method: ", method)
+		tAssertEQ(t, img.Bounds(), m.Bounds())
+	}
+	tAssertEQ(t, sizes[DefaultMethod], sizes[0], "zero should select DefaultMethod")
+	tAssert(t, sizes[MethodFastest] != sizes[6], "method has no effect")
+
+	for _, method := range []int{-2, 7} {
+		err := Encode(new(bytes.Buffer), img, &Options{Quality: 75, Method: method})
+		tAssert(t, err != nil, "method: ", method)
+	}
+}
+
+type tErrWriter struct{}
+
+func (tErrWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestEncode_writer(t *testing.T) {
+	img, err := loadImage("video-001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	tAssertNil(t, Encode(buf, img, nil))
+	data, err := EncodeRGB(img, DefaulQuality)
+	tAssertNil(t, err)
+	tAssertEQ(t, data, buf.Bytes())
+
+	err = Encode(tErrWriter{}, img, nil)
+	tAssert(t, err != nil && err.Error() == "write failed", err)
+}
+
+func TestEncode_ycbcr(t *testing.T) {
+	img, err := loadImage("video-001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jpg bytes.Buffer
+	tAssertNil(t, jpeg.Encode(&jpg, img, &jpeg.Options{Quality: 95}))
+	m, err := jpeg.Decode(&jpg)
+	tAssertNil(t, err)
+	ycbcr, ok := m.(*image.YCbCr)
+	tAssert(t, ok && ycbcr.SubsampleRatio == image.YCbCrSubsampleRatio420, "unexpected JPEG image")
+
+	encodeDelta := func(m image.Image) int {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, m, &Options{Quality: 90}))
+		m1, err := Decode(&buf)
+		tAssertNil(t, err)
+		return averageDelta(ycbcr, m1)
+	}
+
+	// The planes are passed to libwebp directly, which must not shift the
+	// colors compared to converting to RGB first.
+	direct := encodeDelta(ycbcr)
+	converted := encodeDelta(struct{ image.Image }{ycbcr})
+	tAssert(t, direct <= converted+1, "direct delta ", direct, " > converted delta ", converted)
+	tAssert(t, direct <= 5, "direct delta too high: ", direct)
+
+	// Odd origins and other subsampling ratios take the RGB path.
+	sub := ycbcr.SubImage(image.Rect(1, 1, 101, 81)).(*image.YCbCr)
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, sub, &Options{Quality: 90}))
+	m444 := image.NewYCbCr(image.Rect(0, 0, 16, 16), image.YCbCrSubsampleRatio444)
+	buf.Reset()
+	tAssertNil(t, Encode(&buf, m444, &Options{Quality: 90}))
+}
+
+func TestEncodeRGBAWithOptions(t *testing.T) {
+	img := tNoiseImage(64, 64)
+
+	encode := func(opt *Options) []byte {
+		data, err := EncodeRGBAWithOptions(img, opt)
+		tAssertNil(t, err)
+		return data
+	}
+
+	base := encode(&Options{Quality: 75})
+	for _, opt := range []*Options{
+		{Quality: 75, SNSStrength: 100},
+		{Quality: 75, SNSStrength: -1},
+		{Quality: 75, FilterStrength: 100},
+		{Quality: 75, FilterStrength: -1},
+		{Quality: 75, FilterSharpness: 7},
+	} {
+		tAssert(t, !bytes.Equal(base, encode(opt)), "option has no effect: ", *opt)
+	}
+	tAssertEQ(t, base, encode(&Options{Quality: 75, SNSStrength: 50, FilterStrength: 60}))
+
+	for _, opt := range []*Options{
+		{Quality: 75, SNSStrength: 101},
+		{Quality: 75, FilterStrength: 101},
+		{Quality: 75, FilterSharpness: -1},
+		{Quality: 75, FilterSharpness: 8},
+	} {
+		_, err := EncodeRGBAWithOptions(img, opt)
+		tAssert(t, err != nil, "invalid option accepted: ", *opt)
+	}
+
+	m, err := Decode(bytes.NewReader(encode(nil)))
+	tAssertNil(t, err)
+	tAssertEQ(t, img.Rect, m.Bounds())
+}
+
+func TestEncode_alpha(t *testing.T) {
+	// A noisy image with a soft, grainy alpha gradient.
+	img := tNoiseImage(64, 64)
+	grain := tNoiseImage(64, 64)
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			a := uint8(x + y + int(grain.Pix[grain.PixOffset(x, y)]/4))
+			i := img.PixOffset(x, y)
+			for c := 0; c < 3; c++ {
+				img.Pix[i+c] = uint8(uint32(img.Pix[i+c]) * uint32(a) / 255)
+			}
+			img.Pix[i+3] = a
+		}
+	}
+
+	encode := func(opt *Options) []byte {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, img, opt))
+		return buf.Bytes()
+	}
+
+	var last int
+	for _, quality := range []int{5, 50, 100} {
+		size := len(encode(&Options{Quality: 75, AlphaQuality: quality}))
+		tAssert(t, size > last, "alpha quality ", quality, ": ", size, " <= ", last)
+		last = size
+	}
+	tAssertEQ(t, encode(&Options{Quality: 75}), encode(&Options{Quality: 75, AlphaQuality: 100}))
+
+	base := encode(&Options{Quality: 75})
+	tAssert(t, len(encode(&Options{Quality: 75, AlphaUncompressed: true})) > len(base), "uncompressed alpha not larger")
+	for _, filter := range []int{AlphaFilterNone, AlphaFilterBest} {
+		// The alpha plane is lossless by default, whichever the filter.
+		m, err := DecodeNRGBA(bytes.NewReader(encode(&Options{Quality: 75, AlphaFiltering: filter})))
+		tAssertNil(t, err)
+		for i := 3; i < len(img.Pix); i += 4 {
+			if img.Pix[i] != m.Pix[i] {
+				t.Fatalf("alpha filter %d: alpha %d at %d, want %d", filter, m.Pix[i], i/4, img.Pix[i])
+			}
+		}
+	}
+	tAssertEQ(t, base, encode(&Options{Quality: 75, AlphaFiltering: AlphaFilterFast}))
+
+	for _, opt := range []*Options{
+		{Quality: 75, AlphaQuality: -1},
+		{Quality: 75, AlphaQuality: 101},
+		{Quality: 75, AlphaFiltering: -2},
+		{Quality: 75, AlphaFiltering: 3},
+	} {
+		tAssert(t, Encode(&bytes.Buffer{}, img, opt) != nil, "invalid option accepted: ", *opt)
+	}
+}
+
+func TestEncodeStill(t *testing.T) {
+	img, err := loadImage("video-001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := &Options{
+		Quality:    90,
+		ICCProfile: []byte("fake ICC profile data"),
+		EXIF:       []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00"),
+		XMP:        []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`),
+	}
+
+	data, err := EncodeStill(img, opt)
+	tAssertNil(t, err)
+	tAssertEQ(t, []byte("VP8X"), data[12:16])
+	for format, want := range map[string][]byte{"ICCP": opt.ICCProfile, "EXIF": opt.EXIF, "XMP": opt.XMP} {
+		metadata, err := GetMetadata(data, format)
+		tAssertNil(t, err, format)
+		tAssertEQ(t, want, metadata, format)
+	}
+
+	m, err := Decode(bytes.NewReader(data))
+	tAssertNil(t, err)
+	tAssertEQ(t, img.Bounds(), m.Bounds())
+
+	// Without metadata the output is the same as Encode.
+	data, err = EncodeStill(img, nil)
+	tAssertNil(t, err)
+	var buf bytes.Buffer
+	tAssertNil(t, Encode(&buf, img, nil))
+	tAssertEQ(t, buf.Bytes(), data)
+}
+
+func TestEncodeBuffer(t *testing.T) {
+	img, err := loadImage("video-001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opt := range []*Options{nil, {Lossless: true}, {Quality: 75, XMP: []byte("<xmp/>")}} {
+		var want bytes.Buffer
+		tAssertNil(t, Encode(&want, img, opt))
+
+		b, err := EncodeBuffer(img, opt)
+		tAssertNil(t, err)
+		tAssertEQ(t, want.Len(), b.Len())
+		tAssertEQ(t, want.Bytes(), b.Bytes())
+
+		var buf bytes.Buffer
+		n, err := b.WriteTo(&buf)
+		tAssertNil(t, err)
+		tAssertEQ(t, int64(want.Len()), n)
+		tAssertEQ(t, want.Bytes(), buf.Bytes())
+
+		b.Free()
+		b.Free()
+		tAssertEQ(t, 0, b.Len())
+	}
+
+	_, err = EncodeBuffer(img, &Options{Quality: -1})
+	tAssert(t, err != nil)
+}
+
+func TestEncode_losslessLevel(t *testing.T) {
+	img, err := loadImage("gopher-doc.8bpp.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(opt *Options) *bytes.Buffer {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, img, opt))
+		return &buf
+	}
+
+	fast := encode(&Options{Lossless: true, LosslessLevel: LosslessLevelFastest})
+	dense := encode(&Options{Lossless: true, LosslessLevel: 9})
+	tAssert(t, dense.Len() < fast.Len(), "level 9 should be smaller: ", dense.Len(), " >= ", fast.Len())
+
+	m, err := Decode(fast)
+	tAssertNil(t, err)
+	tAssertEQ(t, 0, averageDelta(img, m))
+
+	for _, level := range []int{-2, 10} {
+		tAssert(t, Encode(&bytes.Buffer{}, img, &Options{Lossless: true, LosslessLevel: level}) != nil, "level ", level)
+	}
+}
+
+func TestEncode_nearLossless(t *testing.T) {
+	img, err := loadImage("video-001.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(opt *Options) []byte {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, img, opt))
+		return buf.Bytes()
+	}
+
+	lossless := encode(&Options{Lossless: true})
+	near := encode(&Options{NearLossless: 60})
+	tAssert(t, len(near) < len(lossless), "near lossless should be smaller: ", len(near), " >= ", len(lossless))
+	tAssert(t, bytes.Contains(near[:40], []byte("VP8L")), "near lossless is not lossless encoded")
+	tAssertEQ(t, lossless, encode(&Options{NearLossless: 100}))
+
+	m, err := Decode(bytes.NewReader(near))
+	tAssertNil(t, err)
+	if got := averageDelta(img, m); got > 2 {
+		t.Fatalf("average delta too high; got %d, want <= 2", got)
+	}
+
+	for _, level := range []int{-1, 101} {
+		tAssert(t, Encode(&bytes.Buffer{}, img, &Options{NearLossless: level}) != nil, "level ", level)
+	}
+}
+
+func TestEncode_sharpYUV(t *testing.T) {
+	// Red "text" strokes on white.
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for y := 4; y < 60; y += 6 {
+		for x := 3; x < 61; x++ {
+			if (x/3+y)%4 != 0 {
+				i := img.PixOffset(x, y)
+				img.Pix[i+1], img.Pix[i+2] = 0, 0
+				img.Pix[i+img.Stride+1], img.Pix[i+img.Stride+2] = 0, 0
+			}
+		}
+	}
+
+	// The total error, as averageDelta is too coarse for the difference.
+	delta := func(opt *Options) int {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, img, opt))
+		m, err := DecodeRGBA(buf.Bytes())
+		tAssertNil(t, err)
+		var sum int
+		for i, v := range m.Pix {
+			if d := int(v) - int(img.Pix[i]); d < 0 {
+				sum -= d
+			} else {
+				sum += d
+			}
+		}
+		return sum
+	}
+
+	plain := delta(&Options{Quality: 90})
+	sharp := delta(&Options{Quality: 90, SharpYUV: true})
+	tAssert(t, sharp < plain, "sharp YUV should reduce the error: ", sharp, " >= ", plain)
+}
+
+func TestEncode_exactLossy(t *testing.T) {
+	// A gradient hidden under fully transparent pixels.
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			i := img.PixOffset(x, y)
+			img.Pix[i+0] = uint8(x * 4)
+			img.Pix[i+1] = uint8(y * 4)
+			img.Pix[i+2] = 128
+		}
+	}
+
+	// The average RGB error under the transparent pixels.
+	delta := func(opt *Options) int {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, img, opt))
+		m, err := DecodeNRGBA(&buf)
+		tAssertNil(t, err)
+		var sum int
+		for i, v := range m.Pix {
+			if i%4 == 3 {
+				tAssertEQ(t, uint8(0), v)
+				continue
+			}
+			if d := int(v) - int(img.Pix[i]); d < 0 {
+				sum -= d
+			} else {
+				sum += d
+			}
+		}
+		return sum / (64 * 64 * 3)
+	}
+
+	exact := delta(&Options{Quality: 100, Exact: true})
+	tAssert(t, exact <= 2, "RGB under transparent pixels changed: ", exact)
+	tAssert(t, delta(&Options{Quality: 100}) > exact, "RGB preserved without Exact")
+}
+
+func TestEncode_verifyOutput(t *testing.T) {
+	img := tNoiseImage(64, 48)
+	for _, opt := range []*Options{
+		{Quality: 75, VerifyOutput: true},
+		{Lossless: true, VerifyOutput: true},
+		{Quality: 75, EXIF: []byte("exif"), VerifyOutput: true},
+	} {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, img, opt))
+	}
+
+	enc, err := NewEncoder(&Options{Quality: 75, VerifyOutput: true})
+	tAssertNil(t, err)
+	defer enc.Close()
+	_, err = enc.EncodeInto(make([]byte, 1<<16), image.NewRGBA(image.Rect(0, 0, 64, 48)))
+	tAssertNil(t, err)
+
+	data, err := EncodeStill(img, &Options{Quality: 75})
+	tAssertNil(t, err)
+	tAssertNil(t, verifyOutput(data, 64, 48))
+	tAssert(t, verifyOutput(data, 64, 64) != nil, "wrong size accepted")
+	tAssert(t, verifyOutput(data[:20], 64, 48) != nil, "truncated data accepted")
+}
+
+func TestEncode_gray(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 65, 47))
+	for y := 0; y < 47; y++ {
+		for x := 0; x < 65; x++ {
+			gray.Pix[gray.PixOffset(x, y)] = uint8(x*3 + y)
+		}
+	}
+
+	// The fast RGBA conversion matches the generic one, sub-images included.
+	for _, m := range []*image.Gray{gray, gray.SubImage(image.Rect(3, 5, 40, 30)).(*image.Gray)} {
+		rgba := toRGBAImage(m)
+		tAssertEQ(t, m.Bounds(), rgba.Bounds())
+		for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+			for x := m.Rect.Min.X; x < m.Rect.Max.X; x++ {
+				r, g, b, a := m.At(x, y).RGBA()
+				tAssertEQ(t, [4]uint32{r, g, b, a}, func() [4]uint32 {
+					r, g, b, a := rgba.At(x, y).RGBA()
+					return [4]uint32{r, g, b, a}
+				}())
+			}
+		}
+	}
+
+	for _, opt := range []*Options{{Quality: 90}, {Lossless: true}} {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, gray, opt))
+		m, err := DecodeRGBA(buf.Bytes())
+		tAssertNil(t, err)
+		tAssertEQ(t, gray.Bounds(), m.Bounds())
+
+		for y := 0; y < 47; y++ {
+			for x := 0; x < 65; x++ {
+				v := int(gray.Pix[gray.PixOffset(x, y)])
+				i := m.PixOffset(x, y)
+				for c := 0; c < 3; c++ {
+					d := int(m.Pix[i+c]) - v
+					if opt.Lossless {
+						tAssertEQ(t, 0, d)
+					} else {
+						tAssert(t, d >= -8 && d <= 8, "pixel ", x, ",", y, " off by ", d)
+					}
+				}
+				// Neutral chroma decodes to gray, up to rounding.
+				for c := 1; c < 3; c++ {
+					d := int(m.Pix[i+c]) - int(m.Pix[i])
+					tAssert(t, d >= -1 && d <= 1, "pixel ", x, ",", y, " tinted by ", d)
+				}
+			}
+		}
+	}
+}
+
+func TestEncode_imageHint(t *testing.T) {
+	// Flat bars with sharp edges, like a dashboard chart.
+	img := image.NewRGBA(image.Rect(0, 0, 96, 64))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	for bar := 0; bar < 8; bar++ {
+		for y := 64 - 6*(bar+2); y < 64; y++ {
+			for x := 12*bar + 2; x < 12*bar+10; x++ {
+				i := img.PixOffset(x, y)
+				img.Pix[i+0], img.Pix[i+1] = uint8(30*bar), 0x40
+			}
+		}
+	}
+
+	encode := func(opt *Options) []byte {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, img, opt))
+		return buf.Bytes()
+	}
+	// The hint leaves the lossy tuning alone, that is the job of Preset.
+	plain := encode(&Options{Quality: 75})
+	for _, hint := range []int{ImageHintPicture, ImageHintPhoto, ImageHintGraph} {
+		data := encode(&Options{Quality: 75, ImageHint: hint})
+		tAssert(t, bytes.Equal(data, plain), "image hint changes the preset: ", hint)
+	}
+	graph := encode(&Options{Lossless: true, ImageHint: ImageHintGraph})
+	m, err := DecodeRGBA(graph)
+	tAssertNil(t, err)
+	tAssert(t, bytes.Equal(m.Pix, img.Pix), "lossless image changed")
+
+	var buf bytes.Buffer
+	tAssert(t, Encode(&buf, img, &Options{ImageHint: 4}) != nil, "invalid image hint accepted")
+}
+
+func TestEncodeTargetSize(t *testing.T) {
+	img := tNoiseImage(256, 256)
+	full, err := EncodeStill(img, &Options{Quality: DefaulQuality})
+	tAssertNil(t, err)
+
+	target := len(full) / 4
+	data, err := EncodeTargetSize(img, target)
+	tAssertNil(t, err)
+	tAssert(t, len(data) < len(full)/2, "target size ", target, " not approached: ", len(data))
+	tAssert(t, len(data) > target/2 && len(data) < target*3/2, "target size ", target, " missed: ", len(data))
+	_, err = DecodeRGBA(data)
+	tAssertNil(t, err)
+
+	_, err = EncodeTargetSize(img, 0)
+	tAssert(t, err != nil, "zero target size accepted")
+}
+
+func TestEncode_multiThread(t *testing.T) {
+	img := tNoiseImage(256, 192)
+	for _, opt := range []Options{{Quality: 75}, {Lossless: true, LosslessLevel: 9}} {
+		single, err := EncodeStill(img, &opt)
+		tAssertNil(t, err)
+		opt.MultiThread = true
+		multi, err := EncodeStill(img, &opt)
+		tAssertNil(t, err)
+		tAssert(t, bytes.Equal(single, multi), "multi-threaded output differs, lossless: ", opt.Lossless)
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	img := tNoiseImage(64, 48)
+	for _, opt := range []*Options{nil, {Quality: 50}, {Lossless: true}, {Quality: 75, EXIF: []byte("exif")}} {
+		data, err := EncodeStill(img, opt)
+		tAssertNil(t, err)
+		n, err := EncodedSize(img, opt)
+		tAssertNil(t, err)
+		tAssertEQ(t, len(data), n)
+	}
+
+	_, err := EncodedSize(img, &Options{Quality: 101})
+	tAssert(t, err != nil, "invalid quality accepted")
+}
+
+func TestEncode_preprocessing(t *testing.T) {
+	// A smooth gradient, prone to banding.
+	img := image.NewRGBA(image.Rect(0, 0, 128, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 128; x++ {
+			i := img.PixOffset(x, y)
+			img.Pix[i+0], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = uint8(x/2), uint8(x/2+y/4), 0x60, 0xff
+		}
+	}
+
+	encode := func(opt *Options) []byte {
+		data, err := EncodeStill(img, opt)
+		tAssertNil(t, err)
+		return data
+	}
+	plain := encode(&Options{Quality: 50})
+	tAssert(t, !bytes.Equal(plain, encode(&Options{Quality: 50, Preprocessing: PreprocessDithering})), "dithering has no effect")
+	tAssert(t, !bytes.Equal(plain, encode(&Options{Quality: 50, Segments: 1})), "segments have no effect")
+	tAssert(t, bytes.Equal(plain, encode(&Options{Quality: 50, Preprocessing: PreprocessNone})), "no preprocessing differs from the default")
+
+	for _, opt := range []*Options{{Segments: 5}, {Segments: -1}, {Preprocessing: 4}, {Preprocessing: -2}} {
+		_, err := EncodeStill(img, opt)
+		tAssert(t, err != nil, "invalid options accepted: ", opt)
+	}
+}
+
+func TestEncodeStill_format(t *testing.T) {
+	opaque := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 0xff
+	}
+	transparent := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+
+	for _, tt := range []struct {
+		m     image.Image
+		opt   *Options
+		chunk string
+	}{
+		{opaque, &Options{Quality: 75}, "VP8 "},
+		{opaque, &Options{Lossless: true}, "VP8L"},
+		{transparent, &Options{Quality: 75}, "VP8X"},
+		{transparent, &Options{Lossless: true}, "VP8L"},
+		{opaque, &Options{Quality: 75, EXIF: []byte("exif")}, "VP8X"},
+	} {
+		data, err := EncodeStill(tt.m, tt.opt)
+		tAssertNil(t, err)
+		tAssertEQ(t, tt.chunk, string(data[12:16]), "options: ", tt.opt)
+	}
+}
+
+func TestEncodeRaw(t *testing.T) {
+	img := tNoiseImage(30, 20)
+
+	// A padded stride, as GPU readbacks often have.
+	stride := 4*30 + 8
+	pix := make([]byte, stride*20)
+	for y := 0; y < 20; y++ {
+		copy(pix[y*stride:], img.Pix[y*img.Stride:(y+1)*img.Stride])
+	}
+	data, err := EncodeRaw(pix, 30, 20, stride, &Options{Lossless: true})
+	tAssertNil(t, err)
+	m, err := DecodeRGBA(data)
+	tAssertNil(t, err)
+	tAssertEQ(t, img.Pix, m.Pix)
+
+	// The last row needs no padding.
+	_, err = EncodeRaw(pix[:19*stride+4*30], 30, 20, stride, nil)
+	tAssertNil(t, err)
+
+	for _, tt := range []struct{ n, width, height, stride int }{
+		{len(pix), 30, 20, 4*30 - 1},
+		{len(pix), 0, 20, stride},
+		{len(pix), 30, -1, stride},
+		{19*stride + 4*30 - 1, 30, 20, stride},
+	} {
+		_, err := EncodeRaw(pix[:tt.n], tt.width, tt.height, tt.stride, nil)
+		tAssert(t, err != nil, "invalid buffer accepted: ", tt)
+	}
+}
+
+func TestEncodeBGRA(t *testing.T) {
+	img := tNoiseImage(30, 20)
+	bgra := make([]byte, len(img.Pix))
+	for i := 0; i < len(bgra); i += 4 {
+		bgra[i+0], bgra[i+1], bgra[i+2], bgra[i+3] = img.Pix[i+2], img.Pix[i+1], img.Pix[i+0], img.Pix[i+3]
+	}
+
+	data, err := EncodeBGRA(bgra, 30, 20, 4*30, &Options{Lossless: true})
+	tAssertNil(t, err)
+	m, err := DecodeRGBA(data)
+	tAssertNil(t, err)
+	tAssertEQ(t, img.Pix, m.Pix)
+
+	pix, width, height, err := DecodeBGRA(data)
+	tAssertNil(t, err)
+	tAssert(t, width == 30 && height == 20, "size: ", width, height)
+	tAssertEQ(t, bgra, pix)
+
+	_, err = EncodeBGRA(bgra[:len(bgra)-1], 30, 20, 4*30, nil)
+	tAssert(t, err != nil, "short buffer accepted")
+	_, _, _, err = DecodeBGRA([]byte("RIFF"))
+	tAssert(t, err != nil, "invalid data accepted")
+}
+
+func TestEncodeWithPreset(t *testing.T) {
+	img := tNoiseImage(64, 64)
+
+	sizes := make(map[int]int)
+	for preset := PresetDefault; preset <= PresetText; preset++ {
+		data, err := EncodeWithPreset(img, preset, 75)
+		tAssertNil(t, err)
+		_, err = DecodeRGBA(data)
+		tAssertNil(t, err)
+		sizes[preset] = len(data)
+	}
+	tAssert(t, sizes[PresetIcon] != sizes[PresetPhoto], "preset has no effect: ", sizes)
+
+	// The image hint does not replace the preset.
+	withHint, err := encodeImage(img, &Options{Quality: 75, Preset: PresetIcon, ImageHint: ImageHintPhoto})
+	tAssertNil(t, err)
+	tAssert(t, len(withHint) == sizes[PresetIcon], "image hint overrides the preset")
+
+	_, err = EncodeWithPreset(img, PresetText+1, 75)
+	tAssert(t, err != nil, "invalid preset accepted")
+	_, err = EncodeWithPreset(img, -1, 75)
+	tAssert(t, err != nil, "invalid preset accepted")
+	_, err = EncodeWithPreset(img, PresetIcon, 101)
+	tAssert(t, err != nil, "invalid quality accepted")
+}
+
+func TestEncodeWithMetrics(t *testing.T) {
+	img, err := loadImage("video-001.png")
+	tAssertNil(t, err)
+
+	for _, metric := range []int{MetricPSNR, MetricSSIM, MetricLSIM} {
+		_, low, err := EncodeWithMetrics(img, &Options{Quality: 10}, metric)
+		tAssertNil(t, err)
+		data, high, err := EncodeWithMetrics(img, &Options{Quality: 90}, metric)
+		tAssertNil(t, err)
+		_, err = DecodeRGBA(data)
+		tAssertNil(t, err)
+		tAssert(t, low > 0 && low < high, "metric ", metric, ": ", low, " >= ", high)
+
+		_, exact, err := EncodeWithMetrics(img, &Options{Lossless: true}, metric)
+		tAssertNil(t, err)
+		tAssert(t, exact >= high, "metric ", metric, ": lossless ", exact, " < ", high)
+	}
+
+	// A semi-transparent image encoded exactly is identical to its source.
+	m := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			m.SetNRGBA(x, y, color.NRGBA{uint8(8 * x), uint8(8 * y), 200, uint8(4*x + 2*y + 10)})
+		}
+	}
+	for _, metric := range []int{MetricPSNR, MetricSSIM} {
+		_, exact, err := EncodeWithMetrics(m, &Options{Lossless: true, Exact: true}, metric)
+		tAssertNil(t, err)
+		tAssert(t, exact >= 99, "metric ", metric, ": exact lossless ", exact, " < 99")
+	}
+
+	_, _, err = EncodeWithMetrics(img, nil, MetricLSIM+1)
+	tAssert(t, err != nil, "invalid metric accepted")
+}
+
+func TestEncode_ColorSpace(t *testing.T) {
+	// A horizontal ramp in BT.709 colors, from black to a saturated orange.
+	m := image.NewRGBA(image.Rect(0, 0, 64, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 64; x++ {
+			m.SetRGBA(x, y, color.RGBA{uint8(4 * x), uint8(2 * x), uint8(x / 2), 0xff})
+		}
+	}
+	data, err := EncodeRGBA(m, 90)
+	tAssertNil(t, err)
+	_, err = GetMetadata(data, "ICCP")
+	tAssert(t, err != nil, "untagged image has an ICC profile")
+
+	for _, cs := range []struct {
+		colorSpace int
+		desc       string
+	}{
+		{ColorSpaceSRGB, "sRGB"},
+		{ColorSpaceBT709, "ITU-R BT.709"},
+	} {
+		var buf bytes.Buffer
+		tAssertNil(t, Encode(&buf, m, &Options{Quality: 90, SharpYUV: true, ColorSpace: cs.colorSpace}))
+		data := buf.Bytes()
+		info, err := Inspect(bytes.NewReader(data))
+		tAssertNil(t, err)
+		tAssert(t, info.Flags&FlagICCP != 0, "ICCP flag not set for", cs.desc)
+
+		icc, err := GetMetadata(data, "ICCP")
+		tAssertNil(t, err)
+		tAssertEQ(t, iccProfile(cs.colorSpace), icc)
+		tAssertEQ(t, len(icc), int(binary.BigEndian.Uint32(icc)))
+		tAssertEQ(t, "mntrRGB XYZ ", string(icc[12:24]))
+		tAssertEQ(t, "acsp", string(icc[36:40]))
+
+		// Every tag lies within the profile, 4-byte aligned.
+		tags := make(map[string][]byte)
+		n := int(binary.BigEndian.Uint32(icc[128:]))
+		for i := 0; i < n; i++ {
+			entry := icc[132+12*i:]
+			offset := int(binary.BigEndian.Uint32(entry[4:]))
+			size := int(binary.BigEndian.Uint32(entry[8:]))
+			tAssert(t, offset%4 == 0 && offset+size <= len(icc), "tag", string(entry[:4]), "out of bounds")
+			tags[string(entry[:4])] = icc[offset : offset+size]
+		}
+		for _, sig := range []string{"desc", "cprt", "wtpt", "chad", "rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"} {
+			tAssert(t, tags[sig] != nil, "tag", sig, "missing")
+		}
+		desc := tags["desc"]
+		text := make([]byte, 0, len(cs.desc))
+		for i := 28; i+1 < len(desc); i += 2 {
+			text = append(text, desc[i+1])
+		}
+		tAssertEQ(t, cs.desc, string(text))
+
+		// The tag only records the interpretation, the pixels are unchanged.
+		decoded, err := DecodeRGBA(data)
+		tAssertNil(t, err)
+		tAssert(t, averageDelta(m, decoded) < 4, "colors shifted by", averageDelta(m, decoded))
+	}
+
+	var buf bytes.Buffer
+	err = Encode(&buf, m, &Options{ColorSpace: ColorSpaceBT709, ICCProfile: []byte("profile")})
+	tAssert(t, err != nil, "color space combined with an ICC profile")
+	err = Encode(&buf, m, &Options{ColorSpace: ColorSpaceBT709 + 1})
+	tAssert(t, err != nil, "invalid color space accepted")
+}
+
+func TestEncode_MaxOutputBytes(t *testing.T) {
+	m := tNoiseImage(128, 128)
+	for _, opt := range []Options{
+		{Lossless: true},
+		{Quality: 90},
+		{Quality: 90, SharpYUV: true},
+	} {
+		data, err := EncodeStill(m, &opt)
+		tAssertNil(t, err)
+
+		opt.MaxOutputBytes = len(data)
+		exact, err := EncodeStill(m, &opt)
+		tAssertNil(t, err)
+		tAssertEQ(t, data, exact)
+
+		opt.MaxOutputBytes = len(data) - 1
+		_, err = EncodeStill(m, &opt)
+		tAssert(t, errors.Is(err, ErrOutputTooLarge), "output of", len(data), "bytes over the limit:", err)
+
+		// The metadata counts toward the limit.
+		opt.MaxOutputBytes = len(data) + 8
+		opt.XMP = make([]byte, 64)
+		_, err = EncodeStill(m, &opt)
+		tAssert(t, errors.Is(err, ErrOutputTooLarge), "metadata over the limit:", err)
+	}
+
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, 64, 64), image.YCbCrSubsampleRatio420)
+	_, err := EncodeStill(ycbcr, &Options{Quality: 90, MaxOutputBytes: 16})
+	tAssert(t, errors.Is(err, ErrOutputTooLarge), "YCbCr output over the limit:", err)
+
+	_, err = EncodeStill(m, &Options{Quality: 90, MaxOutputBytes: -1})
+	tAssert(t, err != nil, "negative limit accepted")
+}
+
+func TestEncode_KeepAlpha(t *testing.T) {
+	opaque := tNoiseImage(33, 17)
+	for _, opt := range []Options{
+		{Quality: 80},
+		{Lossless: true},
+		{Quality: 80, XMP: []byte("<x/>")},
+	} {
+		// The alpha of opaque images is left out by default.
+		data, err := EncodeStill(opaque, &opt)
+		tAssertNil(t, err)
+		info, err := Inspect(bytes.NewReader(data))
+		tAssertNil(t, err)
+		tAssertEQ(t, 0, info.Chunks["ALPH"])
+		tAssertEQ(t, uint32(0), info.Flags&FlagAlpha)
+		_, _, hasAlpha, _, err := parseHeader(data)
+		tAssertNil(t, err)
+		tAssert(t, !hasAlpha, "opaque image encoded with alpha:", opt)
+
+		opt.KeepAlpha = true
+		kept, err := EncodeStill(opaque, &opt)
+		tAssertNil(t, err)
+		tAssertNil(t, Validate(kept))
+		_, _, hasAlpha, _, err = parseHeader(kept)
+		tAssertNil(t, err)
+		tAssert(t, hasAlpha, "alpha not kept:", opt)
+		if !opt.Lossless {
+			info, err = Inspect(bytes.NewReader(kept))
+			tAssertNil(t, err)
+			tAssert(t, info.Chunks["ALPH"] > 0 && info.Chunks["ALPH"] < 64, "ALPH chunk of", info.Chunks["ALPH"], "bytes")
+		}
+
+		// The pixels are the same, with opaque alpha.
+		want, err := DecodeRGBA(data)
+		tAssertNil(t, err)
+		got, err := DecodeNRGBA(bytes.NewReader(kept))
+		tAssertNil(t, err)
+		tAssertEQ(t, want.Pix, got.Pix)
+	}
+}
+
+func TestEncode_shortBuffer(t *testing.T) {
+	// A MemP image whose stride runs past its pixels.
+	m := NewMemPImage(image.Rect(0, 0, 16, 16), 4, reflect.Uint8)
+	m.XStride *= 2
+	tAssert(t, Encode(io.Discard, m, &Options{Lossless: true}) != nil, "stride past the pixels accepted")
+	m.XStride = 8
+	tAssert(t, Encode(io.Discard, m, &Options{Lossless: true}) != nil, "stride shorter than a row accepted")
+
+	config, err := webpConfigCreate(&Options{Lossless: true})
+	tAssertNil(t, err)
+	pix := make([]byte, 80*16)
+	for _, v := range []struct {
+		channels, stride, size int
+	}{
+		{channelsBGRA, 32, len(pix)},
+		{channelsBGRA, 64, 15*64 + 63},
+		{3, 64, 15*64 + 47},
+		{1, 15, len(pix)},
+	} {
+		_, err := webpEncodeWithConfigBuffer(&config, v.channels, pix[:v.size], 16, 16, v.stride, 0)
+		tAssert(t, err != nil, "channels", v.channels, "stride", v.stride, "size", v.size, "accepted")
+	}
+	buf, err := webpEncodeWithConfigBuffer(&config, channelsBGRA, pix[:15*80+64], 16, 16, 80, 0)
+	tAssertNil(t, err)
+	buf.free()
+}